@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long reads are cached when no TTL is configured
+const DefaultTTL = 30 * time.Second
+
+// Cache keeps encoded values for a limited time. LRU keeps them in memory; an implementation
+// backed by a shared cache such as Redis lets several instances of the service share entries
+// and invalidations.
+type Cache interface {
+	// Get returns the value of a key that hasn't expired
+	Get(key string) ([]byte, bool)
+	// Set stores the value of a key for ttl
+	Set(key string, value []byte, ttl time.Duration)
+	// Delete removes keys, ignoring those that aren't cached
+	Delete(keys ...string)
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// LRU is an in-memory Cache holding a limited number of entries, evicting the least recently
+// used one when full. Expired entries are removed when they are next looked up or evicted.
+type LRU struct {
+	size    int
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+// NewLRU returns an empty cache of size entries, 1024 if size isn't positive
+func NewLRU(size int) *LRU {
+	if size <= 0 {
+		size = 1024
+	}
+	return &LRU{size: size, order: list.New(), entries: make(map[string]*list.Element), now: time.Now}
+}
+
+// Get returns the value of a key that hasn't expired, marking it recently used
+func (c *LRU) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores the value of a key for ttl, evicting the least recently used entry if needed
+func (c *LRU) Set(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*lruEntry)
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes keys
+func (c *LRU) Delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// Len returns the number of entries, expired ones included
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
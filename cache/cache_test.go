@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(2)
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	c.Get("a")
+	c.Set("c", []byte("3"), time.Minute)
+	_, ok := c.Get("b")
+	assert.False(t, ok, "b was used least recently")
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", string(v), "they should be equal")
+	assert.Equal(t, 2, c.Len(), "they should be equal")
+
+	c.Delete("a", "missing")
+	_, ok = c.Get("a")
+	assert.False(t, ok, "a was deleted")
+}
+
+func TestLRUExpires(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewLRU(0)
+	c.now = func() time.Time { return now }
+	c.Set("a", []byte("1"), time.Minute)
+	now = now.Add(59 * time.Second)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	now = now.Add(time.Second)
+	_, ok = c.Get("a")
+	assert.False(t, ok, "a has expired")
+	assert.Equal(t, 0, c.Len(), "expired entries are removed on lookup")
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+)
+
+// entry is a cached read along with the time it was cached. Writes invalidate the entries they
+// affect, so the data hasn't changed since then and the time serves as its last modification.
+type entry struct {
+	Modified time.Time       `json:"modified"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// Store caches the user reads of a store and invalidates them on the writes going through it.
+// Writes that bypass it, or a read racing a write, can leave an entry stale for at most the TTL.
+type Store struct {
+	domain.Store
+	cache     Cache
+	namespace string
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// NewStore returns store with its user reads cached in c for ttl. Keys are prefixed with the
+// namespace, so that the stores of several tenants can share a cache.
+func NewStore(store domain.Store, c Cache, namespace string, ttl time.Duration) *Store {
+	return &Store{Store: store, cache: c, namespace: namespace, ttl: ttl, now: time.Now}
+}
+
+func (s *Store) usersKey() string {
+	return s.namespace + ":users"
+}
+
+func (s *Store) userKey(i int) string {
+	return s.namespace + ":user:" + strconv.Itoa(i)
+}
+
+// ListUsers returns all users, from the cache if possible
+func (s *Store) ListUsers(ctx context.Context) ([]entities.User, error) {
+	var list []entities.User
+	if s.get(s.usersKey(), &list) {
+		return list, nil
+	}
+	list, err := s.Store.ListUsers(ctx)
+	if err != nil {
+		return list, err
+	}
+	s.set(s.usersKey(), list)
+	return list, nil
+}
+
+// GetUser returns a user, from the cache if possible
+func (s *Store) GetUser(ctx context.Context, i int) (entities.User, error) {
+	var u entities.User
+	if s.get(s.userKey(i), &u) {
+		return u, nil
+	}
+	u, err := s.Store.GetUser(ctx, i)
+	if err != nil {
+		return u, err
+	}
+	s.set(s.userKey(i), u)
+	return u, nil
+}
+
+// AddUser adds a user and invalidates the list of users
+func (s *Store) AddUser(ctx context.Context, u entities.User) (entities.User, error) {
+	u, err := s.Store.AddUser(ctx, u)
+	s.cache.Delete(s.usersKey(), s.userKey(u.ID))
+	return u, err
+}
+
+// UpdateUser updates a user and invalidates it
+func (s *Store) UpdateUser(ctx context.Context, u entities.User) (entities.User, error) {
+	u, err := s.Store.UpdateUser(ctx, u)
+	s.cache.Delete(s.usersKey(), s.userKey(u.ID))
+	return u, err
+}
+
+// DeleteUser deletes a user and invalidates it
+func (s *Store) DeleteUser(ctx context.Context, i int, opts storage.DeleteOptions) (storage.Deletion, error) {
+	d, err := s.Store.DeleteUser(ctx, i, opts)
+	if !opts.DryRun {
+		s.cache.Delete(s.usersKey(), s.userKey(i))
+	}
+	return d, err
+}
+
+// AddRecords adds a batch of users and invalidates the list of users
+func (s *Store) AddRecords(ctx context.Context, records []storage.Record) ([]storage.Record, error) {
+	added, err := s.Store.AddRecords(ctx, records)
+	keys := []string{s.usersKey()}
+	for _, r := range added {
+		if !r.Detached() {
+			keys = append(keys, s.userKey(r.User.ID))
+		}
+	}
+	s.cache.Delete(keys...)
+	return added, err
+}
+
+// UsersModified returns when the list of users last changed, as far as the cache knows
+func (s *Store) UsersModified() (time.Time, bool) {
+	return s.modified(s.usersKey())
+}
+
+// UserModified returns when a user last changed, as far as the cache knows
+func (s *Store) UserModified(i int) (time.Time, bool) {
+	return s.modified(s.userKey(i))
+}
+
+func (s *Store) modified(key string) (time.Time, bool) {
+	data, ok := s.cache.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return time.Time{}, false
+	}
+	return e.Modified, true
+}
+
+// get decodes a cached value into v, reporting whether there was one
+func (s *Store) get(key string, v interface{}) bool {
+	data, ok := s.cache.Get(key)
+	if !ok {
+		return false
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		log.Println(err)
+		return false
+	}
+	if err := json.Unmarshal(e.Value, v); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (s *Store) set(key string, v interface{}) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	data, err := json.Marshal(entry{Modified: s.now().UTC(), Value: value})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.cache.Set(key, data, s.ttl)
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts the user reads reaching the store
+type countingStore struct {
+	domain.Store
+	reads int
+}
+
+func (s *countingStore) ListUsers(ctx context.Context) ([]entities.User, error) {
+	s.reads++
+	return s.Store.ListUsers(ctx)
+}
+
+func (s *countingStore) GetUser(ctx context.Context, i int) (entities.User, error) {
+	s.reads++
+	return s.Store.GetUser(ctx, i)
+}
+
+func TestStoreCachesReads(t *testing.T) {
+	db := &countingStore{Store: storage.NewMockDB()}
+	s := NewStore(db, NewLRU(0), "default", time.Minute)
+	list, _ := s.ListUsers(t.Context())
+	list, _ = s.ListUsers(t.Context())
+	assert.Equal(t, 2, len(list), "they should be equal")
+	u, _ := s.GetUser(t.Context(), 1)
+	u, _ = s.GetUser(t.Context(), 1)
+	assert.Equal(t, "Jane", u.FirstName, "they should be equal")
+	assert.Equal(t, 2, db.reads, "repeated reads are served from the cache")
+
+	_, err := s.GetUser(t.Context(), 10)
+	_, err = s.GetUser(t.Context(), 10)
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, db.reads, "failed reads aren't cached")
+
+	_, ok := s.UserModified(1)
+	assert.True(t, ok)
+	_, ok = s.UserModified(0)
+	assert.False(t, ok, "user 0 isn't cached")
+}
+
+func TestStoreInvalidatesOnWrites(t *testing.T) {
+	c := NewLRU(0)
+	db := &countingStore{Store: storage.NewMockDB()}
+	s := NewStore(db, c, "default", time.Minute)
+	other := NewStore(storage.NewMockDB(), c, "acme", time.Minute)
+	s.ListUsers(t.Context())
+	s.GetUser(t.Context(), 1)
+	other.ListUsers(t.Context())
+
+	u, _ := s.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	list, _ := s.ListUsers(t.Context())
+	assert.Equal(t, 3, len(list), "they should be equal")
+
+	u.FirstName = "Applejack"
+	s.UpdateUser(t.Context(), u)
+	u, _ = s.GetUser(t.Context(), u.ID)
+	assert.Equal(t, "Applejack", u.FirstName, "they should be equal")
+
+	s.DeleteUser(t.Context(), 1, storage.DeleteOptions{})
+	_, err := s.GetUser(t.Context(), 1)
+	assert.NotNil(t, err)
+	list, _ = s.ListUsers(t.Context())
+	assert.Equal(t, 2, len(list), "they should be equal")
+
+	_, ok := other.UsersModified()
+	assert.True(t, ok, "the writes of a namespace leave the others cached")
+}
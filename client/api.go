@@ -0,0 +1,285 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/resilience"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/kostiamol/go-rest-api-template/webhook"
+	"github.com/palantir/stacktrace"
+)
+
+// Health is the service name and version reported by GET /health
+type Health struct {
+	SvcName string `json:"svcName"`
+	Version string `json:"version"`
+}
+
+// Readiness tells whether the service can take traffic
+type Readiness struct {
+	// ready or unavailable
+	Status string `json:"status"`
+	// State of the circuit breaker guarding the storage
+	Storage *resilience.BreakerState `json:"storage,omitempty"`
+}
+
+// UserRecord is a user together with its passports, the item of batch imports
+type UserRecord struct {
+	entities.User
+	Passports []entities.Passport `json:"passports,omitempty"`
+}
+
+// BatchResult reports the outcome of a single item of a batch import
+type BatchResult struct {
+	// Position of the item in the request
+	Index int `json:"index"`
+	// HTTP status code of the item
+	Status string `json:"status"`
+	// The error message
+	Message string `json:"message,omitempty"`
+	// The created user
+	User *UserRecord `json:"user,omitempty"`
+}
+
+// BatchResults holds the per-item results of a batch import
+type BatchResults struct {
+	// Number of created users
+	Created int `json:"created"`
+	// Number of rejected items
+	Failed int `json:"failed"`
+	// Per-item results, in request order
+	Results []BatchResult `json:"results"`
+}
+
+// GraphQLError is an entry of the errors list of a GraphQL response
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// GraphQLResponse is the result of a GraphQL query, whose data is left to the caller to decode
+type GraphQLResponse struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors []GraphQLError  `json:"errors,omitempty"`
+}
+
+// Export formats
+const (
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+	ExportCSV    = "csv"
+)
+
+func userPath(uid int) string {
+	return "/users/" + strconv.Itoa(uid)
+}
+
+// Health reports the name and version of the service
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	var h Health
+	err := c.do(ctx, request{method: http.MethodGet, path: "/health"}, &h)
+	return h, err
+}
+
+// Ready reports whether the service can take traffic. A service that isn't ready answers with
+// an *Error of status 503, along with its readiness.
+func (c *Client) Ready(ctx context.Context) (Readiness, error) {
+	var r Readiness
+	err := c.do(ctx, request{method: http.MethodGet, path: "/ready"}, &r)
+	return r, err
+}
+
+// ListUsers returns all users
+func (c *Client) ListUsers(ctx context.Context) ([]entities.User, error) {
+	var list struct {
+		Users []entities.User `json:"users"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/users"}, &list)
+	return list.Users, err
+}
+
+// GetUser returns a user
+func (c *Client) GetUser(ctx context.Context, uid int) (entities.User, error) {
+	var u entities.User
+	err := c.do(ctx, request{method: http.MethodGet, path: userPath(uid)}, &u)
+	return u, err
+}
+
+// CreateUser creates a user and returns it with its UID
+func (c *Client) CreateUser(ctx context.Context, u entities.User) (entities.User, error) {
+	var created entities.User
+	err := c.do(ctx, request{method: http.MethodPost, path: "/users", body: u}, &created)
+	return created, err
+}
+
+// UpdateUser replaces the user with the UID of u
+func (c *Client) UpdateUser(ctx context.Context, u entities.User) (entities.User, error) {
+	var updated entities.User
+	err := c.do(ctx, request{method: http.MethodPut, path: userPath(u.ID), body: u}, &updated)
+	return updated, err
+}
+
+// DeleteUser deletes a user, its passports being handled according to the delete policy of the
+// service
+func (c *Client) DeleteUser(ctx context.Context, uid int) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: userPath(uid)}, nil)
+}
+
+// DeleteUserDryRun reports what deleting a user would do without deleting it
+func (c *Client) DeleteUserDryRun(ctx context.Context, uid int) (storage.Deletion, error) {
+	var d storage.Deletion
+	err := c.do(ctx, request{method: http.MethodDelete, path: userPath(uid), query: url.Values{"dryRun": {"true"}}}, &d)
+	return d, err
+}
+
+// BatchUsers creates many users with their passports. With atomic either every record is created
+// or none is. A rejected atomic batch is reported as an *Error of status 422, along with the
+// results telling which records failed.
+func (c *Client) BatchUsers(ctx context.Context, records []UserRecord, atomic bool) (BatchResults, error) {
+	var res BatchResults
+	if records == nil {
+		records = []UserRecord{}
+	}
+	r := request{method: http.MethodPost, path: "/users:batch", body: records}
+	if atomic {
+		r.query = url.Values{"atomic": {"true"}}
+	}
+	err := c.do(ctx, r, &res)
+	return res, err
+}
+
+// Export streams all users and passports in one of the export formats, for the caller to close
+func (c *Client) Export(ctx context.Context, format string) (io.ReadCloser, error) {
+	r := request{method: http.MethodGet, path: "/export", query: url.Values{"format": {format}}}
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, responseError(resp.StatusCode, data, nil)
+	}
+	return resp.Body, nil
+}
+
+// GraphQL runs a GraphQL query or mutation. Errors of the query itself are returned in the
+// response rather than as an error.
+func (c *Client) GraphQL(ctx context.Context, query string, variables map[string]interface{}) (GraphQLResponse, error) {
+	var res GraphQLResponse
+	body := map[string]interface{}{"query": query, "variables": variables}
+	err := c.do(ctx, request{method: http.MethodPost, path: "/graphql", body: body}, &res)
+	if err != nil && len(res.Errors) > 0 {
+		// a query the service rejected as a whole
+		return res, nil
+	}
+	return res, err
+}
+
+// GraphQLSchema returns the GraphQL schema of the service in the schema definition language
+func (c *Client) GraphQLSchema(ctx context.Context) (string, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/graphql"})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", stacktrace.Propagate(err, "can't read the GraphQL schema")
+	}
+	if resp.StatusCode >= 400 {
+		return "", responseError(resp.StatusCode, data, nil)
+	}
+	return string(data), nil
+}
+
+// ListWebhooks returns the webhook subscriptions, without their secrets
+func (c *Client) ListWebhooks(ctx context.Context) ([]webhook.Subscription, error) {
+	var list struct {
+		Webhooks []webhook.Subscription `json:"webhooks"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/webhooks"}, &list)
+	return list.Webhooks, err
+}
+
+// CreateWebhook subscribes a URL to events and returns the subscription with its secret
+func (c *Client) CreateWebhook(ctx context.Context, s webhook.Subscription) (webhook.Subscription, error) {
+	var created webhook.Subscription
+	err := c.do(ctx, request{method: http.MethodPost, path: "/webhooks", body: s}, &created)
+	return created, err
+}
+
+// DeleteWebhook removes a webhook subscription
+func (c *Client) DeleteWebhook(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/webhooks/" + url.PathEscape(id)}, nil)
+}
+
+// ListDeadLetters returns the events that couldn't be delivered to a webhook
+func (c *Client) ListDeadLetters(ctx context.Context) ([]webhook.DeadLetter, error) {
+	var list struct {
+		DeadLetters []webhook.DeadLetter `json:"deadLetters"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/webhooks/deadletters"}, &list)
+	return list.DeadLetters, err
+}
+
+// ListUserPassports returns the passports of a user
+func (c *Client) ListUserPassports(ctx context.Context, uid int) ([]entities.Passport, error) {
+	return c.listPassports(ctx, request{method: http.MethodGet, path: userPath(uid) + "/passports"})
+}
+
+// ListPassports returns all passports
+func (c *Client) ListPassports(ctx context.Context) ([]entities.Passport, error) {
+	return c.listPassports(ctx, request{method: http.MethodGet, path: "/passports"})
+}
+
+// ListExpiringPassports returns the passports expiring before a time, soonest first
+func (c *Client) ListExpiringPassports(ctx context.Context, before time.Time) ([]entities.Passport, error) {
+	query := url.Values{"expiringBefore": {before.Format(time.RFC3339)}}
+	return c.listPassports(ctx, request{method: http.MethodGet, path: "/passports", query: query})
+}
+
+func (c *Client) listPassports(ctx context.Context, r request) ([]entities.Passport, error) {
+	var list struct {
+		Passports []entities.Passport `json:"passports"`
+	}
+	err := c.do(ctx, r, &list)
+	return list.Passports, err
+}
+
+// GetPassport returns a passport
+func (c *Client) GetPassport(ctx context.Context, id string) (entities.Passport, error) {
+	var p entities.Passport
+	err := c.do(ctx, request{method: http.MethodGet, path: "/passports/" + url.PathEscape(id)}, &p)
+	return p, err
+}
+
+// CreateUserPassport adds a passport to a user
+func (c *Client) CreateUserPassport(ctx context.Context, uid int, p entities.Passport) (entities.Passport, error) {
+	var created entities.Passport
+	err := c.do(ctx, request{method: http.MethodPost, path: userPath(uid) + "/passports", body: p}, &created)
+	return created, err
+}
+
+// UpdatePassport replaces the passport with the ID of p
+func (c *Client) UpdatePassport(ctx context.Context, p entities.Passport) (entities.Passport, error) {
+	if p.ID == "" {
+		return p, stacktrace.NewError("passport has no id")
+	}
+	var updated entities.Passport
+	err := c.do(ctx, request{method: http.MethodPut, path: "/passports/" + url.PathEscape(p.ID), body: p}, &updated)
+	return updated, err
+}
+
+// DeletePassport deletes a passport
+func (c *Client) DeletePassport(ctx context.Context, id string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: "/passports/" + url.PathEscape(id)}, nil)
+}
@@ -0,0 +1,260 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/tenant"
+	"github.com/palantir/stacktrace"
+)
+
+// Options configures a Client; zero values are replaced by defaults
+type Options struct {
+	// Client sending the requests, http.DefaultClient by default
+	HTTPClient *http.Client
+	// Bearer token sent with every request, which may carry the tenant
+	Token string
+	// Tenant whose data is accessed, sent in the X-Tenant-ID header
+	Tenant string
+	// Requests are given up after this many attempts
+	Attempts int
+	// Upper bound of the delay before the first retry, doubled for every further retry
+	InitialBackoff time.Duration
+	// Upper bound of the delay between retries
+	MaxBackoff time.Duration
+}
+
+// Client calls the REST API of the service. Reads are retried when the service can't be reached
+// or answers 502, 503 or 504. PUT and DELETE are only retried when no connection could be made:
+// the service may still apply a write it timed out on, and a repeated DELETE would then report
+// 404 for a deletion that succeeded. POST is never retried.
+type Client struct {
+	base *url.URL
+	opts Options
+}
+
+// New returns a client of the service listening at baseURL, e.g. http://localhost:3001
+func New(baseURL string, opts Options) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, stacktrace.NewError("invalid base URL %q", baseURL)
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 3
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 2 * time.Second
+	}
+	return &Client{base: base, opts: opts}, nil
+}
+
+// Error is a failure reported by the service, along with the message of its status response
+type Error struct {
+	// HTTP status code of the response
+	StatusCode int
+	// The status message
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Message
+}
+
+// StatusOf returns the HTTP status code of an error reported by the service, 0 for other errors
+// such as the service being unreachable
+func StatusOf(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether the service answered 404 Not Found
+func IsNotFound(err error) bool {
+	return StatusOf(err) == http.StatusNotFound
+}
+
+// IsConflict reports whether the service refused a change breaking the business rules with
+// 409 Conflict
+func IsConflict(err error) bool {
+	return StatusOf(err) == http.StatusConflict
+}
+
+// IsUnavailable reports whether the service answered 503 Service Unavailable, e.g. because its
+// storage is failing
+func IsUnavailable(err error) bool {
+	return StatusOf(err) == http.StatusServiceUnavailable
+}
+
+// status is the body of the error responses of the service
+type status struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// request describes a call of the API
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        interface{}
+	contentType string
+	header      http.Header
+}
+
+// do sends a request and decodes a JSON response into out, unless out is nil. A response with an
+// error status is returned as an *Error; its body is still decoded into out when it has the shape
+// of a successful response, as the batch and readiness responses do.
+func (c *Client) do(ctx context.Context, r request, out interface{}) error {
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't read the response of %s %s", r.method, r.path)
+	}
+	if resp.StatusCode >= 400 {
+		return responseError(resp.StatusCode, data, out)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return stacktrace.Propagate(err, "can't decode the response of %s %s", r.method, r.path)
+	}
+	return nil
+}
+
+// responseError turns an error response into an *Error
+func responseError(code int, data []byte, out interface{}) error {
+	e := &Error{StatusCode: code}
+	var s status
+	if json.Unmarshal(data, &s) == nil && s.Message != "" {
+		e.Message = s.Message
+	} else if out != nil {
+		json.Unmarshal(data, out)
+	}
+	return e
+}
+
+// send sends a request, retrying it if it can be repeated, and returns the response for the
+// caller to close
+func (c *Client) send(ctx context.Context, r request) (*http.Response, error) {
+	var body []byte
+	if r.body != nil {
+		var err error
+		if body, err = json.Marshal(r.body); err != nil {
+			return nil, stacktrace.Propagate(err, "can't encode the request of %s %s", r.method, r.path)
+		}
+	}
+	retryable := r.method != http.MethodPost
+	for attempt := 1; ; attempt++ {
+		resp, err := c.sendOnce(ctx, r, body)
+		if !retryable || attempt >= c.opts.Attempts || ctx.Err() != nil || !retry(r.method, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if err == nil {
+				err = &Error{StatusCode: resp.StatusCode}
+			}
+			return nil, stacktrace.Propagate(err, "gave up retrying: %v", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) sendOnce(ctx context.Context, r request, body []byte) (*http.Response, error) {
+	u := *c.base
+	u.Path += r.path
+	u.RawQuery = r.query.Encode()
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, u.String(), reader)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "can't build the request of %s %s", r.method, r.path)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+	if c.opts.Tenant != "" {
+		req.Header.Set(tenant.Header, c.opts.Tenant)
+	}
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "%s %s failed", r.method, r.path)
+	}
+	return resp, nil
+}
+
+// retry reports whether a failed attempt of a request with method is worth repeating. Writes are
+// only repeated when they can't have reached the service.
+func retry(method string, resp *http.Response, err error) bool {
+	if method != http.MethodGet && method != http.MethodHead {
+		return err != nil && dialFailed(err)
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// dialFailed reports whether a request failed before a connection was made, so that nothing of
+// it was sent
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(stacktrace.RootCause(err), &opErr) && opErr.Op == "dial"
+}
+
+// backoff returns a random delay of up to an exponentially growing ceiling before the given retry
+func (c *Client) backoff(attempt int) time.Duration {
+	ceiling := c.opts.MaxBackoff
+	if shifted := c.opts.InitialBackoff << uint(attempt-1); attempt < 32 && shifted > 0 && shifted < ceiling {
+		ceiling = shifted
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/svc"
+	"github.com/kostiamol/go-rest-api-template/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+// newServer runs the service on test data and returns a client of it
+func newServer(t *testing.T) (*Client, svc.Context) {
+	ctx := svc.NewContext()
+	t.Cleanup(ctx.Close)
+	srv := httptest.NewServer(svc.NewHandler(ctx))
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, Options{HTTPClient: &http.Client{Timeout: 5 * time.Second}})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return c, ctx
+}
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestUsers(t *testing.T) {
+	c, _ := newServer(t)
+
+	h, err := c.Health(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, "0.0.0", h.Version, "they should be equal")
+	r, err := c.Ready(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, "ready", r.Status, "they should be equal")
+
+	list, err := c.ListUsers(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list), "they should be equal")
+
+	u, err := c.CreateUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack", DateOfBirth: date("1990-01-01")})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, u.ID, "they should be equal")
+	u.LocationOfBirth = "Ponyville"
+	u, err = c.UpdateUser(t.Context(), u)
+	assert.Nil(t, err)
+	u, err = c.GetUser(t.Context(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Ponyville", u.LocationOfBirth, "they should be equal")
+
+	_, err = c.CreateUser(t.Context(), entities.User{FirstName: "Apple"})
+	assert.Equal(t, http.StatusUnprocessableEntity, StatusOf(err), "they should be equal")
+
+	d, err := c.DeleteUserDryRun(t.Context(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, true, d.DryRun, "they should be equal")
+	assert.Nil(t, c.DeleteUser(t.Context(), 2))
+	_, err = c.GetUser(t.Context(), 2)
+	assert.True(t, IsNotFound(err))
+	assert.Equal(t, "can't find user", err.Error(), "they should be equal")
+}
+
+func TestPassports(t *testing.T) {
+	c, _ := newServer(t)
+
+	p, err := c.CreateUserPassport(t.Context(), 1, entities.Passport{ID: "123456789", Authority: "HMPO", DateOfIssue: date("2020-01-01"), DateOfExpiry: date("2030-01-01")})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, p.UserID, "they should be equal")
+	_, err = c.CreateUserPassport(t.Context(), 1, entities.Passport{ID: "987654321", Authority: "HMPO", DateOfIssue: date("2021-01-01")})
+	assert.True(t, IsConflict(err))
+
+	list, err := c.ListUserPassports(t.Context(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list), "they should be equal")
+	list, err = c.ListPassports(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list), "they should be equal")
+	list, err = c.ListExpiringPassports(t.Context(), date("2025-01-01"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(list), "they should be equal")
+
+	p.Authority = "HMPO"
+	p.DateOfExpiry = date("2031-01-01")
+	_, err = c.UpdatePassport(t.Context(), p)
+	assert.Nil(t, err)
+	p, err = c.GetPassport(t.Context(), "123456789")
+	assert.Nil(t, err)
+	assert.Equal(t, date("2031-01-01"), p.DateOfExpiry, "they should be equal")
+
+	assert.Nil(t, c.DeletePassport(t.Context(), "123456789"))
+	_, err = c.GetPassport(t.Context(), "123456789")
+	assert.True(t, IsNotFound(err))
+}
+
+func TestBatchAndExport(t *testing.T) {
+	c, _ := newServer(t)
+
+	res, err := c.BatchUsers(t.Context(), []UserRecord{
+		{User: entities.User{FirstName: "Apple", LastName: "Jack"}},
+		{User: entities.User{FirstName: "Rarity"}},
+	}, true)
+	assert.Equal(t, http.StatusUnprocessableEntity, StatusOf(err), "they should be equal")
+	assert.Equal(t, 1, res.Failed, "they should be equal")
+	assert.Equal(t, 2, len(res.Results), "they should be equal")
+
+	res, err = c.BatchUsers(t.Context(), []UserRecord{{User: entities.User{FirstName: "Apple", LastName: "Jack"}}}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, res.Created, "they should be equal")
+
+	body, err := c.Export(t.Context(), ExportNDJSON)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"), "they should be equal")
+
+	_, err = c.Export(t.Context(), "xml")
+	assert.Equal(t, http.StatusBadRequest, StatusOf(err), "they should be equal")
+}
+
+func TestGraphQLAndWebhooks(t *testing.T) {
+	c, _ := newServer(t)
+
+	res, err := c.GraphQL(t.Context(), `query($id: Int!) { user(id: $id) { firstName } }`, map[string]interface{}{"id": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(res.Errors), "they should be equal")
+	assert.Equal(t, `{"user":{"firstName":"Jane"}}`, string(res.Data), "they should be equal")
+	res, err = c.GraphQL(t.Context(), `{ nope }`, nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, len(res.Errors), "they should not be equal")
+	schema, err := c.GraphQLSchema(t.Context())
+	assert.Nil(t, err)
+	assert.Contains(t, schema, "type Query")
+
+	s, err := c.CreateWebhook(t.Context(), webhook.Subscription{URL: "http://127.0.0.1:1/hook", Events: []string{entities.UserCreated}})
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", s.Secret, "they should not be equal")
+	list, err := c.ListWebhooks(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list), "they should be equal")
+	_, err = c.ListDeadLetters(t.Context())
+	assert.Nil(t, err)
+	assert.Nil(t, c.DeleteWebhook(t.Context(), s.ID))
+	assert.True(t, IsNotFound(c.DeleteWebhook(t.Context(), s.ID)))
+}
+
+func TestStreamEvents(t *testing.T) {
+	c, ctx := newServer(t)
+
+	stream, err := c.StreamEvents(t.Context(), []string{"user"}, "")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer stream.Close()
+	ctx.DB.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	e, err := stream.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, entities.UserCreated, e.Type, "they should be equal")
+	assert.Equal(t, e.ID, stream.LastEventID(), "they should be equal")
+	var u entities.User
+	data, _ := json.Marshal(e.Data)
+	json.Unmarshal(data, &u)
+	assert.Equal(t, "Apple", u.FirstName, "they should be equal")
+}
+
+func TestRetry(t *testing.T) {
+	ctx := svc.NewContext()
+	defer ctx.Close()
+	router := svc.NewRouter(ctx)
+	var calls, failures int32
+	failures = 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		router.ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	c, _ := New(srv.URL, Options{InitialBackoff: time.Millisecond})
+
+	list, err := c.ListUsers(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list), "they should be equal")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "they should be equal")
+
+	// writes that aren't idempotent are sent once
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&failures, 1)
+	_, err = c.CreateUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	assert.True(t, IsUnavailable(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "they should be equal")
+
+	// so are writes the service may have applied
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&failures, 1)
+	err = c.DeleteUser(t.Context(), 0)
+	assert.True(t, IsUnavailable(err))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "they should be equal")
+
+	// retries give up after the last attempt
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&failures, 5)
+	_, err = c.GetUser(t.Context(), 0)
+	assert.True(t, IsUnavailable(err))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "they should be equal")
+
+	_, err = New("localhost:3001", Options{})
+	assert.NotNil(t, err)
+}
+
+func TestRetryWritesOnlyWhenUnsent(t *testing.T) {
+	// a closed listener refuses connections, so nothing is sent
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	l.Close()
+	var attempts int32
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&attempts, 1)
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	c, _ := New("http://"+l.Addr().String(), Options{HTTPClient: client, InitialBackoff: time.Millisecond})
+	err := c.DeleteUser(t.Context(), 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts), "they should be equal")
+
+	// a connection dropped once the request was sent isn't retried
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer srv.Close()
+	atomic.StoreInt32(&attempts, 0)
+	c, _ = New(srv.URL, Options{InitialBackoff: time.Millisecond})
+	_, err = c.UpdateUser(t.Context(), entities.User{ID: 1, FirstName: "Apple", LastName: "Jack"})
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts), "they should be equal")
+	_, err = c.GetUser(t.Context(), 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&attempts), "reads are retried")
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/palantir/stacktrace"
+)
+
+// EventStream reads the server-sent events of GET /events
+type EventStream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	lastID string
+}
+
+// StreamEvents subscribes to the changes of users and passports, of the given entity types or of
+// all when none are given. A stream resumed with the LastEventID of a previous one starts with the
+// events that were missed in between.
+func (c *Client) StreamEvents(ctx context.Context, types []string, lastEventID string) (*EventStream, error) {
+	r := request{method: http.MethodGet, path: "/events", header: http.Header{}}
+	if len(types) > 0 {
+		r.query = url.Values{"types": {strings.Join(types, ",")}}
+	}
+	if lastEventID != "" {
+		r.header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return nil, responseError(resp.StatusCode, data, nil)
+	}
+	return &EventStream{body: resp.Body, reader: bufio.NewReader(resp.Body), lastID: lastEventID}, nil
+}
+
+// Next blocks until the next event arrives. It returns io.EOF once the service ends the stream,
+// which it does with clients too slow to keep up; resume with LastEventID.
+func (s *EventStream) Next() (entities.Event, error) {
+	var data []string
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return entities.Event{}, io.EOF
+			}
+			return entities.Event{}, stacktrace.Propagate(err, "can't read the event stream")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && len(data) > 0:
+			var e entities.Event
+			if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &e); err != nil {
+				return e, stacktrace.Propagate(err, "can't decode event")
+			}
+			s.lastID = e.ID
+			return e, nil
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// comments, such as keep-alives, and the id and event fields, which the data repeats,
+		// are skipped
+	}
+}
+
+// LastEventID returns the id of the last event read, from which a new stream can resume
+func (s *EventStream) LastEventID() string {
+	return s.lastID
+}
+
+// Close ends the stream
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
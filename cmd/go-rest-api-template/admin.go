@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/kostiamol/go-rest-api-template/svc"
+	"github.com/palantir/stacktrace"
+)
+
+const usersUsage = `usage: go-rest-api-template users list|get|create|delete
+
+  list                                   list the users
+  get <uid>                              print a user along with its passports
+  create -first-name NAME -last-name NAME [-date-of-birth YYYY-MM-DD] [-location-of-birth PLACE]
+                                         create a user
+  delete [-policy POLICY] [-dry-run] <uid>
+                                         delete a user, restricting, cascading to or detaching
+                                         its passports (USER_DELETE_POLICY by default)
+
+Users are read from and changes saved to the fixtures file, which only holds the users of the
+default tenant. Changes are refused while a server serves the file: it would keep serving the data
+it loaded, and lose the changes when it stops.`
+
+const fixturesUsage = `usage: go-rest-api-template fixtures load|dump
+
+  load <file>    add the users and passports of a file in the fixtures format, under new UIDs
+  dump [file]    write all users and passports in the fixtures format, to stdout by default
+
+Only the users of the default tenant are kept in the fixtures file. Loads are refused while a
+server serves the file.`
+
+// userRecord is a user along with its passports
+type userRecord struct {
+	entities.User
+	Passports []entities.Passport `json:"passports"`
+}
+
+// openStorage loads the fixtures file behind the business rules, as the server does
+func openStorage(cfg config) (svc.Storager, error) {
+	db, err := storage.LoadFixturesIntoMockDB(cfg.Fixtures)
+	if err != nil {
+		return nil, err
+	}
+	return domain.NewService(db, cfg.Authorities), nil
+}
+
+// errLocked is returned by lockFixtures when the fixtures file is locked by another process
+var errLocked = errors.New("the fixtures file is locked")
+
+// saveStorage writes the users and passports back to the fixtures file, which is where the mock
+// storage keeps the data of the default tenant between runs. The file is replaced in one step, so
+// that a failed write leaves the previous data in place. It fails while a server serves the file.
+func saveStorage(ctx context.Context, cfg config, db svc.Storager) error {
+	release, err := lockFixtures(cfg.Fixtures, true)
+	if err == errLocked {
+		return stacktrace.NewError("%s is served by a running server, stop it before changing the data", cfg.Fixtures)
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	var buf bytes.Buffer
+	if err := dumpFixtures(ctx, db, &buf); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(cfg.Fixtures), ".fixtures-*.json")
+	if err != nil {
+		return stacktrace.Propagate(err, "can't save the fixtures file")
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return stacktrace.Propagate(err, "can't save the fixtures file")
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return stacktrace.Propagate(err, "can't save the fixtures file")
+	}
+	if err := tmp.Close(); err != nil {
+		return stacktrace.Propagate(err, "can't save the fixtures file")
+	}
+	if err := os.Rename(tmp.Name(), cfg.Fixtures); err != nil {
+		return stacktrace.Propagate(err, "can't save the fixtures file")
+	}
+	return nil
+}
+
+// dumpFixtures writes all users and passports in the fixtures format
+func dumpFixtures(ctx context.Context, db svc.Storager, out io.Writer) error {
+	users, err := db.ListUsers(ctx)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't list users")
+	}
+	passports, err := db.ListPassports(ctx)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't list passports")
+	}
+	return writeJSON(out, storage.Fixtures{Users: users, Passports: passports})
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// parseUID parses the single UID argument of a command
+func parseUID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, stacktrace.NewError(usersUsage)
+	}
+	uid, err := strconv.Atoi(args[0])
+	if err != nil || uid < 0 {
+		return 0, stacktrace.NewError("UID must be a non-negative number")
+	}
+	return uid, nil
+}
+
+// commandContext returns a context cancelled on interrupt
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// usersCommand runs the users subcommand
+func usersCommand(cfg config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return stacktrace.NewError(usersUsage)
+	}
+	command, args := args[0], args[1:]
+	flags := flag.NewFlagSet("users "+command, flag.ContinueOnError)
+	flags.SetOutput(out)
+	var (
+		firstName       = flags.String("first-name", "", "first name of the user")
+		lastName        = flags.String("last-name", "", "last name of the user")
+		dateOfBirth     = flags.String("date-of-birth", "", "date of birth of the user, YYYY-MM-DD")
+		locationOfBirth = flags.String("location-of-birth", "", "location of birth of the user")
+		policy          = flags.String("policy", string(cfg.DeletePolicy), "restrict, cascade or detach the passports of the user")
+		dryRun          = flags.Bool("dry-run", false, "report what would be deleted without deleting it")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	ctx, stop := commandContext()
+	defer stop()
+	db, err := openStorage(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		list, err := db.ListUsers(ctx)
+		if err != nil {
+			return stacktrace.Propagate(err, "can't list users")
+		}
+		if list == nil {
+			list = []entities.User{}
+		}
+		return writeJSON(out, list)
+	case "get":
+		uid, err := parseUID(args)
+		if err != nil {
+			return err
+		}
+		u, err := db.GetUser(ctx, uid)
+		if err != nil {
+			return stacktrace.Propagate(err, "can't find user %d", uid)
+		}
+		passports, err := db.ListUserPassports(ctx, uid)
+		if err != nil {
+			return stacktrace.Propagate(err, "can't list the passports of user %d", uid)
+		}
+		if passports == nil {
+			passports = []entities.Passport{}
+		}
+		return writeJSON(out, userRecord{User: u, Passports: passports})
+	case "create":
+		if len(args) != 0 || *firstName == "" || *lastName == "" {
+			return stacktrace.NewError(usersUsage)
+		}
+		u := entities.User{ID: -1, FirstName: *firstName, LastName: *lastName, LocationOfBirth: *locationOfBirth}
+		if *dateOfBirth != "" {
+			if u.DateOfBirth, err = time.Parse("2006-01-02", *dateOfBirth); err != nil {
+				return stacktrace.NewError("date of birth must be formatted as YYYY-MM-DD")
+			}
+		}
+		if u, err = db.AddUser(ctx, u); err != nil {
+			return stacktrace.Propagate(err, "can't create user")
+		}
+		if err := saveStorage(ctx, cfg, db); err != nil {
+			return err
+		}
+		return writeJSON(out, u)
+	case "delete":
+		uid, err := parseUID(args)
+		if err != nil {
+			return err
+		}
+		p, err := storage.ParseDeletePolicy(*policy)
+		if err != nil {
+			return err
+		}
+		d, err := db.DeleteUser(ctx, uid, storage.DeleteOptions{Policy: p, DryRun: *dryRun})
+		if err != nil {
+			return stacktrace.Propagate(err, "can't delete user %d", uid)
+		}
+		if !d.DryRun {
+			if err := saveStorage(ctx, cfg, db); err != nil {
+				return err
+			}
+		}
+		return writeJSON(out, d)
+	}
+	return stacktrace.NewError(usersUsage)
+}
+
+// fixturesCommand runs the fixtures subcommand
+func fixturesCommand(cfg config, args []string, out io.Writer) error {
+	if len(args) == 0 || len(args) > 2 {
+		return stacktrace.NewError(fixturesUsage)
+	}
+	ctx, stop := commandContext()
+	defer stop()
+	db, err := openStorage(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "dump" && len(args) == 1:
+		return dumpFixtures(ctx, db, out)
+	case args[0] == "dump":
+		var buf bytes.Buffer
+		if err := dumpFixtures(ctx, db, &buf); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(args[1], buf.Bytes(), 0644); err != nil {
+			return stacktrace.Propagate(err, "can't write %s", args[1])
+		}
+		return nil
+	case args[0] == "load" && len(args) == 2:
+		file, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return stacktrace.Propagate(err, "can't read %s", args[1])
+		}
+		var fixtures storage.Fixtures
+		if err := json.Unmarshal(file, &fixtures); err != nil {
+			return stacktrace.Propagate(err, "can't parse %s", args[1])
+		}
+		users, passports, err := loadFixtures(ctx, db, fixtures)
+		if err != nil {
+			return err
+		}
+		// nothing is saved unless every user and passport was added
+		if err := saveStorage(ctx, cfg, db); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "loaded %d users and %d passports\n", users, passports)
+		return nil
+	}
+	return stacktrace.NewError(fixturesUsage)
+}
+
+// loadFixtures adds users under new UIDs, then their passports, returning how many of each were added
+func loadFixtures(ctx context.Context, db svc.Storager, fixtures storage.Fixtures) (int, int, error) {
+	uids := make(map[int]int)
+	for _, u := range fixtures.Users {
+		id := u.ID
+		u.ID = -1
+		u, err := db.AddUser(ctx, u)
+		if err != nil {
+			return 0, 0, stacktrace.Propagate(err, "can't add user %d", id)
+		}
+		uids[id] = u.ID
+	}
+	for _, p := range fixtures.Passports {
+		uid, ok := uids[p.UserID]
+		if !ok {
+			return 0, 0, stacktrace.NewError("passport %s refers to unknown user %d", p.ID, p.UserID)
+		}
+		p.UserID = uid
+		if _, err := db.AddPassport(ctx, p); err != nil {
+			return 0, 0, stacktrace.Propagate(err, "can't add passport %s", p.ID)
+		}
+	}
+	return len(fixtures.Users), len(fixtures.Passports), nil
+}
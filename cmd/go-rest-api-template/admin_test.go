@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupFixtures points the configuration at a copy of the fixtures file
+func setupFixtures(t *testing.T) string {
+	fixtures, err := ioutil.ReadFile("../../fixtures.json")
+	assert.Nil(t, err)
+	file := filepath.Join(t.TempDir(), "fixtures.json")
+	assert.Nil(t, ioutil.WriteFile(file, fixtures, 0644))
+	t.Setenv("ENV", "TEST")
+	t.Setenv("VERSION", "../../VERSION")
+	t.Setenv("FIXTURES", file)
+	return file
+}
+
+func runCommand(t *testing.T, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := run(args, &out)
+	return out.String(), err
+}
+
+func TestUsersCommands(t *testing.T) {
+	setupFixtures(t)
+
+	out, err := runCommand(t, "users", "create", "-first-name", "Ada", "-last-name", "Lovelace", "-date-of-birth", "1815-12-10")
+	assert.Nil(t, err)
+	var u entities.User
+	assert.Nil(t, json.Unmarshal([]byte(out), &u))
+	assert.Equal(t, 2, u.ID, "they should be equal")
+
+	out, err = runCommand(t, "users", "list")
+	assert.Nil(t, err)
+	var list []entities.User
+	assert.Nil(t, json.Unmarshal([]byte(out), &list))
+	assert.Equal(t, 3, len(list), "they should be equal")
+	assert.Equal(t, "Lovelace", list[2].LastName, "they should be equal")
+
+	out, err = runCommand(t, "users", "get", "2")
+	assert.Nil(t, err)
+	var r userRecord
+	assert.Nil(t, json.Unmarshal([]byte(out), &r))
+	assert.Equal(t, "Ada", r.FirstName, "they should be equal")
+	assert.Equal(t, 0, len(r.Passports), "they should be equal")
+
+	out, err = runCommand(t, "users", "delete", "-dry-run", "2")
+	assert.Nil(t, err)
+	var d storage.Deletion
+	assert.Nil(t, json.Unmarshal([]byte(out), &d))
+	assert.Equal(t, true, d.DryRun, "they should be equal")
+	_, err = runCommand(t, "users", "get", "2")
+	assert.Nil(t, err)
+
+	_, err = runCommand(t, "users", "delete", "2")
+	assert.Nil(t, err)
+	_, err = runCommand(t, "users", "get", "2")
+	assert.NotNil(t, err)
+
+	_, err = runCommand(t, "users", "create", "-first-name", "Ada")
+	assert.NotNil(t, err)
+	_, err = runCommand(t, "users", "delete", "-policy", "shred", "1")
+	assert.NotNil(t, err)
+}
+
+func TestFixturesCommands(t *testing.T) {
+	file := setupFixtures(t)
+	dump := filepath.Join(filepath.Dir(file), "dump.json")
+
+	_, err := runCommand(t, "fixtures", "dump", dump)
+	assert.Nil(t, err)
+	out, err := runCommand(t, "fixtures", "load", dump)
+	assert.Nil(t, err)
+	assert.Equal(t, "loaded 2 users and 0 passports\n", out, "they should be equal")
+
+	out, err = runCommand(t, "fixtures", "dump")
+	assert.Nil(t, err)
+	var fixtures storage.Fixtures
+	assert.Nil(t, json.Unmarshal([]byte(out), &fixtures))
+	assert.Equal(t, 4, len(fixtures.Users), "they should be equal")
+	assert.Equal(t, "Jane", fixtures.Users[3].FirstName, "they should be equal")
+
+	// a passport of an unknown user fails the whole load
+	bad := filepath.Join(filepath.Dir(file), "bad.json")
+	assert.Nil(t, ioutil.WriteFile(bad, []byte(`{"users":[{"id":7,"firstName":"A","lastName":"B"}],"passports":[{"id":"1","userId":8}]}`), 0644))
+	_, err = runCommand(t, "fixtures", "load", bad)
+	assert.NotNil(t, err)
+	out, err = runCommand(t, "users", "list")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, strings.Count(out, `"id"`), "they should be equal")
+}
+
+func TestVersionAndConfigCommands(t *testing.T) {
+	setupFixtures(t)
+	t.Setenv("TENANT_JWT_SECRET", "s3cret")
+
+	out, err := runCommand(t, "version")
+	assert.Nil(t, err)
+	assert.Equal(t, "0.1.0\n", out, "they should be equal")
+
+	out, err = runCommand(t, "config", "print")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "ENV=TEST\n")
+	assert.Contains(t, out, "TENANT_JWT_SECRET=<set>\n")
+	assert.NotContains(t, out, "s3cret")
+	assert.Contains(t, out, "CORS_ALLOWED_ORIGINS=\n")
+	assert.Contains(t, out, "CORS_ALLOWED_HEADERS=Authorization,X-Tenant-ID,Last-Event-ID,If-Modified-Since\n")
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, https://*.example.org")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	out, err = runCommand(t, "config", "print")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "CORS_ALLOWED_ORIGINS=https://app.example.com,https://*.example.org\n")
+	assert.Contains(t, out, "CORS_ALLOW_CREDENTIALS=true\n")
+
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+	_, err = runCommand(t, "config", "print")
+	assert.NotNil(t, err)
+
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "sometimes")
+	_, err = runCommand(t, "config", "print")
+	assert.NotNil(t, err)
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "")
+
+	t.Setenv("TLS_CERT_FILE", "cert.pem")
+	_, err = runCommand(t, "config", "print")
+	assert.NotNil(t, err)
+	t.Setenv("TLS_KEY_FILE", "key.pem")
+	out, err = runCommand(t, "config", "print")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "TLS_CERT_FILE=cert.pem\n")
+	t.Setenv("TLS_CERT_FILE", "")
+	t.Setenv("TLS_KEY_FILE", "")
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.7,fd00::1/64")
+	out, err = runCommand(t, "config", "print")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "TRUSTED_PROXIES=10.0.0.0/8,192.0.2.7/32,fd00::/64\n")
+	t.Setenv("TRUSTED_PROXIES", "proxy.internal")
+	_, err = runCommand(t, "config", "print")
+	assert.NotNil(t, err)
+	t.Setenv("TRUSTED_PROXIES", "")
+
+	assert.Contains(t, out, "MAX_BODY_BYTES=1048576\n")
+	assert.Contains(t, out, "COMPRESSION_MIN_BYTES=1024\n")
+	t.Setenv("MAX_BODY_BYTES", "0")
+	_, err = runCommand(t, "config", "print")
+	assert.NotNil(t, err)
+	t.Setenv("MAX_BODY_BYTES", "")
+
+	assert.Contains(t, out, `PASSPORT_AUTHORITIES={"HMPO":"^[0-9]{9}$","IPS":"^[0-9]{9}$"}`+"\n")
+	t.Setenv("PASSPORT_AUTHORITIES", `{"HMPO": "[0-9"}`)
+	_, err = runCommand(t, "config", "print")
+	assert.NotNil(t, err)
+	t.Setenv("PASSPORT_AUTHORITIES", "")
+
+	assert.Contains(t, out, "WEBHOOK_ALLOW_INTERNAL=false\n")
+	t.Setenv("WEBHOOK_ALLOW_INTERNAL", "true")
+	out, err = runCommand(t, "config", "print")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "WEBHOOK_ALLOW_INTERNAL=true\n")
+	t.Setenv("WEBHOOK_ALLOW_INTERNAL", "")
+
+	t.Setenv("TENANTS", "acme, globex")
+	out, err = runCommand(t, "config", "print")
+	assert.Nil(t, err)
+	assert.Contains(t, out, "TENANTS=acme,globex\n")
+	t.Setenv("TENANTS", "Acme Corp")
+	_, err = runCommand(t, "config", "print")
+	assert.NotNil(t, err)
+	t.Setenv("TENANTS", "")
+
+	t.Setenv("CACHE_SIZE", "none")
+	_, err = runCommand(t, "config", "print")
+	assert.NotNil(t, err)
+
+	_, err = runCommand(t, "frobnicate")
+	assert.NotNil(t, err)
+}
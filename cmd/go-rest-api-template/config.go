@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/cache"
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/expiry"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/kostiamol/go-rest-api-template/svc"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+	"github.com/palantir/stacktrace"
+)
+
+// config is the configuration of the service, read from the environment
+type config struct {
+	Env                string               // LOCAL, DEV, STAGE, PROD
+	Port               string               // server traffic on this port
+	GRPCPort           string               // gRPC traffic on this port
+	VersionFile        string               // path to VERSION file
+	Fixtures           string               // path to fixtures file
+	ExpiryWindow       time.Duration        // passports expiring within this window are reminded of
+	DeletePolicy       storage.DeletePolicy // restrict, cascade or detach the passports of deleted users
+	Authorities        domain.Authorities   // issuing authorities of passports and their number formats
+	TenantDomain       string               // tenants are served from subdomains of this domain
+	TenantSecret       string               // verifies bearer tokens carrying a tenant claim
+	Tenants            []string             // tenants served besides the default one
+	RequestTimeout     time.Duration        // requests taking longer are abandoned
+	MaxBodySize        int64                // larger request bodies are refused
+	CompressionMinSize int                  // responses from this size on are compressed
+	CacheTTL           time.Duration        // user reads are cached this long, 0 disables caching
+	CacheSize          int                  // number of cached reads, 0 for the default
+	V1Sunset           time.Time            // version 1 of the API goes away on this date, if set
+	CORS               svc.CORS             // cross-origin requests allowed from browsers
+	TLS                svc.TLS              // HTTPS, and client certificates, if a certificate is given
+	WebhookInternal    bool                 // webhooks may be delivered to loopback and private addresses
+}
+
+// defaultCORSHeaders are the request headers of the API that browsers must ask to send
+var defaultCORSHeaders = []string{"Authorization", "X-Tenant-ID", "Last-Event-ID", "If-Modified-Since"}
+
+// loadConfig reads the configuration from the environment. LOCAL and PROD come with their own
+// ports and paths, other environments take them from PORT, GRPC_PORT, VERSION and FIXTURES.
+// LOCAL allows cross-origin requests from any origin, other environments only from
+// CORS_ALLOWED_ORIGINS. LOCAL delivers webhooks to internal addresses, other environments only if
+// WEBHOOK_ALLOW_INTERNAL is set.
+func loadConfig() (config, error) {
+	c := config{
+		Env:          os.Getenv("ENV"),
+		Port:         os.Getenv("PORT"),
+		GRPCPort:     os.Getenv("GRPC_PORT"),
+		VersionFile:  os.Getenv("VERSION"),
+		Fixtures:     os.Getenv("FIXTURES"),
+		TenantDomain: os.Getenv("TENANT_DOMAIN"),
+		TenantSecret: os.Getenv("TENANT_JWT_SECRET"),
+		Tenants:      envList("TENANTS", nil),
+	}
+	if c.Env == "" || c.Env == svc.Local {
+		c.Env = svc.Local
+		c.Port = "3001"
+		c.GRPCPort = "3002"
+		c.VersionFile = "../../VERSION"
+		c.Fixtures = "../../fixtures.json"
+	} else if c.Env == svc.Prod {
+		c.Port = "8080"
+		c.GRPCPort = "9090"
+		c.VersionFile = "./rsc/VERSION"
+		c.Fixtures = "./rsc/fixtures.json"
+	}
+	var err error
+	for _, id := range c.Tenants {
+		if !tenant.Valid(id) {
+			return c, stacktrace.NewError("TENANTS must list tenant ids of lower case letters, digits and dashes, not %q", id)
+		}
+	}
+	if c.DeletePolicy, err = storage.ParseDeletePolicy(os.Getenv("USER_DELETE_POLICY")); err != nil {
+		return c, err
+	}
+	c.Authorities = domain.DefaultAuthorities()
+	if authorities := os.Getenv("PASSPORT_AUTHORITIES"); authorities != "" {
+		if c.Authorities, err = domain.ParseAuthorities([]byte(authorities)); err != nil {
+			return c, stacktrace.Propagate(err, "invalid PASSPORT_AUTHORITIES")
+		}
+	}
+	days, err := envInt("EXPIRY_WINDOW_DAYS", int(expiry.DefaultWindow/(24*time.Hour)), 1)
+	if err != nil {
+		return c, err
+	}
+	c.ExpiryWindow = time.Duration(days) * 24 * time.Hour
+	seconds, err := envInt("REQUEST_TIMEOUT_SECONDS", int(svc.DefaultRequestTimeout/time.Second), 1)
+	if err != nil {
+		return c, err
+	}
+	c.RequestTimeout = time.Duration(seconds) * time.Second
+	size, err := envInt("MAX_BODY_BYTES", svc.DefaultMaxBodySize, 1)
+	if err != nil {
+		return c, err
+	}
+	c.MaxBodySize = int64(size)
+	if c.CompressionMinSize, err = envInt("COMPRESSION_MIN_BYTES", svc.DefaultCompressionMinSize, 1); err != nil {
+		return c, err
+	}
+	if seconds, err = envInt("CACHE_TTL_SECONDS", int(cache.DefaultTTL/time.Second), 0); err != nil {
+		return c, err
+	}
+	c.CacheTTL = time.Duration(seconds) * time.Second
+	if c.CacheSize, err = envInt("CACHE_SIZE", 0, 1); err != nil {
+		return c, err
+	}
+	c.CORS = svc.CORS{
+		AllowedOrigins: envList("CORS_ALLOWED_ORIGINS", nil),
+		AllowedMethods: envList("CORS_ALLOWED_METHODS", nil),
+		AllowedHeaders: envList("CORS_ALLOWED_HEADERS", defaultCORSHeaders),
+	}
+	if c.Env == svc.Local && c.CORS.AllowedOrigins == nil {
+		c.CORS.AllowedOrigins = []string{"*"}
+	}
+	if credentials := os.Getenv("CORS_ALLOW_CREDENTIALS"); credentials != "" {
+		if c.CORS.AllowCredentials, err = strconv.ParseBool(credentials); err != nil {
+			return c, stacktrace.NewError("CORS_ALLOW_CREDENTIALS must be true or false")
+		}
+	}
+	if c.CORS.AllowCredentials {
+		for _, origin := range c.CORS.AllowedOrigins {
+			if origin == "*" {
+				return c, stacktrace.NewError("CORS_ALLOW_CREDENTIALS requires CORS_ALLOWED_ORIGINS to name the origins rather than *")
+			}
+		}
+	}
+	if seconds, err = envInt("CORS_MAX_AGE_SECONDS", 600, 0); err != nil {
+		return c, err
+	}
+	c.CORS.MaxAge = time.Duration(seconds) * time.Second
+	c.TLS = svc.TLS{
+		CertFile:     os.Getenv("TLS_CERT_FILE"),
+		KeyFile:      os.Getenv("TLS_KEY_FILE"),
+		ClientCAFile: os.Getenv("TLS_CLIENT_CA_FILE"),
+		RedirectPort: os.Getenv("HTTP_REDIRECT_PORT"),
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return c, stacktrace.NewError("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if required := os.Getenv("TLS_REQUIRE_CLIENT_CERT"); required != "" {
+		if c.TLS.RequireClientCert, err = strconv.ParseBool(required); err != nil {
+			return c, stacktrace.NewError("TLS_REQUIRE_CLIENT_CERT must be true or false")
+		}
+	}
+	if c.TLS.CertFile == "" && (c.TLS.ClientCAFile != "" || c.TLS.RedirectPort != "") {
+		return c, stacktrace.NewError("TLS_CLIENT_CA_FILE and HTTP_REDIRECT_PORT need TLS_CERT_FILE")
+	}
+	if c.TLS.TrustedProxies, err = envPrefixes("TRUSTED_PROXIES"); err != nil {
+		return c, err
+	}
+	c.WebhookInternal = c.Env == svc.Local
+	if internal := os.Getenv("WEBHOOK_ALLOW_INTERNAL"); internal != "" {
+		if c.WebhookInternal, err = strconv.ParseBool(internal); err != nil {
+			return c, stacktrace.NewError("WEBHOOK_ALLOW_INTERNAL must be true or false")
+		}
+	}
+	if sunset := os.Getenv("V1_SUNSET"); sunset != "" {
+		if c.V1Sunset, err = time.Parse("2006-01-02", sunset); err != nil {
+			return c, stacktrace.NewError("V1_SUNSET must be a date such as 2006-01-02")
+		}
+	}
+	return c, nil
+}
+
+// envPrefixes reads a comma-separated list of networks, in CIDR notation, or addresses from the
+// environment
+func envPrefixes(name string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range envList(name, nil) {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return nil, stacktrace.NewError("%s must list networks such as 10.0.0.0/8 or addresses, not %s", name, s)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// envInt reads a number of at least min from the environment, def if the variable isn't set
+func envInt(name string, def, min int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min {
+		return 0, stacktrace.NewError("%s must be a number of at least %d", name, min)
+	}
+	return n, nil
+}
+
+// envList reads a comma-separated list from the environment, def if the variable isn't set
+func envList(name string, def []string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// print writes the configuration as environment variables, hiding the secret
+func (c config) print(out io.Writer) {
+	secret := "<unset>"
+	if c.TenantSecret != "" {
+		secret = "<set>"
+	}
+	sunset := ""
+	if !c.V1Sunset.IsZero() {
+		sunset = c.V1Sunset.Format("2006-01-02")
+	}
+	var proxies []string
+	for _, prefix := range c.TLS.TrustedProxies {
+		proxies = append(proxies, prefix.String())
+	}
+	for _, kv := range [][2]string{
+		{"ENV", c.Env},
+		{"PORT", c.Port},
+		{"GRPC_PORT", c.GRPCPort},
+		{"VERSION", c.VersionFile},
+		{"FIXTURES", c.Fixtures},
+		{"EXPIRY_WINDOW_DAYS", strconv.Itoa(int(c.ExpiryWindow / (24 * time.Hour)))},
+		{"USER_DELETE_POLICY", string(c.DeletePolicy)},
+		{"PASSPORT_AUTHORITIES", c.Authorities.String()},
+		{"TENANT_DOMAIN", c.TenantDomain},
+		{"TENANT_JWT_SECRET", secret},
+		{"TENANTS", strings.Join(c.Tenants, ",")},
+		{"REQUEST_TIMEOUT_SECONDS", strconv.Itoa(int(c.RequestTimeout / time.Second))},
+		{"MAX_BODY_BYTES", strconv.FormatInt(c.MaxBodySize, 10)},
+		{"COMPRESSION_MIN_BYTES", strconv.Itoa(c.CompressionMinSize)},
+		{"CACHE_TTL_SECONDS", strconv.Itoa(int(c.CacheTTL / time.Second))},
+		{"CACHE_SIZE", strconv.Itoa(c.CacheSize)},
+		{"V1_SUNSET", sunset},
+		{"CORS_ALLOWED_ORIGINS", strings.Join(c.CORS.AllowedOrigins, ",")},
+		{"CORS_ALLOWED_METHODS", strings.Join(c.CORS.AllowedMethods, ",")},
+		{"CORS_ALLOWED_HEADERS", strings.Join(c.CORS.AllowedHeaders, ",")},
+		{"CORS_ALLOW_CREDENTIALS", strconv.FormatBool(c.CORS.AllowCredentials)},
+		{"CORS_MAX_AGE_SECONDS", strconv.Itoa(int(c.CORS.MaxAge / time.Second))},
+		{"TLS_CERT_FILE", c.TLS.CertFile},
+		{"TLS_KEY_FILE", c.TLS.KeyFile},
+		{"TLS_CLIENT_CA_FILE", c.TLS.ClientCAFile},
+		{"TLS_REQUIRE_CLIENT_CERT", strconv.FormatBool(c.TLS.RequireClientCert)},
+		{"HTTP_REDIRECT_PORT", c.TLS.RedirectPort},
+		{"TRUSTED_PROXIES", strings.Join(proxies, ",")},
+		{"WEBHOOK_ALLOW_INTERNAL", strconv.FormatBool(c.WebhookInternal)},
+	} {
+		fmt.Fprintf(out, "%s=%s\n", kv[0], kv[1])
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// lockFixtures doesn't lock the fixtures file where flock isn't available: commands change it
+// whether a server is running or not
+func lockFixtures(file string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
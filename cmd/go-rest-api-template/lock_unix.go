@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/palantir/stacktrace"
+)
+
+// lockFixtures locks the fixtures file, shared by the servers reading it or exclusively by the
+// commands changing it, and returns the function releasing the lock. It returns errLocked at once
+// when the file is locked otherwise. The lock goes away with the process holding it.
+func lockFixtures(file string, exclusive bool) (func(), error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "can't lock the fixtures file")
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, stacktrace.Propagate(err, "can't lock the fixtures file")
+	}
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServedFixturesAreNotChanged(t *testing.T) {
+	file := setupFixtures(t)
+
+	// as a running server does
+	release, err := lockFixtures(file, false)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	_, err = runCommand(t, "users", "create", "-first-name", "Ada", "-last-name", "Lovelace")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "is served by a running server")
+	}
+	_, err = runCommand(t, "fixtures", "load", file)
+	assert.NotNil(t, err)
+	_, err = runCommand(t, "users", "list")
+	assert.Nil(t, err)
+	release()
+
+	_, err = runCommand(t, "users", "create", "-first-name", "Ada", "-last-name", "Lovelace")
+	assert.Nil(t, err)
+
+	// servers don't start while a command changes the file
+	release, err = lockFixtures(file, true)
+	assert.Nil(t, err)
+	_, err = lockFixtures(file, false)
+	assert.Equal(t, errLocked, err, "they should be equal")
+	release()
+}
@@ -1,47 +1,144 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/kostiamol/go-rest-api-template/expiry"
 	"github.com/kostiamol/go-rest-api-template/storage"
 	"github.com/kostiamol/go-rest-api-template/svc"
-
-	"github.com/unrolled/render"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+	"github.com/kostiamol/go-rest-api-template/webhook"
+	"github.com/palantir/stacktrace"
 )
 
+const usage = `usage: go-rest-api-template [command]
+
+  serve            run the HTTP and gRPC servers (the default)
+  users            list, get, create or delete users
+  fixtures         load or dump the fixtures file
+  migrate          migrate the database schema
+  version          print the version of the service
+  config print     print the configuration read from the environment
+
+Run a command with -h for its options.`
+
 func main() {
-	var (
-		env      = os.Getenv("ENV")      // LOCAL, DEV, STAGE, PROD
-		port     = os.Getenv("PORT")     // server traffic on this port
-		version  = os.Getenv("VERSION")  // path to VERSION file
-		fixtures = os.Getenv("FIXTURES") // path to fixtures file
-	)
-	if env == "" || env == svc.Local {
-		env = svc.Local
-		port = "3001"
-		version = "../../VERSION"
-		fixtures = "../../fixtures.json"
-	} else if env == svc.Prod {
-		env = svc.Prod
-		port = "8080"
-		version = "./rsc/VERSION"
-		fixtures = "./rsc/fixtures.json"
-	}
-	version, err := svc.ParseVersionFile(version)
-	if err != nil {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
 		log.Fatal(err)
 	}
-	db, err := storage.LoadFixturesIntoMockDB(fixtures)
+}
+
+// run dispatches the command line to its subcommand
+func run(args []string, out io.Writer) error {
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	if command == "migrate" {
+		return migrateCommand(args, out)
+	}
+	if command == "help" || command == "-h" || command == "--help" {
+		fmt.Fprintln(out, usage)
+		return nil
+	}
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	switch command {
+	case "serve":
+		return serve(cfg)
+	case "users":
+		return usersCommand(cfg, args, out)
+	case "fixtures":
+		return fixturesCommand(cfg, args, out)
+	case "version":
+		version, err := svc.ParseVersionFile(cfg.VersionFile)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, version)
+		return nil
+	case "config":
+		if len(args) != 1 || args[0] != "print" {
+			return stacktrace.NewError("usage: go-rest-api-template config print")
+		}
+		cfg.print(out)
+		return nil
 	}
-	ctx := svc.Context{
-		Render:  render.New(),
-		Version: version,
-		Env:     env,
-		Port:    port,
-		DB:      db,
+	return stacktrace.NewError("unknown command %q\n\n%s", command, usage)
+}
+
+// serve runs the servers until the process is stopped
+func serve(cfg config) error {
+	version, err := svc.ParseVersionFile(cfg.VersionFile)
+	if err != nil {
+		return err
+	}
+	// the administration commands refuse to change the fixtures file while it is served
+	release, err := lockFixtures(cfg.Fixtures, false)
+	if err == errLocked {
+		return stacktrace.NewError("%s is being changed by another command", cfg.Fixtures)
+	}
+	if err != nil {
+		return err
+	}
+	defer release()
+	db, err := storage.LoadFixturesIntoMockDB(cfg.Fixtures)
+	if err != nil {
+		return err
+	}
+	tenants := storage.NewTenants(db, cfg.Tenants...)
+	ctx := svc.NewStorageContext(tenants, svc.StorageOptions{
+		Authorities: cfg.Authorities,
+		CacheSize:   cfg.CacheSize,
+		CacheTTL:    cfg.CacheTTL,
+		Webhooks:    webhook.Options{AllowInternal: cfg.WebhookInternal},
+	})
+	ctx.Version = version
+	ctx.Env = cfg.Env
+	ctx.Port = cfg.Port
+	ctx.GRPCPort = cfg.GRPCPort
+	ctx.ExpiryWindow = cfg.ExpiryWindow
+	ctx.RequestTimeout = cfg.RequestTimeout
+	ctx.MaxBodySize = cfg.MaxBodySize
+	ctx.CompressionMinSize = cfg.CompressionMinSize
+	ctx.DeletePolicy = cfg.DeletePolicy
+	ctx.V1Sunset = cfg.V1Sunset
+	ctx.CORS = cfg.CORS
+	ctx.TLS = cfg.TLS
+	// once tenants can prove who they are, they must
+	ctx.TenantResolver = tenant.Resolver{
+		Verified: cfg.TenantSecret != "" || cfg.TLS.ClientCAFile != "",
+		Secret:   []byte(cfg.TenantSecret),
+		Domain:   cfg.TenantDomain,
+	}
+	defer ctx.Close()
+	scheduler := expiry.NewScheduler(func() []expiry.Store {
+		var stores []expiry.Store
+		for _, db := range tenants.All() {
+			stores = append(stores, db)
+		}
+		return stores
+	}, expiry.Options{Window: cfg.ExpiryWindow})
+	defer scheduler.Close()
+
+	// both servers stop on SIGINT or SIGTERM, or when either of them fails
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	errs := make(chan error, 2)
+	go func() { errs <- svc.RunGRPC(ctx, stop.Done()) }()
+	go func() { errs <- svc.Run(ctx, stop.Done()) }()
+	err = <-errs
+	cancel()
+	if other := <-errs; err == nil {
+		err = other
 	}
-	svc.Run(ctx)
+	return err
 }
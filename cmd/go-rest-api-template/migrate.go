@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/kostiamol/go-rest-api-template/migrate"
+	"github.com/kostiamol/go-rest-api-template/migrations"
+	_ "github.com/lib/pq" // the postgres driver
+	"github.com/palantir/stacktrace"
+)
+
+const migrateUsage = `usage: go-rest-api-template migrate up|down|status|to <version>
+
+  up            apply all pending migrations
+  down          roll back the last applied migration
+  status        list the migrations and the version of the database
+  to <version>  migrate up or down to a version, 0 rolling back everything
+
+The database is taken from DATABASE_URL, its data source name, and DATABASE_DRIVER, the name of
+a database/sql driver linked into the binary, postgres by default.`
+
+// migrateCommand runs the migrate subcommand against the database of the environment
+func migrateCommand(args []string, out io.Writer) error {
+	if len(args) == 0 || (args[0] == "to") != (len(args) == 2) || len(args) > 2 {
+		return stacktrace.NewError(migrateUsage)
+	}
+	driver, url := os.Getenv("DATABASE_DRIVER"), os.Getenv("DATABASE_URL")
+	if driver == "" {
+		driver = "postgres"
+	}
+	if url == "" {
+		return stacktrace.NewError("DATABASE_URL is required")
+	}
+	db, err := sql.Open(driver, url)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't open the database")
+	}
+	defer db.Close()
+	list, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return err
+	}
+	m := migrate.New(&migrate.SQLDriver{DB: db, Dollar: driver == "postgres" || driver == "pgx"}, list)
+	// an interrupted migration releases the lock rather than leave others waiting for its lease
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var version int
+	switch args[0] {
+	case "up":
+		version, err = m.Up(ctx)
+	case "down":
+		version, err = m.Down(ctx)
+	case "to":
+		target, perr := strconv.Atoi(args[1])
+		if perr != nil || target < 0 {
+			return stacktrace.NewError("version must be a migration number or 0")
+		}
+		version, err = m.To(ctx, target)
+	case "status":
+		statuses, current, dirty, serr := m.Status(ctx)
+		if serr != nil {
+			return serr
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Fprintf(out, "%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		if dirty {
+			fmt.Fprintf(out, "schema version %d (dirty)\n", current)
+		} else {
+			fmt.Fprintf(out, "schema version %d\n", current)
+		}
+		return nil
+	default:
+		return stacktrace.NewError(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "schema version %d\n", version)
+	return nil
+}
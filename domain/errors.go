@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+)
+
+// Kind classifies the errors of the domain service so that every API can report them its own way
+type Kind int
+
+// Error kinds
+const (
+	// Internal errors are failures of the storage rather than of the request
+	Internal Kind = iota
+	// NotFound means an entity the request refers to doesn't exist
+	NotFound
+	// Conflict means the request clashes with the stored data, e.g. a duplicate
+	Conflict
+	// Invalid means the request breaks a business rule on its own
+	Invalid
+)
+
+// Error is a broken business rule
+type Error struct {
+	Kind    Kind
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+func errorf(kind Kind, format string, args ...interface{}) error {
+	return Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// missing reports a failed lookup as NotFound, unless the lookup failed because the request was
+// cancelled or ran out of time or because the storage is unavailable
+func missing(ctx context.Context, err error, message string) error {
+	if ctx.Err() != nil || storage.Unavailable(err) {
+		return stacktrace.Propagate(err, "%s", message)
+	}
+	return Error{Kind: NotFound, Message: message}
+}
+
+// KindOf returns the kind of err, Internal for errors that don't come from a broken rule
+func KindOf(err error) Kind {
+	if e, ok := stacktrace.RootCause(err).(Error); ok {
+		return e.Kind
+	}
+	return Internal
+}
@@ -0,0 +1,263 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+)
+
+// Authorities maps the known issuing authorities to the format of their passport numbers
+type Authorities map[string]*regexp.Regexp
+
+// DefaultAuthorities returns the authorities known when none are configured
+func DefaultAuthorities() Authorities {
+	return Authorities{
+		// HM Passport Office
+		"HMPO": regexp.MustCompile(`^[0-9]{9}$`),
+		// Identity and Passport Service, HMPO's predecessor
+		"IPS": regexp.MustCompile(`^[0-9]{9}$`),
+	}
+}
+
+// ParseAuthorities reads authorities from a JSON object mapping their names to the regular
+// expression their passport numbers match, e.g. {"HMPO": "^[0-9]{9}$"}
+func ParseAuthorities(data []byte) (Authorities, error) {
+	var formats map[string]string
+	if err := json.Unmarshal(data, &formats); err != nil {
+		return nil, stacktrace.Propagate(err, "authorities must be a JSON object of regular expressions")
+	}
+	if len(formats) == 0 {
+		return nil, stacktrace.NewError("no authorities")
+	}
+	authorities := make(Authorities, len(formats))
+	for name, format := range formats {
+		re, err := regexp.Compile(format)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "invalid format of authority %s", name)
+		}
+		authorities[name] = re
+	}
+	return authorities, nil
+}
+
+// String lists the authorities as the JSON object ParseAuthorities reads
+func (a Authorities) String() string {
+	formats := make(map[string]string, len(a))
+	for name, re := range a {
+		formats[name] = re.String()
+	}
+	data, _ := json.Marshal(formats)
+	return string(data)
+}
+
+// Store is the storage the service enforces its rules on; svc.Storager satisfies it
+type Store interface {
+	ListUsers(ctx context.Context) ([]entities.User, error)
+	GetUser(ctx context.Context, i int) (entities.User, error)
+	GetUsers(ctx context.Context, ids []int) ([]entities.User, error)
+	AddUser(ctx context.Context, u entities.User) (entities.User, error)
+	UpdateUser(ctx context.Context, u entities.User) (entities.User, error)
+	DeleteUser(ctx context.Context, i int, opts storage.DeleteOptions) (storage.Deletion, error)
+	ListPassports(ctx context.Context) ([]entities.Passport, error)
+	ListExpiringPassports(ctx context.Context, before time.Time) ([]entities.Passport, error)
+	ListUserPassports(ctx context.Context, uid int) ([]entities.Passport, error)
+	ListPassportsOfUsers(ctx context.Context, uids []int) ([]entities.Passport, error)
+	GetPassport(ctx context.Context, id string) (entities.Passport, error)
+	AddPassport(ctx context.Context, p entities.Passport) (entities.Passport, error)
+	UpdatePassport(ctx context.Context, p entities.Passport) (entities.Passport, error)
+	DeletePassport(ctx context.Context, id string) error
+	AddRecords(ctx context.Context, records []storage.Record) ([]storage.Record, error)
+}
+
+// Service enforces the business rules on the writes to a Store, passing reads through:
+//
+//   - a user holds at most one non-expired passport per issuing authority
+//   - the passport number matches the format of its authority
+//   - a passport is issued after its holder's date of birth
+//
+// Broken rules are reported as an Error. The checks and the write they guard run under one
+// lock, so concurrent writes through the same service can't break the rules together.
+type Service struct {
+	Store
+	authorities Authorities
+	mu          sync.Mutex
+	now         func() time.Time
+}
+
+// NewService returns a service enforcing the business rules on store, knowing of the issuing
+// authorities given
+func NewService(store Store, authorities Authorities) *Service {
+	return &Service{Store: store, authorities: authorities, now: time.Now}
+}
+
+// UpdateUser updates a user, whose date of birth must stay before the issue of its passports
+func (s *Service) UpdateUser(ctx context.Context, u entities.User) (entities.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := s.Store.ListUserPassports(ctx, u.ID)
+	if err != nil {
+		return u, missing(ctx, err, "can't find user")
+	}
+	for _, p := range list {
+		if err := checkIssuedAfterBirth(p, u); err != nil {
+			return u, err
+		}
+	}
+	return s.Store.UpdateUser(ctx, u)
+}
+
+// DeleteUser deletes a user, reporting a user refused by the restrict policy as a conflict
+func (s *Service) DeleteUser(ctx context.Context, i int, opts storage.DeleteOptions) (storage.Deletion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.Store.GetUser(ctx, i); err != nil {
+		return storage.Deletion{}, missing(ctx, err, "can't find user")
+	}
+	d, err := s.Store.DeleteUser(ctx, i, opts)
+	if stacktrace.GetCode(err) == storage.EcodeUserHasPassports {
+		return d, errorf(Conflict, "user %d holds passports, delete them first", i)
+	}
+	return d, err
+}
+
+// AddPassport adds a passport to an existing user
+func (s *Service) AddPassport(ctx context.Context, p entities.Passport) (entities.Passport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.Store.GetPassport(ctx, p.ID); err == nil {
+		return p, errorf(Conflict, "passport %s already exists", p.ID)
+	} else if ctx.Err() != nil || storage.Unavailable(err) {
+		return p, stacktrace.Propagate(err, "can't add passport %s", p.ID)
+	}
+	if err := s.check(ctx, p); err != nil {
+		return p, err
+	}
+	p, err := s.Store.AddPassport(ctx, p)
+	if err != nil {
+		return p, stacktrace.Propagate(err, "can't add passport %s", p.ID)
+	}
+	return p, nil
+}
+
+// UpdatePassport updates an existing passport
+func (s *Service) UpdatePassport(ctx context.Context, p entities.Passport) (entities.Passport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.Store.GetPassport(ctx, p.ID); err != nil {
+		return p, missing(ctx, err, "can't find passport")
+	}
+	if err := s.check(ctx, p); err != nil {
+		return p, err
+	}
+	p, err := s.Store.UpdatePassport(ctx, p)
+	if err != nil {
+		return p, stacktrace.Propagate(err, "can't update passport %s", p.ID)
+	}
+	return p, nil
+}
+
+// AddRecords adds a batch of users along with their passports, and detached passports. The rules
+// are applied to every record before anything is added, and the first record breaking one fails
+// the whole batch as a *storage.RecordError.
+func (s *Service) AddRecords(ctx context.Context, records []storage.Record) ([]storage.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	for i, r := range records {
+		if err := s.checkRecord(ctx, r, seen); err != nil {
+			return nil, &storage.RecordError{Index: i, Err: err}
+		}
+	}
+	return s.Store.AddRecords(ctx, records)
+}
+
+// checkRecord applies the passport rules to the new passports of a record, given the ids of the
+// passports of the records before it; the lock must be held
+func (s *Service) checkRecord(ctx context.Context, r storage.Record, seen map[string]bool) error {
+	held := make(map[string]string)
+	for _, p := range r.Passports {
+		if seen[p.ID] {
+			return errorf(Conflict, "passport %s already exists", p.ID)
+		}
+		seen[p.ID] = true
+		if _, err := s.Store.GetPassport(ctx, p.ID); err == nil {
+			return errorf(Conflict, "passport %s already exists", p.ID)
+		} else if ctx.Err() != nil || storage.Unavailable(err) {
+			return stacktrace.Propagate(err, "can't add passport %s", p.ID)
+		}
+		if err := s.checkFormat(p); err != nil {
+			return err
+		}
+		if r.Detached() {
+			continue
+		}
+		if err := checkIssuedAfterBirth(p, r.User); err != nil {
+			return err
+		}
+		if !active(p, s.now()) {
+			continue
+		}
+		if other, ok := held[p.Authority]; ok {
+			return errorf(Conflict, "user already holds passport %s from %s, which hasn't expired", other, p.Authority)
+		}
+		held[p.Authority] = p.ID
+	}
+	return nil
+}
+
+// checkFormat checks that a passport comes from a known authority and matches its format
+func (s *Service) checkFormat(p entities.Passport) error {
+	format, ok := s.authorities[p.Authority]
+	if !ok {
+		return errorf(Invalid, "unknown issuing authority %s", p.Authority)
+	}
+	if !format.MatchString(p.ID) {
+		return errorf(Invalid, "passport id %s doesn't match the format of %s", p.ID, p.Authority)
+	}
+	return nil
+}
+
+// check applies the passport rules; the lock must be held
+func (s *Service) check(ctx context.Context, p entities.Passport) error {
+	if err := s.checkFormat(p); err != nil {
+		return err
+	}
+	u, err := s.Store.GetUser(ctx, p.UserID)
+	if err != nil {
+		return missing(ctx, err, "can't find user")
+	}
+	if err := checkIssuedAfterBirth(p, u); err != nil {
+		return err
+	}
+	if !active(p, s.now()) {
+		return nil
+	}
+	held, err := s.Store.ListUserPassports(ctx, p.UserID)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't list passports of user %d", p.UserID)
+	}
+	for _, other := range held {
+		if other.ID != p.ID && other.Authority == p.Authority && active(other, s.now()) {
+			return errorf(Conflict, "user already holds passport %s from %s, which hasn't expired", other.ID, p.Authority)
+		}
+	}
+	return nil
+}
+
+func checkIssuedAfterBirth(p entities.Passport, u entities.User) error {
+	if p.DateOfIssue.IsZero() || u.DateOfBirth.IsZero() || p.DateOfIssue.After(u.DateOfBirth) {
+		return nil
+	}
+	return errorf(Invalid, "passport %s dateOfIssue must be after the holder's dateOfBirth", p.ID)
+}
+
+// active reports whether a passport hasn't expired; passports without a date of expiry never do
+func active(p entities.Passport, now time.Time) bool {
+	return p.DateOfExpiry.IsZero() || p.DateOfExpiry.After(now)
+}
@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestOneActivePassportPerAuthority(t *testing.T) {
+	s := NewService(storage.NewMockDB(), DefaultAuthorities())
+	s.now = func() time.Time { return date("2020-01-01") }
+	_, err := s.AddPassport(t.Context(), entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 1, DateOfExpiry: date("2025-01-01")})
+	assert.Nil(t, err)
+	_, err = s.AddPassport(t.Context(), entities.Passport{ID: "222222222", Authority: "HMPO", UserID: 1})
+	assert.Equal(t, Conflict, KindOf(err), "they should be equal")
+	assert.Equal(t, "user already holds passport 111111111 from HMPO, which hasn't expired", err.Error(), "they should be equal")
+
+	_, err = s.AddPassport(t.Context(), entities.Passport{ID: "222222222", Authority: "IPS", UserID: 1})
+	assert.Nil(t, err, "other authorities are fine")
+	_, err = s.AddPassport(t.Context(), entities.Passport{ID: "333333333", Authority: "HMPO", UserID: 1, DateOfExpiry: date("2019-01-01")})
+	assert.Nil(t, err, "expired passports are fine")
+	_, err = s.UpdatePassport(t.Context(), entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 1, DateOfExpiry: date("2026-01-01")})
+	assert.Nil(t, err, "a passport doesn't conflict with itself")
+	_, err = s.AddPassport(t.Context(), entities.Passport{ID: "111111111", Authority: "IPS", UserID: 0})
+	assert.Equal(t, Conflict, KindOf(err), "they should be equal")
+}
+
+func TestPassportRules(t *testing.T) {
+	s := NewService(storage.NewMockDB(), DefaultAuthorities())
+	for _, c := range []struct {
+		passport entities.Passport
+		kind     Kind
+	}{
+		{entities.Passport{ID: "111111111", Authority: "Ministry of Magic", UserID: 1}, Invalid},
+		{entities.Passport{ID: "12345", Authority: "HMPO", UserID: 1}, Invalid},
+		{entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 1, DateOfIssue: date("1991-01-01")}, Invalid},
+		{entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 10}, NotFound},
+	} {
+		_, err := s.AddPassport(t.Context(), c.passport)
+		assert.Equal(t, c.kind, KindOf(err), "%v", err)
+	}
+	_, err := s.UpdatePassport(t.Context(), entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 1})
+	assert.Equal(t, NotFound, KindOf(err), "they should be equal")
+}
+
+func TestUpdateUserKeepsBirthBeforeIssue(t *testing.T) {
+	s := NewService(storage.NewMockDB(), DefaultAuthorities())
+	_, err := s.AddPassport(t.Context(), entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 1, DateOfIssue: date("2010-01-01")})
+	assert.Nil(t, err)
+	u, _ := s.GetUser(t.Context(), 1)
+	u.DateOfBirth = date("2011-01-01")
+	_, err = s.UpdateUser(t.Context(), u)
+	assert.Equal(t, Invalid, KindOf(err), "they should be equal")
+	_, err = s.UpdateUser(t.Context(), entities.User{ID: 10})
+	assert.Equal(t, NotFound, KindOf(err), "they should be equal")
+}
+
+func TestKindOf(t *testing.T) {
+	assert.Equal(t, Conflict, KindOf(stacktrace.Propagate(errorf(Conflict, "duplicate"), "wrapped")), "they should be equal")
+	assert.Equal(t, Internal, KindOf(stacktrace.NewError("boom")), "they should be equal")
+}
+
+func TestConfiguredAuthorities(t *testing.T) {
+	authorities, err := ParseAuthorities([]byte(`{"HMPO": "^[0-9]{9}$", "UKVI": "^[A-Z]{2}[0-9]{6}$"}`))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	s := NewService(storage.NewMockDB(), authorities)
+	_, err = s.AddPassport(t.Context(), entities.Passport{ID: "AB123456", Authority: "UKVI", UserID: 1})
+	assert.Nil(t, err)
+	_, err = s.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "IPS", UserID: 1})
+	assert.Equal(t, Invalid, KindOf(err), "they should be equal")
+	assert.Equal(t, `{"HMPO":"^[0-9]{9}$","UKVI":"^[A-Z]{2}[0-9]{6}$"}`, authorities.String(), "they should be equal")
+
+	for _, data := range []string{`{}`, `["HMPO"]`, `{"HMPO": "[0-9"}`} {
+		_, err = ParseAuthorities([]byte(data))
+		assert.NotNil(t, err, data)
+	}
+}
@@ -5,12 +5,41 @@ import (
 )
 
 // Passport holds passport data
+// swagger:response passport
 type Passport struct {
-	ID           string    `json:"id"`
-	DateOfIssue  time.Time `json:"dateOfIssue"`
+	// Passport number
+	ID string `json:"id"`
+	// Date of issue
+	DateOfIssue time.Time `json:"dateOfIssue"`
+	// Date of expiry
 	DateOfExpiry time.Time `json:"dateOfExpiry"`
-	Authority    string    `json:"authority"`
-	UserID       int       `json:"userId"`
+	// Issuing authority
+	Authority string `json:"authority"`
+	// UID of the passport holder
+	UserID int `json:"userId"`
+	// Computed status: valid, expiring or expired. It is never stored.
+	Status string `json:"status,omitempty"`
+}
+
+// Passport statuses
+const (
+	StatusValid    = "valid"
+	StatusExpiring = "expiring"
+	StatusExpired  = "expired"
+)
+
+// ExpiryStatus returns the status of the passport at the given time, passports expiring within
+// window being expiring. Passports without a date of expiry are valid.
+func (p Passport) ExpiryStatus(now time.Time, window time.Duration) string {
+	switch {
+	case p.DateOfExpiry.IsZero():
+		return StatusValid
+	case !p.DateOfExpiry.After(now):
+		return StatusExpired
+	case p.DateOfExpiry.Before(now.Add(window)):
+		return StatusExpiring
+	}
+	return StatusValid
 }
 
 // User holds personal user information
@@ -27,3 +56,34 @@ type User struct {
 	// Location of birth
 	LocationOfBirth string `json:"locationOfBirth"`
 }
+
+// Event describes a change of a user or passport
+type Event struct {
+	// Unique event id
+	ID string `json:"id"`
+	// Event type, e.g. user.created
+	Type string `json:"type"`
+	// Tenant owning the changed entity
+	Tenant string `json:"tenant,omitempty"`
+	// Time of the change
+	Time time.Time `json:"time"`
+	// The changed entity, or its id for deletions
+	Data interface{} `json:"data"`
+}
+
+// Deleted is the data of a deletion event
+type Deleted struct {
+	ID interface{} `json:"id"`
+}
+
+// Event types
+const (
+	UserCreated     = "user.created"
+	UserUpdated     = "user.updated"
+	UserDeleted     = "user.deleted"
+	PassportCreated = "passport.created"
+	PassportUpdated = "passport.updated"
+	PassportDeleted = "passport.deleted"
+	// PassportExpiring reminds of a passport about to expire
+	PassportExpiring = "passport.expiring"
+)
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+)
+
+// Broker fans events out to live subscribers and keeps the most recent ones in a ring buffer so
+// that subscribers reconnecting with the id of the last event they saw can catch up
+type Broker struct {
+	mu          sync.Mutex
+	ring        []entities.Event
+	next        int
+	full        bool
+	subscribers map[*Subscriber]struct{}
+}
+
+// Subscriber receives the events matching its filter on C. C is closed when the subscriber is
+// cancelled or falls too far behind.
+type Subscriber struct {
+	C      <-chan entities.Event
+	c      chan entities.Event
+	tenant string
+	filter map[string]bool
+	broker *Broker
+}
+
+// NewBroker returns a broker remembering the last size events
+func NewBroker(size int) *Broker {
+	if size <= 0 {
+		size = 1024
+	}
+	return &Broker{
+		ring:        make([]entities.Event, size),
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+}
+
+// EntityType returns the entity an event type is about, e.g. user for user.created
+func EntityType(eventType string) string {
+	if i := strings.IndexByte(eventType, '.'); i >= 0 {
+		return eventType[:i]
+	}
+	return eventType
+}
+
+// Publish records an event and hands it to the interested subscribers. Subscribers whose buffer
+// is full are dropped rather than slowing the publisher down.
+func (b *Broker) Publish(e entities.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ring[b.next] = e
+	b.next = (b.next + 1) % len(b.ring)
+	if b.next == 0 {
+		b.full = true
+	}
+	for s := range b.subscribers {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			b.remove(s)
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a subscriber to the events of a tenant about the given entity types, all
+// of them when empty. If lastID
+// is set, the buffered events published after it are returned for replay; when lastID has
+// already left the buffer the whole buffer is replayed and found is false.
+func (b *Broker) Subscribe(tenant string, types []string, lastID string, buffer int) (s *Subscriber, replay []entities.Event, found bool) {
+	c := make(chan entities.Event, buffer)
+	s = &Subscriber{C: c, c: c, tenant: tenant, broker: b}
+	if len(types) > 0 {
+		s.filter = make(map[string]bool)
+		for _, t := range types {
+			s.filter[t] = true
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastID == "" {
+		b.subscribers[s] = struct{}{}
+		return s, nil, true
+	}
+	buf := b.buffered()
+	from := 0
+	for i, e := range buf {
+		if e.ID == lastID {
+			from, found = i+1, true
+		}
+	}
+	for _, e := range buf[from:] {
+		if s.wants(e) {
+			replay = append(replay, e)
+		}
+	}
+	b.subscribers[s] = struct{}{}
+	return s, replay, found
+}
+
+// Cancel unregisters the subscriber and closes its channel
+func (s *Subscriber) Cancel() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+func (s *Subscriber) wants(e entities.Event) bool {
+	return e.Tenant == s.tenant && (s.filter == nil || s.filter[EntityType(e.Type)])
+}
+
+// remove must be called with the lock held
+func (b *Broker) remove(s *Subscriber) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.c)
+	}
+}
+
+// buffered returns the buffered events, oldest first; it must be called with the lock held
+func (b *Broker) buffered() []entities.Event {
+	if !b.full {
+		return b.ring[:b.next]
+	}
+	return append(append([]entities.Event{}, b.ring[b.next:]...), b.ring[:b.next]...)
+}
@@ -0,0 +1,70 @@
+package events
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+func ids(list []entities.Event) []string {
+	out := []string{}
+	for _, e := range list {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func TestReplayFromRingBuffer(t *testing.T) {
+	b := NewBroker(3)
+	for i := 1; i <= 4; i++ {
+		b.Publish(entities.Event{ID: strconv.Itoa(i), Type: entities.UserCreated})
+	}
+	_, replay, found := b.Subscribe("", nil, "2", 1)
+	assert.True(t, found)
+	assert.Equal(t, []string{"3", "4"}, ids(replay), "they should be equal")
+
+	_, replay, found = b.Subscribe("", nil, "1", 1)
+	assert.False(t, found, "event 1 has been overwritten")
+	assert.Equal(t, []string{"2", "3", "4"}, ids(replay), "they should be equal")
+
+	_, replay, found = b.Subscribe("", nil, "", 1)
+	assert.True(t, found)
+	assert.Equal(t, 0, len(replay), "they should be equal")
+}
+
+func TestFilterByEntityType(t *testing.T) {
+	b := NewBroker(10)
+	b.Publish(entities.Event{ID: "1", Type: entities.UserCreated})
+	b.Publish(entities.Event{ID: "2", Type: entities.PassportCreated})
+	s, replay, _ := b.Subscribe("", []string{"passport"}, "1", 10)
+	assert.Equal(t, []string{"2"}, ids(replay), "they should be equal")
+	b.Publish(entities.Event{ID: "3", Type: entities.UserDeleted})
+	b.Publish(entities.Event{ID: "4", Type: entities.PassportDeleted})
+	assert.Equal(t, "4", (<-s.C).ID, "they should be equal")
+	s.Cancel()
+	_, ok := <-s.C
+	assert.False(t, ok, "the channel should be closed")
+	s.Cancel()
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker(10)
+	s, _, _ := b.Subscribe("", nil, "", 1)
+	b.Publish(entities.Event{ID: "1", Type: entities.UserCreated})
+	b.Publish(entities.Event{ID: "2", Type: entities.UserCreated})
+	assert.Equal(t, "1", (<-s.C).ID, "they should be equal")
+	_, ok := <-s.C
+	assert.False(t, ok, "the channel should be closed")
+}
+
+func TestTenantsAreSeparated(t *testing.T) {
+	b := NewBroker(10)
+	b.Publish(entities.Event{ID: "1", Type: entities.UserCreated, Tenant: "acme"})
+	s, replay, _ := b.Subscribe("globex", nil, "1", 10)
+	assert.Equal(t, 0, len(replay), "they should be equal")
+	b.Publish(entities.Event{ID: "2", Type: entities.UserCreated, Tenant: "acme"})
+	b.Publish(entities.Event{ID: "3", Type: entities.UserCreated, Tenant: "globex"})
+	assert.Equal(t, "3", (<-s.C).ID, "they should be equal")
+}
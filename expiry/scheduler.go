@@ -0,0 +1,143 @@
+package expiry
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/palantir/stacktrace"
+)
+
+// Store is what the scheduler needs from the storage: the passports about to expire and a way
+// to record the reminders so that they are published like any other event
+type Store interface {
+	ListExpiringPassports(ctx context.Context, before time.Time) ([]entities.Passport, error)
+	RecordEvent(ctx context.Context, typ string, data interface{}) error
+}
+
+// Options configures a Scheduler; zero values are replaced by defaults
+type Options struct {
+	// Passports expiring within this window are reminded of
+	Window time.Duration
+	// Interval between checks
+	Interval time.Duration
+	// Clock, time.Now by default
+	Now func() time.Time
+}
+
+// DefaultWindow is the reminder window used when none is configured
+const DefaultWindow = 90 * 24 * time.Hour
+
+// Scheduler periodically looks for passports expiring within the window and records a
+// passport.expiring event for each of them. Every passport is reminded of once per date of
+// expiry; the reminders sent are kept in memory, so a restart reminds again.
+type Scheduler struct {
+	stores   func() []Store
+	opts     Options
+	mu       sync.Mutex
+	reminded map[Store]map[string]time.Time
+	closed   chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewScheduler starts a scheduler checking the stores, one per tenant, right away and then at
+// every interval
+func NewScheduler(stores func() []Store, opts Options) *Scheduler {
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	s := &Scheduler{
+		stores:   stores,
+		opts:     opts,
+		reminded: make(map[Store]map[string]time.Time),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Check records reminders for the passports entering the window and returns how many it recorded
+func (s *Scheduler) Check(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, store := range s.stores() {
+		n, err := s.check(ctx, store)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (s *Scheduler) check(ctx context.Context, store Store) (int, error) {
+	now := s.opts.Now()
+	list, err := store.ListExpiringPassports(ctx, now.Add(s.opts.Window))
+	if err != nil {
+		return 0, stacktrace.Propagate(err, "can't list expiring passports")
+	}
+	reminded := s.reminded[store]
+	current := make(map[string]time.Time)
+	count := 0
+	for _, p := range list {
+		if !p.DateOfExpiry.After(now) {
+			continue
+		}
+		if expiry, ok := reminded[p.ID]; ok && expiry.Equal(p.DateOfExpiry) {
+			current[p.ID] = p.DateOfExpiry
+			continue
+		}
+		p.Status = entities.StatusExpiring
+		if err := store.RecordEvent(ctx, entities.PassportExpiring, p); err != nil {
+			return count, stacktrace.Propagate(err, "can't record reminder for passport %s", p.ID)
+		}
+		current[p.ID] = p.DateOfExpiry
+		count++
+	}
+	// forget the passports that expired, were renewed or deleted
+	s.reminded[store] = current
+	return count, nil
+}
+
+// Close stops the scheduler
+func (s *Scheduler) Close() {
+	s.once.Do(func() { close(s.closed) })
+	<-s.done
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+	// cancelled on close, so that a check in progress doesn't hold up the shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Check(ctx); err != nil {
+			log.Println(err)
+		}
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
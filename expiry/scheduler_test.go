@@ -0,0 +1,51 @@
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRemindsOncePerExpiry(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2024-01-01T00:00:00Z")
+	db := storage.NewMockDB()
+	db.AddPassport(t.Context(), entities.Passport{ID: "111", Authority: "HMPO", UserID: 0, DateOfExpiry: now.Add(-24 * time.Hour)})
+	db.AddPassport(t.Context(), entities.Passport{ID: "222", Authority: "HMPO", UserID: 0, DateOfExpiry: now.Add(24 * time.Hour)})
+	db.AddPassport(t.Context(), entities.Passport{ID: "333", Authority: "HMPO", UserID: 1, DateOfExpiry: now.Add(365 * 24 * time.Hour)})
+	db.AddPassport(t.Context(), entities.Passport{ID: "444", Authority: "HMPO", UserID: 1})
+	db.MarkPublished([]string{"1", "2", "3", "4"})
+
+	s := NewScheduler(func() []Store { return []Store{db} }, Options{Window: 30 * 24 * time.Hour, Interval: time.Hour, Now: func() time.Time { return now }})
+	s.Close()
+	count, err := s.Check(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count, "the first check happened on start")
+	events, _ := db.PendingEvents(0)
+	if assert.Equal(t, 1, len(events)) {
+		assert.Equal(t, entities.PassportExpiring, events[0].Type, "they should be equal")
+		assert.Equal(t, "222", events[0].Data.(entities.Passport).ID, "they should be equal")
+		assert.Equal(t, entities.StatusExpiring, events[0].Data.(entities.Passport).Status, "they should be equal")
+	}
+
+	// a renewal within the window is reminded of again
+	db.UpdatePassport(t.Context(), entities.Passport{ID: "222", Authority: "HMPO", UserID: 0, DateOfExpiry: now.Add(48 * time.Hour)})
+	count, _ = s.Check(t.Context())
+	assert.Equal(t, 1, count, "they should be equal")
+}
+
+func TestExpiryStatus(t *testing.T) {
+	now := time.Now()
+	window := 30 * 24 * time.Hour
+	for expiry, status := range map[time.Time]string{
+		{}:                  entities.StatusValid,
+		now.Add(-time.Hour): entities.StatusExpired,
+		now.Add(time.Hour):  entities.StatusExpiring,
+		now.Add(2 * window): entities.StatusValid,
+	} {
+		p := entities.Passport{DateOfExpiry: expiry}
+		assert.Equal(t, status, p.ExpiryStatus(now, window), "they should be equal")
+	}
+}
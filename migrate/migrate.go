@@ -0,0 +1,202 @@
+package migrate
+
+import (
+	"context"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/palantir/stacktrace"
+)
+
+// Migration is a numbered schema change along with the script undoing it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Driver applies migrations to a database and keeps track of its version
+type Driver interface {
+	// Lock blocks until the caller holds the migration lock of the database or ctx is done, so
+	// that two instances never migrate at the same time
+	Lock(ctx context.Context) error
+	// Unlock releases the migration lock
+	Unlock(ctx context.Context) error
+	// Version returns the version of the database, 0 before the first migration. Dirty means a
+	// script failed half way, leaving the database to be repaired by hand.
+	Version(ctx context.Context) (version int, dirty bool, err error)
+	// Apply runs a script and sets the version of the database to version
+	Apply(ctx context.Context, script string, version int) error
+}
+
+// Status describes a migration and whether the database has it
+type Status struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+var fileName = regexp.MustCompile(`^([0-9]+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Load reads the migrations of fsys, named NNNN_name.up.sql and NNNN_name.down.sql, in order of
+// version. Every migration needs both scripts, and versions must be positive and unique.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "can't list migrations")
+	}
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		m := fileName.FindStringSubmatch(f.Name())
+		if f.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil || version <= 0 {
+			return nil, stacktrace.NewError("migration %s has an invalid version", f.Name())
+		}
+		script, err := fs.ReadFile(fsys, f.Name())
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "can't read migration %s", f.Name())
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, stacktrace.NewError("migrations %s and %s share version %d", migration.Name, m[2], version)
+		}
+		if m[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+	var list []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, stacktrace.NewError("migration %d_%s needs both an up and a down script", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Migrator moves a database between the versions of its migrations
+type Migrator struct {
+	driver     Driver
+	migrations []Migration
+}
+
+// New returns a migrator applying migrations, sorted by version as Load returns them, through driver
+func New(driver Driver, migrations []Migration) *Migrator {
+	return &Migrator{driver: driver, migrations: migrations}
+}
+
+// Latest returns the version of the last migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies all pending migrations and returns the resulting version
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.To(ctx, m.Latest())
+}
+
+// Down rolls back the last applied migration and returns the resulting version
+func (m *Migrator) Down(ctx context.Context) (int, error) {
+	return m.migrate(ctx, func(current int) (int, error) {
+		if current == 0 {
+			return 0, nil
+		}
+		return m.previous(current), nil
+	})
+}
+
+// To migrates up or down to a version, 0 rolling back every migration, and returns it
+func (m *Migrator) To(ctx context.Context, version int) (int, error) {
+	return m.migrate(ctx, func(current int) (int, error) {
+		if version != 0 && m.index(version) < 0 {
+			return current, stacktrace.NewError("there is no migration %d", version)
+		}
+		return version, nil
+	})
+}
+
+// Status lists the migrations, telling which the database has, along with its version
+func (m *Migrator) Status(ctx context.Context) ([]Status, int, bool, error) {
+	current, dirty, err := m.driver.Version(ctx)
+	if err != nil {
+		return nil, 0, false, stacktrace.Propagate(err, "can't read the schema version")
+	}
+	list := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		list[i] = Status{Version: migration.Version, Name: migration.Name, Applied: migration.Version <= current}
+	}
+	return list, current, dirty, nil
+}
+
+// migrate takes the lock and walks one migration at a time from the current version to the one
+// target picks, so that a failure leaves the database at the last version that fully applied
+func (m *Migrator) migrate(ctx context.Context, target func(current int) (int, error)) (int, error) {
+	if err := m.driver.Lock(ctx); err != nil {
+		return 0, stacktrace.Propagate(err, "can't take the migration lock")
+	}
+	defer m.driver.Unlock(context.WithoutCancel(ctx))
+	current, dirty, err := m.driver.Version(ctx)
+	if err != nil {
+		return 0, stacktrace.Propagate(err, "can't read the schema version")
+	}
+	if dirty {
+		return current, stacktrace.NewError("schema version %d is dirty, a migration failed half way and needs repairing by hand", current)
+	}
+	if current != 0 && m.index(current) < 0 {
+		return current, stacktrace.NewError("schema version %d is unknown to this binary", current)
+	}
+	version, err := target(current)
+	if err != nil {
+		return current, err
+	}
+	for current < version {
+		next := m.migrations[m.index(current)+1]
+		if err := m.driver.Apply(ctx, next.Up, next.Version); err != nil {
+			return current, stacktrace.Propagate(err, "migration %d_%s failed", next.Version, next.Name)
+		}
+		current = next.Version
+	}
+	for current > version {
+		migration := m.migrations[m.index(current)]
+		previous := m.previous(current)
+		if err := m.driver.Apply(ctx, migration.Down, previous); err != nil {
+			return current, stacktrace.Propagate(err, "rolling back migration %d_%s failed", migration.Version, migration.Name)
+		}
+		current = previous
+	}
+	return current, nil
+}
+
+// index returns the position of a version in the migrations, -1 for version 0 or an unknown one
+func (m *Migrator) index(version int) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// previous returns the version preceding an applied one
+func (m *Migrator) previous(version int) int {
+	if i := m.index(version); i > 0 {
+		return m.migrations[i-1].Version
+	}
+	return 0
+}
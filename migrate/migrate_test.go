@@ -0,0 +1,154 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/kostiamol/go-rest-api-template/migrations"
+	"github.com/stretchr/testify/assert"
+)
+
+// memDriver records the scripts it runs; a script named fail fails
+type memDriver struct {
+	lock    chan struct{}
+	mu      sync.Mutex
+	version int
+	dirty   bool
+	applied []string
+}
+
+func newMemDriver() *memDriver {
+	return &memDriver{lock: make(chan struct{}, 1)}
+}
+
+func (d *memDriver) Lock(ctx context.Context) error {
+	select {
+	case d.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *memDriver) Unlock(ctx context.Context) error {
+	<-d.lock
+	return nil
+}
+
+func (d *memDriver) Version(ctx context.Context) (int, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.version, d.dirty, nil
+}
+
+func (d *memDriver) Apply(ctx context.Context, script string, version int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if script == "fail" {
+		return errors.New("syntax error")
+	}
+	d.applied = append(d.applied, script)
+	d.version = version
+	return nil
+}
+
+var testFS = fstest.MapFS{
+	"0001_users.up.sql":       {Data: []byte("create users")},
+	"0001_users.down.sql":     {Data: []byte("drop users")},
+	"0002_passports.up.sql":   {Data: []byte("create passports")},
+	"0002_passports.down.sql": {Data: []byte("drop passports")},
+	"0010_index.up.sql":       {Data: []byte("create index")},
+	"0010_index.down.sql":     {Data: []byte("drop index")},
+	"README.md":               {Data: []byte("not a migration")},
+}
+
+func TestLoad(t *testing.T) {
+	list, err := Load(testFS)
+	assert.Nil(t, err)
+	if assert.Equal(t, 3, len(list)) {
+		assert.Equal(t, Migration{Version: 10, Name: "index", Up: "create index", Down: "drop index"}, list[2], "they should be equal")
+	}
+
+	_, err = Load(fstest.MapFS{"0001_users.up.sql": {Data: []byte("create users")}})
+	assert.NotNil(t, err, "a migration needs a down script")
+	_, err = Load(fstest.MapFS{
+		"0001_users.up.sql":    {Data: []byte("create users")},
+		"0001_users.down.sql":  {Data: []byte("drop users")},
+		"0001_people.up.sql":   {Data: []byte("create people")},
+		"0001_people.down.sql": {Data: []byte("drop people")},
+	})
+	assert.NotNil(t, err, "versions are unique")
+
+	list, err = Load(migrations.FS)
+	assert.Nil(t, err, "the embedded migrations are valid")
+	assert.NotEqual(t, 0, len(list), "they should not be equal")
+}
+
+func TestMigrator(t *testing.T) {
+	list, _ := Load(testFS)
+	d := newMemDriver()
+	m := New(d, list)
+
+	version, err := m.Up(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, 10, version, "they should be equal")
+	assert.Equal(t, []string{"create users", "create passports", "create index"}, d.applied, "they should be equal")
+
+	version, _ = m.Down(t.Context())
+	assert.Equal(t, 2, version, "they should be equal")
+	version, _ = m.To(t.Context(), 0)
+	assert.Equal(t, 0, version, "they should be equal")
+	assert.Equal(t, []string{"create users", "create passports", "create index", "drop index", "drop passports", "drop users"}, d.applied, "they should be equal")
+
+	version, _ = m.Down(t.Context())
+	assert.Equal(t, 0, version, "nothing to roll back")
+	_, err = m.To(t.Context(), 3)
+	assert.NotNil(t, err, "there is no migration 3")
+
+	m.To(t.Context(), 1)
+	statuses, current, dirty, err := m.Status(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, current, "they should be equal")
+	assert.False(t, dirty)
+	assert.Equal(t, []Status{{1, "users", true}, {2, "passports", false}, {10, "index", false}}, statuses, "they should be equal")
+}
+
+func TestMigratorStopsAtFailure(t *testing.T) {
+	list, _ := Load(testFS)
+	list[1].Up = "fail"
+	d := newMemDriver()
+	version, err := New(d, list).Up(t.Context())
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, version, "the version is the last one that applied")
+
+	d.dirty = true
+	_, err = New(d, list).Down(t.Context())
+	assert.NotNil(t, err, "a dirty database isn't migrated")
+	assert.Equal(t, 1, d.version, "they should be equal")
+}
+
+func TestMigratorTakesLock(t *testing.T) {
+	list, _ := Load(testFS)
+	d := newMemDriver()
+	d.Lock(t.Context())
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := New(d, list).Up(ctx)
+	assert.NotNil(t, err, "another instance holds the lock")
+	assert.Equal(t, 0, len(d.applied), "they should be equal")
+	d.Unlock(t.Context())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			New(d, list).Up(t.Context())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, len(d.applied), "migrations are applied once")
+}
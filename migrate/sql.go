@@ -0,0 +1,262 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// SQLDriver migrates a database/sql database. The version is kept in the schema_migrations
+// table and the lock is a row of the schema_lock table, leased for LockTTL and renewed while
+// held, so that the lock of an instance that died is taken over once its lease runs out. An
+// instance failing to renew its lease stops migrating, as another one may take the lock over.
+type SQLDriver struct {
+	DB *sql.DB
+	// Dollar makes queries use $1 placeholders, as PostgreSQL does, instead of ?
+	Dollar bool
+	// How long the lock is leased, 1 minute by default
+	LockTTL time.Duration
+	// How often a waiting instance tries to take the lock, 1 second by default
+	PollInterval time.Duration
+
+	mu    sync.Mutex
+	owner string
+	// lease is cancelled, with the cause, once the lease of the lock can't be renewed
+	lease  context.Context
+	cancel context.CancelCauseFunc
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// init creates the bookkeeping tables
+func (d *SQLDriver) init(ctx context.Context) error {
+	for _, query := range []string{
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL, dirty BOOLEAN NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS schema_lock (id INTEGER PRIMARY KEY, owner VARCHAR(64) NOT NULL, expires_at BIGINT NOT NULL)",
+	} {
+		if _, err := d.DB.ExecContext(ctx, query); err != nil {
+			return stacktrace.Propagate(err, "can't create the migration tables")
+		}
+	}
+	return nil
+}
+
+// rebind rewrites the ? placeholders of a query for the database
+func (d *SQLDriver) rebind(query string) string {
+	if !d.Dollar {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (d *SQLDriver) lockTTL() time.Duration {
+	if d.LockTTL > 0 {
+		return d.LockTTL
+	}
+	return time.Minute
+}
+
+// Lock takes the lock row, waiting for the instance holding it to release it or let it expire
+func (d *SQLDriver) Lock(ctx context.Context) error {
+	if err := d.init(ctx); err != nil {
+		return err
+	}
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return stacktrace.Propagate(err, "can't generate the lock owner")
+	}
+	interval := d.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		ok, err := d.tryLock(ctx, hex.EncodeToString(owner))
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return stacktrace.Propagate(ctx.Err(), "gave up waiting for the migration lock")
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (d *SQLDriver) tryLock(ctx context.Context, owner string) (bool, error) {
+	now := time.Now()
+	if _, err := d.DB.ExecContext(ctx, d.rebind("DELETE FROM schema_lock WHERE id = 1 AND expires_at < ?"), now.UnixNano()); err != nil {
+		return false, stacktrace.Propagate(err, "can't clear an expired migration lock")
+	}
+	// the primary key lets a single instance insert the row
+	_, err := d.DB.ExecContext(ctx, d.rebind("INSERT INTO schema_lock (id, owner, expires_at) VALUES (1, ?, ?)"), owner, now.Add(d.lockTTL()).UnixNano())
+	if err != nil {
+		var held int
+		if d.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_lock WHERE id = 1").Scan(&held) == nil && held == 1 {
+			return false, nil
+		}
+		return false, stacktrace.Propagate(err, "can't take the migration lock")
+	}
+	lease, cancel := context.WithCancelCause(context.Background())
+	d.mu.Lock()
+	d.owner, d.lease, d.cancel = owner, lease, cancel
+	d.stop, d.done = make(chan struct{}), make(chan struct{})
+	go d.renew(owner, cancel, d.stop, d.done)
+	d.mu.Unlock()
+	return true, nil
+}
+
+// renew extends the lease of the lock until it is released, or cancels the lease with lost once
+// it can't
+func (d *SQLDriver) renew(owner string, lost context.CancelCauseFunc, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(d.lockTTL() / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := d.extend(owner); err != nil {
+				lost(err)
+				return
+			}
+		}
+	}
+}
+
+// extend renews the lease of the lock, failing if the lock is no longer held by owner, as happens
+// when its lease ran out and another instance took it over
+func (d *SQLDriver) extend(owner string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.lockTTL()/3)
+	defer cancel()
+	res, err := d.DB.ExecContext(ctx, d.rebind("UPDATE schema_lock SET expires_at = ? WHERE id = 1 AND owner = ?"), time.Now().Add(d.lockTTL()).UnixNano(), owner)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't renew the migration lock")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return stacktrace.NewError("lost the migration lock, its lease ran out")
+	}
+	return nil
+}
+
+// leased returns ctx, cancelled with the cause once the lease of the lock is lost
+func (d *SQLDriver) leased(ctx context.Context) (context.Context, func()) {
+	d.mu.Lock()
+	lease := d.lease
+	d.mu.Unlock()
+	if lease == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(lease, func() { cancel(context.Cause(lease)) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// Unlock releases the lock row
+func (d *SQLDriver) Unlock(ctx context.Context) error {
+	d.mu.Lock()
+	owner, cancel, stop, done := d.owner, d.cancel, d.stop, d.done
+	d.owner, d.lease, d.cancel, d.stop, d.done = "", nil, nil, nil, nil
+	d.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+	cancel(nil)
+	if _, err := d.DB.ExecContext(ctx, d.rebind("DELETE FROM schema_lock WHERE id = 1 AND owner = ?"), owner); err != nil {
+		return stacktrace.Propagate(err, "can't release the migration lock")
+	}
+	return nil
+}
+
+// Version reads the version row, 0 if there is none yet
+func (d *SQLDriver) Version(ctx context.Context) (int, bool, error) {
+	if err := d.init(ctx); err != nil {
+		return 0, false, err
+	}
+	var version int
+	var dirty bool
+	err := d.DB.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations").Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, stacktrace.Propagate(err, "can't read the schema version")
+	}
+	return version, dirty, nil
+}
+
+// Apply runs a script and sets the version in one transaction. The database is marked dirty at
+// the target version before the script runs: databases committing schema changes as they go, as
+// MySQL does, commit the mark too, so a failure half way leaves the database dirty rather than at
+// a version it doesn't have. The transaction is rolled back if the lease of the lock is lost.
+func (d *SQLDriver) Apply(ctx context.Context, script string, version int) error {
+	ctx, cancel := d.leased(ctx)
+	defer cancel()
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return stacktrace.Propagate(cause(ctx, err), "can't begin the migration")
+	}
+	if err := d.setVersion(ctx, tx, version, true); err != nil {
+		tx.Rollback()
+		return cause(ctx, err)
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return stacktrace.Propagate(cause(ctx, err), "can't run the migration")
+	}
+	if err := d.setVersion(ctx, tx, version, false); err != nil {
+		tx.Rollback()
+		return cause(ctx, err)
+	}
+	if ctx.Err() != nil {
+		tx.Rollback()
+		return stacktrace.Propagate(context.Cause(ctx), "can't commit the migration")
+	}
+	if err := tx.Commit(); err != nil {
+		return stacktrace.Propagate(cause(ctx, err), "can't commit the migration")
+	}
+	return nil
+}
+
+// cause returns why ctx is done, such as a lost lease, rather than the err it caused, if it is
+func cause(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+func (d *SQLDriver) setVersion(ctx context.Context, tx *sql.Tx, version int, dirty bool) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations"); err != nil {
+		return stacktrace.Propagate(err, "can't set the schema version")
+	}
+	if _, err := tx.ExecContext(ctx, d.rebind("INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)"), version, dirty); err != nil {
+		return stacktrace.Propagate(err, "can't set the schema version")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/migrations"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// leaseDB is a database answering the queries of SQLDriver. A script named block runs until it
+// is cancelled, and the lease of the lock can't be renewed from then on.
+type leaseDB struct {
+	mu        sync.Mutex
+	lost      bool
+	pending   []string
+	committed []string
+}
+
+func (db *leaseDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return leaseConn{db}, nil
+}
+
+func (db *leaseDB) Driver() driver.Driver {
+	return nil
+}
+
+func (db *leaseDB) scripts() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.committed
+}
+
+type leaseConn struct {
+	db *leaseDB
+}
+
+func (c leaseConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "UPDATE schema_lock"):
+		if db.lost {
+			return driver.RowsAffected(0), nil
+		}
+	case query == "block":
+		db.lost = true
+		db.mu.Unlock()
+		<-ctx.Done()
+		db.mu.Lock()
+		return nil, ctx.Err()
+	case !strings.Contains(query, "schema_"):
+		db.pending = append(db.pending, query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// QueryContext finds no rows, as in a database never migrated
+func (c leaseConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return noRows{}, nil
+}
+
+func (c leaseConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c leaseConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.committed = append(c.db.committed, c.db.pending...)
+	c.db.pending = nil
+	return nil
+}
+
+func (c leaseConn) Rollback() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.pending = nil
+	return nil
+}
+
+func (c leaseConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c leaseConn) Close() error {
+	return nil
+}
+
+type noRows struct{}
+
+func (noRows) Columns() []string {
+	return []string{"version", "dirty"}
+}
+
+func (noRows) Close() error {
+	return nil
+}
+
+func (noRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
+
+func TestSQLDriverLosesLease(t *testing.T) {
+	fake := &leaseDB{}
+	db := sql.OpenDB(fake)
+	defer db.Close()
+	list, _ := Load(testFS)
+	list[1].Up = "block"
+	d := &SQLDriver{DB: db, LockTTL: 30 * time.Millisecond}
+
+	// the migration running when the lease can't be renewed is rolled back, and no other runs
+	version, err := New(d, list).Up(t.Context())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "lost the migration lock")
+	}
+	assert.Equal(t, 1, version, "they should be equal")
+	assert.Equal(t, []string{"create users"}, fake.scripts(), "they should be equal")
+
+	// the lease is renewed while it can be
+	fake.lost = false
+	assert.Nil(t, d.Lock(t.Context()))
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, d.Apply(t.Context(), "create index", 10))
+	assert.Nil(t, d.Unlock(t.Context()))
+}
+
+func TestSQLDriverPostgres(t *testing.T) {
+	url := os.Getenv("MIGRATE_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("MIGRATE_TEST_DATABASE_URL isn't set")
+	}
+	db, err := sql.Open("postgres", url)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer db.Close()
+	list, err := Load(migrations.FS)
+	assert.Nil(t, err)
+	newDriver := func() *SQLDriver {
+		return &SQLDriver{DB: db, Dollar: true, LockTTL: time.Second, PollInterval: 10 * time.Millisecond}
+	}
+	d := newDriver()
+	m := New(d, list)
+	ctx := t.Context()
+	defer db.Exec("DROP TABLE IF EXISTS schema_migrations, schema_lock")
+	version, err := m.To(ctx, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, version, "they should be equal")
+
+	version, err = m.Up(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Latest(), version, "they should be equal")
+	statuses, current, dirty, err := m.Status(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Latest(), current, "they should be equal")
+	assert.False(t, dirty)
+	for _, s := range statuses {
+		assert.True(t, s.Applied, "migration %d is applied", s.Version)
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO users (tenant, id, first_name, last_name) VALUES ('acme', 1, 'Ada', 'Lovelace')")
+	assert.Nil(t, err)
+
+	version, err = m.Down(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, list[len(list)-2].Version, version, "they should be equal")
+
+	// a failing script leaves the version alone, as PostgreSQL rolls schema changes back
+	broken := append([]Migration{}, list...)
+	broken[len(broken)-1].Up = "CREATE INDEX broken ON missing (id)"
+	_, err = New(d, broken).Up(ctx)
+	assert.NotNil(t, err)
+	_, current, dirty, err = m.Status(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, version, current, "they should be equal")
+	assert.False(t, dirty)
+
+	// another instance waits for the lock
+	assert.Nil(t, d.Lock(ctx))
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, newDriver().Lock(waitCtx))
+
+	// and the lease is lost once the lock is taken over
+	_, err = db.ExecContext(ctx, "DELETE FROM schema_lock")
+	assert.Nil(t, err)
+	time.Sleep(500 * time.Millisecond)
+	err = d.Apply(ctx, "SELECT 1", version)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "lost the migration lock")
+	}
+	assert.Nil(t, d.Unlock(ctx))
+
+	version, err = m.To(ctx, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, version, "they should be equal")
+}
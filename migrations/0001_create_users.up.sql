@@ -0,0 +1,9 @@
+CREATE TABLE users (
+    tenant            VARCHAR(63)  NOT NULL,
+    id                INTEGER      NOT NULL,
+    first_name        VARCHAR(255) NOT NULL,
+    last_name         VARCHAR(255) NOT NULL,
+    date_of_birth     TIMESTAMP,
+    location_of_birth VARCHAR(255),
+    PRIMARY KEY (tenant, id)
+);
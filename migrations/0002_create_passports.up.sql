@@ -0,0 +1,11 @@
+CREATE TABLE passports (
+    tenant         VARCHAR(63) NOT NULL,
+    id             VARCHAR(63) NOT NULL,
+    user_id        INTEGER     NOT NULL,
+    authority      VARCHAR(63) NOT NULL,
+    date_of_issue  TIMESTAMP,
+    date_of_expiry TIMESTAMP,
+    PRIMARY KEY (tenant, id)
+);
+
+CREATE INDEX passports_user ON passports (tenant, user_id);
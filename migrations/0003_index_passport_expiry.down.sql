@@ -0,0 +1 @@
+DROP INDEX passports_expiry;
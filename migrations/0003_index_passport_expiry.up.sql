@@ -0,0 +1 @@
+CREATE INDEX passports_expiry ON passports (date_of_expiry);
@@ -0,0 +1,9 @@
+package migrations
+
+import "embed"
+
+// FS holds the schema of the persistent storage as numbered migrations, embedded in the binary.
+// Every NNNN_name.up.sql has a NNNN_name.down.sql undoing it; package migrate applies them.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,140 @@
+package outbox
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/palantir/stacktrace"
+)
+
+// Store is the storage side of the outbox: mutations record their events in the same
+// transaction as the change, the relay reads them back and marks them once published
+type Store interface {
+	PendingEvents(limit int) ([]entities.Event, error)
+	MarkPublished(ids []string) error
+	// OutboxNotify signals new events so they are published without waiting for the next poll
+	OutboxNotify() <-chan struct{}
+}
+
+// Sink is a destination of the published events, e.g. a webhook dispatcher or an event stream
+type Sink interface {
+	Publish(e entities.Event) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc func(e entities.Event) error
+
+// Publish calls f(e)
+func (f SinkFunc) Publish(e entities.Event) error {
+	return f(e)
+}
+
+// Options configures a Relay; zero values are replaced by defaults
+type Options struct {
+	// Interval between polls of the store, which also paces the retries of failed events
+	Interval time.Duration
+	// Maximum number of events read at once
+	BatchSize int
+}
+
+// Relay publishes the events of a store to its sinks in order. An event is marked published only
+// once every sink has accepted it, so delivery is at least once: after a failure or a crash the
+// sinks that already got it see it again.
+type Relay struct {
+	store  Store
+	sinks  []Sink
+	opts   Options
+	mu     sync.Mutex
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewRelay starts relaying the events of store to sinks in a background goroutine
+func NewRelay(store Store, opts Options, sinks ...Sink) *Relay {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	r := &Relay{
+		store:  store,
+		sinks:  sinks,
+		opts:   opts,
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Flush publishes the pending events now
+func (r *Relay) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		events, err := r.store.PendingEvents(r.opts.BatchSize)
+		if err != nil {
+			return stacktrace.Propagate(err, "can't read outbox")
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		var ids []string
+		var failed error
+		for _, e := range events {
+			if failed = r.publish(e); failed != nil {
+				// keep the order: later events wait for this one
+				break
+			}
+			ids = append(ids, e.ID)
+		}
+		if len(ids) > 0 {
+			if err := r.store.MarkPublished(ids); err != nil {
+				return stacktrace.Propagate(err, "can't mark events published")
+			}
+		}
+		if failed != nil {
+			return failed
+		}
+	}
+}
+
+// Close stops the relay after publishing what is pending
+func (r *Relay) Close() {
+	r.once.Do(func() { close(r.closed) })
+	<-r.done
+}
+
+func (r *Relay) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	notify := r.store.OutboxNotify()
+	for {
+		select {
+		case <-r.closed:
+			if err := r.Flush(); err != nil {
+				log.Println(err)
+			}
+			return
+		case <-notify:
+		case <-ticker.C:
+		}
+		if err := r.Flush(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (r *Relay) publish(e entities.Event) error {
+	for _, s := range r.sinks {
+		if err := s.Publish(e); err != nil {
+			return stacktrace.Propagate(err, "can't publish event %s", e.ID)
+		}
+	}
+	return nil
+}
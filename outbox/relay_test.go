@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder is a sink remembering the ids it got, failing while fail is set
+type recorder struct {
+	mu   sync.Mutex
+	ids  []string
+	fail bool
+}
+
+func (r *recorder) Publish(e entities.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		return errors.New("sink is down")
+	}
+	r.ids = append(r.ids, e.ID)
+	return nil
+}
+
+func (r *recorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.ids...)
+}
+
+func TestRelayPublishesInOrder(t *testing.T) {
+	db := storage.NewMockDB()
+	sink := &recorder{}
+	r := NewRelay(db, Options{Interval: time.Hour}, sink)
+	defer r.Close()
+	db.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	db.AddPassport(t.Context(), entities.Passport{ID: "123", UserID: 2})
+	db.DeletePassport(t.Context(), "123")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.received()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, []string{"1", "2", "3"}, sink.received(), "they should be equal")
+	pending, _ := db.PendingEvents(0)
+	assert.Equal(t, 0, len(pending), "published events leave the outbox")
+}
+
+func TestRelayKeepsEventsUntilEverySinkAcceptsThem(t *testing.T) {
+	db := storage.NewMockDB()
+	first, second := &recorder{}, &recorder{fail: true}
+	r := NewRelay(db, Options{Interval: time.Hour}, first, second)
+	r.Close()
+	db.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	db.DeleteUser(t.Context(), 2, storage.DeleteOptions{})
+	assert.NotNil(t, r.Flush())
+	pending, _ := db.PendingEvents(0)
+	assert.Equal(t, 2, len(pending), "they should be equal")
+
+	second.fail = false
+	assert.Nil(t, r.Flush())
+	assert.Equal(t, []string{"1", "1", "2"}, first.received(), "delivery is at least once")
+	assert.Equal(t, []string{"1", "2"}, second.received(), "they should be equal")
+	pending, _ = db.PendingEvents(0)
+	assert.Equal(t, 0, len(pending), "they should be equal")
+}
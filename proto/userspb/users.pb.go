@@ -0,0 +1,1024 @@
+// gRPC definition of the go-rest-api-template service. It mirrors the routes in svc/routes.go and
+// is served by svc.RunGRPC. The Go code in proto/userspb is generated from it, run make proto
+// after changing it.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: users.proto
+
+package userspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type User struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Id              int32                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	FirstName       string                 `protobuf:"bytes,2,opt,name=first_name,json=firstName,proto3" json:"first_name,omitempty"`
+	LastName        string                 `protobuf:"bytes,3,opt,name=last_name,json=lastName,proto3" json:"last_name,omitempty"`
+	DateOfBirth     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=date_of_birth,json=dateOfBirth,proto3" json:"date_of_birth,omitempty"`
+	LocationOfBirth string                 `protobuf:"bytes,5,opt,name=location_of_birth,json=locationOfBirth,proto3" json:"location_of_birth,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *User) Reset() {
+	*x = User{}
+	mi := &file_users_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *User) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*User) ProtoMessage() {}
+
+func (x *User) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use User.ProtoReflect.Descriptor instead.
+func (*User) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *User) GetId() int32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *User) GetFirstName() string {
+	if x != nil {
+		return x.FirstName
+	}
+	return ""
+}
+
+func (x *User) GetLastName() string {
+	if x != nil {
+		return x.LastName
+	}
+	return ""
+}
+
+func (x *User) GetDateOfBirth() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DateOfBirth
+	}
+	return nil
+}
+
+func (x *User) GetLocationOfBirth() string {
+	if x != nil {
+		return x.LocationOfBirth
+	}
+	return ""
+}
+
+type Passport struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Id           string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	DateOfIssue  *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=date_of_issue,json=dateOfIssue,proto3" json:"date_of_issue,omitempty"`
+	DateOfExpiry *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=date_of_expiry,json=dateOfExpiry,proto3" json:"date_of_expiry,omitempty"`
+	Authority    string                 `protobuf:"bytes,4,opt,name=authority,proto3" json:"authority,omitempty"`
+	UserId       int32                  `protobuf:"varint,5,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	// Computed on output: valid, expiring or expired
+	Status        string `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Passport) Reset() {
+	*x = Passport{}
+	mi := &file_users_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Passport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Passport) ProtoMessage() {}
+
+func (x *Passport) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Passport.ProtoReflect.Descriptor instead.
+func (*Passport) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Passport) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Passport) GetDateOfIssue() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DateOfIssue
+	}
+	return nil
+}
+
+func (x *Passport) GetDateOfExpiry() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DateOfExpiry
+	}
+	return nil
+}
+
+func (x *Passport) GetAuthority() string {
+	if x != nil {
+		return x.Authority
+	}
+	return ""
+}
+
+func (x *Passport) GetUserId() int32 {
+	if x != nil {
+		return x.UserId
+	}
+	return 0
+}
+
+func (x *Passport) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+type UserRecord struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	User          *User                  `protobuf:"bytes,1,opt,name=user,proto3" json:"user,omitempty"`
+	Passports     []*Passport            `protobuf:"bytes,2,rep,name=passports,proto3" json:"passports,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserRecord) Reset() {
+	*x = UserRecord{}
+	mi := &file_users_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserRecord) ProtoMessage() {}
+
+func (x *UserRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserRecord.ProtoReflect.Descriptor instead.
+func (*UserRecord) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UserRecord) GetUser() *User {
+	if x != nil {
+		return x.User
+	}
+	return nil
+}
+
+func (x *UserRecord) GetPassports() []*Passport {
+	if x != nil {
+		return x.Passports
+	}
+	return nil
+}
+
+type HealthResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SvcName       string                 `protobuf:"bytes,1,opt,name=svc_name,json=svcName,proto3" json:"svc_name,omitempty"`
+	Version       string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HealthResponse) Reset() {
+	*x = HealthResponse{}
+	mi := &file_users_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HealthResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HealthResponse) ProtoMessage() {}
+
+func (x *HealthResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HealthResponse.ProtoReflect.Descriptor instead.
+func (*HealthResponse) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *HealthResponse) GetSvcName() string {
+	if x != nil {
+		return x.SvcName
+	}
+	return ""
+}
+
+func (x *HealthResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+type UserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uid           int32                  `protobuf:"varint,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserRequest) Reset() {
+	*x = UserRequest{}
+	mi := &file_users_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserRequest) ProtoMessage() {}
+
+func (x *UserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserRequest.ProtoReflect.Descriptor instead.
+func (*UserRequest) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UserRequest) GetUid() int32 {
+	if x != nil {
+		return x.Uid
+	}
+	return 0
+}
+
+type PassportRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pid           string                 `protobuf:"bytes,1,opt,name=pid,proto3" json:"pid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PassportRequest) Reset() {
+	*x = PassportRequest{}
+	mi := &file_users_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PassportRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PassportRequest) ProtoMessage() {}
+
+func (x *PassportRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PassportRequest.ProtoReflect.Descriptor instead.
+func (*PassportRequest) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PassportRequest) GetPid() string {
+	if x != nil {
+		return x.Pid
+	}
+	return ""
+}
+
+type DeleteUserRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Uid   int32                  `protobuf:"varint,1,opt,name=uid,proto3" json:"uid,omitempty"`
+	// Report what would be deleted without deleting it
+	DryRun        bool `protobuf:"varint,2,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteUserRequest) Reset() {
+	*x = DeleteUserRequest{}
+	mi := &file_users_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteUserRequest) ProtoMessage() {}
+
+func (x *DeleteUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteUserRequest.ProtoReflect.Descriptor instead.
+func (*DeleteUserRequest) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteUserRequest) GetUid() int32 {
+	if x != nil {
+		return x.Uid
+	}
+	return 0
+}
+
+func (x *DeleteUserRequest) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+// What deleting a user did, or would do for a dry run. The passports of the user are handled by
+// the delete policy of the server: restrict, cascade or detach.
+type Deletion struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	UserId           int32                  `protobuf:"varint,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Policy           string                 `protobuf:"bytes,2,opt,name=policy,proto3" json:"policy,omitempty"`
+	DryRun           bool                   `protobuf:"varint,3,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	DeletedPassports []string               `protobuf:"bytes,4,rep,name=deleted_passports,json=deletedPassports,proto3" json:"deleted_passports,omitempty"`
+	// Passports left without a holder, whose user_id is -1
+	DetachedPassports []string `protobuf:"bytes,5,rep,name=detached_passports,json=detachedPassports,proto3" json:"detached_passports,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *Deletion) Reset() {
+	*x = Deletion{}
+	mi := &file_users_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Deletion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Deletion) ProtoMessage() {}
+
+func (x *Deletion) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Deletion.ProtoReflect.Descriptor instead.
+func (*Deletion) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Deletion) GetUserId() int32 {
+	if x != nil {
+		return x.UserId
+	}
+	return 0
+}
+
+func (x *Deletion) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *Deletion) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+func (x *Deletion) GetDeletedPassports() []string {
+	if x != nil {
+		return x.DeletedPassports
+	}
+	return nil
+}
+
+func (x *Deletion) GetDetachedPassports() []string {
+	if x != nil {
+		return x.DetachedPassports
+	}
+	return nil
+}
+
+type ListPassportsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only passports expiring before this time, soonest first
+	ExpiringBefore *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=expiring_before,json=expiringBefore,proto3" json:"expiring_before,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ListPassportsRequest) Reset() {
+	*x = ListPassportsRequest{}
+	mi := &file_users_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPassportsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPassportsRequest) ProtoMessage() {}
+
+func (x *ListPassportsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPassportsRequest.ProtoReflect.Descriptor instead.
+func (*ListPassportsRequest) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListPassportsRequest) GetExpiringBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiringBefore
+	}
+	return nil
+}
+
+type ListUsersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Users         []*User                `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	Count         int32                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListUsersResponse) Reset() {
+	*x = ListUsersResponse{}
+	mi := &file_users_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListUsersResponse) ProtoMessage() {}
+
+func (x *ListUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListUsersResponse.ProtoReflect.Descriptor instead.
+func (*ListUsersResponse) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListUsersResponse) GetUsers() []*User {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+func (x *ListUsersResponse) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type ListPassportsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Passports     []*Passport            `protobuf:"bytes,1,rep,name=passports,proto3" json:"passports,omitempty"`
+	Count         int32                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListPassportsResponse) Reset() {
+	*x = ListPassportsResponse{}
+	mi := &file_users_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListPassportsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPassportsResponse) ProtoMessage() {}
+
+func (x *ListPassportsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPassportsResponse.ProtoReflect.Descriptor instead.
+func (*ListPassportsResponse) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListPassportsResponse) GetPassports() []*Passport {
+	if x != nil {
+		return x.Passports
+	}
+	return nil
+}
+
+func (x *ListPassportsResponse) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type BatchUsersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*UserRecord          `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	Atomic        bool                   `protobuf:"varint,2,opt,name=atomic,proto3" json:"atomic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchUsersRequest) Reset() {
+	*x = BatchUsersRequest{}
+	mi := &file_users_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchUsersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchUsersRequest) ProtoMessage() {}
+
+func (x *BatchUsersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchUsersRequest.ProtoReflect.Descriptor instead.
+func (*BatchUsersRequest) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *BatchUsersRequest) GetRecords() []*UserRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *BatchUsersRequest) GetAtomic() bool {
+	if x != nil {
+		return x.Atomic
+	}
+	return false
+}
+
+type BatchResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Index int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	// HTTP status code of the item, as in the REST API
+	Status        int32       `protobuf:"varint,2,opt,name=status,proto3" json:"status,omitempty"`
+	Message       string      `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	Record        *UserRecord `protobuf:"bytes,4,opt,name=record,proto3" json:"record,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchResult) Reset() {
+	*x = BatchResult{}
+	mi := &file_users_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchResult) ProtoMessage() {}
+
+func (x *BatchResult) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchResult.ProtoReflect.Descriptor instead.
+func (*BatchResult) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *BatchResult) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *BatchResult) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *BatchResult) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *BatchResult) GetRecord() *UserRecord {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+type BatchUsersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Created       int32                  `protobuf:"varint,1,opt,name=created,proto3" json:"created,omitempty"`
+	Failed        int32                  `protobuf:"varint,2,opt,name=failed,proto3" json:"failed,omitempty"`
+	Results       []*BatchResult         `protobuf:"bytes,3,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchUsersResponse) Reset() {
+	*x = BatchUsersResponse{}
+	mi := &file_users_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchUsersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchUsersResponse) ProtoMessage() {}
+
+func (x *BatchUsersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_users_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchUsersResponse.ProtoReflect.Descriptor instead.
+func (*BatchUsersResponse) Descriptor() ([]byte, []int) {
+	return file_users_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *BatchUsersResponse) GetCreated() int32 {
+	if x != nil {
+		return x.Created
+	}
+	return 0
+}
+
+func (x *BatchUsersResponse) GetFailed() int32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *BatchUsersResponse) GetResults() []*BatchResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+var File_users_proto protoreflect.FileDescriptor
+
+const file_users_proto_rawDesc = "" +
+	"\n" +
+	"\vusers.proto\x12\tgorest.v1\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xbe\x01\n" +
+	"\x04User\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x05R\x02id\x12\x1d\n" +
+	"\n" +
+	"first_name\x18\x02 \x01(\tR\tfirstName\x12\x1b\n" +
+	"\tlast_name\x18\x03 \x01(\tR\blastName\x12>\n" +
+	"\rdate_of_birth\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\vdateOfBirth\x12*\n" +
+	"\x11location_of_birth\x18\x05 \x01(\tR\x0flocationOfBirth\"\xeb\x01\n" +
+	"\bPassport\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12>\n" +
+	"\rdate_of_issue\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\vdateOfIssue\x12@\n" +
+	"\x0edate_of_expiry\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\fdateOfExpiry\x12\x1c\n" +
+	"\tauthority\x18\x04 \x01(\tR\tauthority\x12\x17\n" +
+	"\auser_id\x18\x05 \x01(\x05R\x06userId\x12\x16\n" +
+	"\x06status\x18\x06 \x01(\tR\x06status\"d\n" +
+	"\n" +
+	"UserRecord\x12#\n" +
+	"\x04user\x18\x01 \x01(\v2\x0f.gorest.v1.UserR\x04user\x121\n" +
+	"\tpassports\x18\x02 \x03(\v2\x13.gorest.v1.PassportR\tpassports\"E\n" +
+	"\x0eHealthResponse\x12\x19\n" +
+	"\bsvc_name\x18\x01 \x01(\tR\asvcName\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\"\x1f\n" +
+	"\vUserRequest\x12\x10\n" +
+	"\x03uid\x18\x01 \x01(\x05R\x03uid\"#\n" +
+	"\x0fPassportRequest\x12\x10\n" +
+	"\x03pid\x18\x01 \x01(\tR\x03pid\">\n" +
+	"\x11DeleteUserRequest\x12\x10\n" +
+	"\x03uid\x18\x01 \x01(\x05R\x03uid\x12\x17\n" +
+	"\adry_run\x18\x02 \x01(\bR\x06dryRun\"\xb0\x01\n" +
+	"\bDeletion\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\x05R\x06userId\x12\x16\n" +
+	"\x06policy\x18\x02 \x01(\tR\x06policy\x12\x17\n" +
+	"\adry_run\x18\x03 \x01(\bR\x06dryRun\x12+\n" +
+	"\x11deleted_passports\x18\x04 \x03(\tR\x10deletedPassports\x12-\n" +
+	"\x12detached_passports\x18\x05 \x03(\tR\x11detachedPassports\"[\n" +
+	"\x14ListPassportsRequest\x12C\n" +
+	"\x0fexpiring_before\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x0eexpiringBefore\"P\n" +
+	"\x11ListUsersResponse\x12%\n" +
+	"\x05users\x18\x01 \x03(\v2\x0f.gorest.v1.UserR\x05users\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x05R\x05count\"`\n" +
+	"\x15ListPassportsResponse\x121\n" +
+	"\tpassports\x18\x01 \x03(\v2\x13.gorest.v1.PassportR\tpassports\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x05R\x05count\"\\\n" +
+	"\x11BatchUsersRequest\x12/\n" +
+	"\arecords\x18\x01 \x03(\v2\x15.gorest.v1.UserRecordR\arecords\x12\x16\n" +
+	"\x06atomic\x18\x02 \x01(\bR\x06atomic\"\x84\x01\n" +
+	"\vBatchResult\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\x05R\x06status\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x12-\n" +
+	"\x06record\x18\x04 \x01(\v2\x15.gorest.v1.UserRecordR\x06record\"x\n" +
+	"\x12BatchUsersResponse\x12\x18\n" +
+	"\acreated\x18\x01 \x01(\x05R\acreated\x12\x16\n" +
+	"\x06failed\x18\x02 \x01(\x05R\x06failed\x120\n" +
+	"\aresults\x18\x03 \x03(\v2\x16.gorest.v1.BatchResultR\aresults2\x8d\a\n" +
+	"\vUserService\x12;\n" +
+	"\x06Health\x12\x16.google.protobuf.Empty\x1a\x19.gorest.v1.HealthResponse\x12A\n" +
+	"\tListUsers\x12\x16.google.protobuf.Empty\x1a\x1c.gorest.v1.ListUsersResponse\x122\n" +
+	"\aGetUser\x12\x16.gorest.v1.UserRequest\x1a\x0f.gorest.v1.User\x12.\n" +
+	"\n" +
+	"CreateUser\x12\x0f.gorest.v1.User\x1a\x0f.gorest.v1.User\x12.\n" +
+	"\n" +
+	"UpdateUser\x12\x0f.gorest.v1.User\x1a\x0f.gorest.v1.User\x12?\n" +
+	"\n" +
+	"DeleteUser\x12\x1c.gorest.v1.DeleteUserRequest\x1a\x13.gorest.v1.Deletion\x12I\n" +
+	"\n" +
+	"BatchUsers\x12\x1c.gorest.v1.BatchUsersRequest\x1a\x1d.gorest.v1.BatchUsersResponse\x129\n" +
+	"\x06Export\x12\x16.google.protobuf.Empty\x1a\x15.gorest.v1.UserRecord0\x01\x12R\n" +
+	"\rListPassports\x12\x1f.gorest.v1.ListPassportsRequest\x1a .gorest.v1.ListPassportsResponse\x12M\n" +
+	"\x11ListUserPassports\x12\x16.gorest.v1.UserRequest\x1a .gorest.v1.ListPassportsResponse\x12>\n" +
+	"\vGetPassport\x12\x1a.gorest.v1.PassportRequest\x1a\x13.gorest.v1.Passport\x12>\n" +
+	"\x12CreateUserPassport\x12\x13.gorest.v1.Passport\x1a\x13.gorest.v1.Passport\x12:\n" +
+	"\x0eUpdatePassport\x12\x13.gorest.v1.Passport\x1a\x13.gorest.v1.Passport\x12D\n" +
+	"\x0eDeletePassport\x12\x1a.gorest.v1.PassportRequest\x1a\x16.google.protobuf.EmptyB9Z7github.com/kostiamol/go-rest-api-template/proto/userspbb\x06proto3"
+
+var (
+	file_users_proto_rawDescOnce sync.Once
+	file_users_proto_rawDescData []byte
+)
+
+func file_users_proto_rawDescGZIP() []byte {
+	file_users_proto_rawDescOnce.Do(func() {
+		file_users_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_users_proto_rawDesc), len(file_users_proto_rawDesc)))
+	})
+	return file_users_proto_rawDescData
+}
+
+var file_users_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_users_proto_goTypes = []any{
+	(*User)(nil),                  // 0: gorest.v1.User
+	(*Passport)(nil),              // 1: gorest.v1.Passport
+	(*UserRecord)(nil),            // 2: gorest.v1.UserRecord
+	(*HealthResponse)(nil),        // 3: gorest.v1.HealthResponse
+	(*UserRequest)(nil),           // 4: gorest.v1.UserRequest
+	(*PassportRequest)(nil),       // 5: gorest.v1.PassportRequest
+	(*DeleteUserRequest)(nil),     // 6: gorest.v1.DeleteUserRequest
+	(*Deletion)(nil),              // 7: gorest.v1.Deletion
+	(*ListPassportsRequest)(nil),  // 8: gorest.v1.ListPassportsRequest
+	(*ListUsersResponse)(nil),     // 9: gorest.v1.ListUsersResponse
+	(*ListPassportsResponse)(nil), // 10: gorest.v1.ListPassportsResponse
+	(*BatchUsersRequest)(nil),     // 11: gorest.v1.BatchUsersRequest
+	(*BatchResult)(nil),           // 12: gorest.v1.BatchResult
+	(*BatchUsersResponse)(nil),    // 13: gorest.v1.BatchUsersResponse
+	(*timestamppb.Timestamp)(nil), // 14: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),         // 15: google.protobuf.Empty
+}
+var file_users_proto_depIdxs = []int32{
+	14, // 0: gorest.v1.User.date_of_birth:type_name -> google.protobuf.Timestamp
+	14, // 1: gorest.v1.Passport.date_of_issue:type_name -> google.protobuf.Timestamp
+	14, // 2: gorest.v1.Passport.date_of_expiry:type_name -> google.protobuf.Timestamp
+	0,  // 3: gorest.v1.UserRecord.user:type_name -> gorest.v1.User
+	1,  // 4: gorest.v1.UserRecord.passports:type_name -> gorest.v1.Passport
+	14, // 5: gorest.v1.ListPassportsRequest.expiring_before:type_name -> google.protobuf.Timestamp
+	0,  // 6: gorest.v1.ListUsersResponse.users:type_name -> gorest.v1.User
+	1,  // 7: gorest.v1.ListPassportsResponse.passports:type_name -> gorest.v1.Passport
+	2,  // 8: gorest.v1.BatchUsersRequest.records:type_name -> gorest.v1.UserRecord
+	2,  // 9: gorest.v1.BatchResult.record:type_name -> gorest.v1.UserRecord
+	12, // 10: gorest.v1.BatchUsersResponse.results:type_name -> gorest.v1.BatchResult
+	15, // 11: gorest.v1.UserService.Health:input_type -> google.protobuf.Empty
+	15, // 12: gorest.v1.UserService.ListUsers:input_type -> google.protobuf.Empty
+	4,  // 13: gorest.v1.UserService.GetUser:input_type -> gorest.v1.UserRequest
+	0,  // 14: gorest.v1.UserService.CreateUser:input_type -> gorest.v1.User
+	0,  // 15: gorest.v1.UserService.UpdateUser:input_type -> gorest.v1.User
+	6,  // 16: gorest.v1.UserService.DeleteUser:input_type -> gorest.v1.DeleteUserRequest
+	11, // 17: gorest.v1.UserService.BatchUsers:input_type -> gorest.v1.BatchUsersRequest
+	15, // 18: gorest.v1.UserService.Export:input_type -> google.protobuf.Empty
+	8,  // 19: gorest.v1.UserService.ListPassports:input_type -> gorest.v1.ListPassportsRequest
+	4,  // 20: gorest.v1.UserService.ListUserPassports:input_type -> gorest.v1.UserRequest
+	5,  // 21: gorest.v1.UserService.GetPassport:input_type -> gorest.v1.PassportRequest
+	1,  // 22: gorest.v1.UserService.CreateUserPassport:input_type -> gorest.v1.Passport
+	1,  // 23: gorest.v1.UserService.UpdatePassport:input_type -> gorest.v1.Passport
+	5,  // 24: gorest.v1.UserService.DeletePassport:input_type -> gorest.v1.PassportRequest
+	3,  // 25: gorest.v1.UserService.Health:output_type -> gorest.v1.HealthResponse
+	9,  // 26: gorest.v1.UserService.ListUsers:output_type -> gorest.v1.ListUsersResponse
+	0,  // 27: gorest.v1.UserService.GetUser:output_type -> gorest.v1.User
+	0,  // 28: gorest.v1.UserService.CreateUser:output_type -> gorest.v1.User
+	0,  // 29: gorest.v1.UserService.UpdateUser:output_type -> gorest.v1.User
+	7,  // 30: gorest.v1.UserService.DeleteUser:output_type -> gorest.v1.Deletion
+	13, // 31: gorest.v1.UserService.BatchUsers:output_type -> gorest.v1.BatchUsersResponse
+	2,  // 32: gorest.v1.UserService.Export:output_type -> gorest.v1.UserRecord
+	10, // 33: gorest.v1.UserService.ListPassports:output_type -> gorest.v1.ListPassportsResponse
+	10, // 34: gorest.v1.UserService.ListUserPassports:output_type -> gorest.v1.ListPassportsResponse
+	1,  // 35: gorest.v1.UserService.GetPassport:output_type -> gorest.v1.Passport
+	1,  // 36: gorest.v1.UserService.CreateUserPassport:output_type -> gorest.v1.Passport
+	1,  // 37: gorest.v1.UserService.UpdatePassport:output_type -> gorest.v1.Passport
+	15, // 38: gorest.v1.UserService.DeletePassport:output_type -> google.protobuf.Empty
+	25, // [25:39] is the sub-list for method output_type
+	11, // [11:25] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_users_proto_init() }
+func file_users_proto_init() {
+	if File_users_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_users_proto_rawDesc), len(file_users_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_users_proto_goTypes,
+		DependencyIndexes: file_users_proto_depIdxs,
+		MessageInfos:      file_users_proto_msgTypes,
+	}.Build()
+	File_users_proto = out.File
+	file_users_proto_goTypes = nil
+	file_users_proto_depIdxs = nil
+}
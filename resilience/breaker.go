@@ -0,0 +1,264 @@
+package resilience
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+)
+
+// Circuit breaker states
+const (
+	// Closed lets every call through
+	Closed = "closed"
+	// Open refuses every call until the cooldown has passed
+	Open = "open"
+	// HalfOpen lets a single probe through, whose outcome closes or reopens the breaker
+	HalfOpen = "half-open"
+)
+
+// BreakerOptions configures a Breaker; zero values are replaced by defaults
+type BreakerOptions struct {
+	// The breaker opens after this many consecutive failures
+	Threshold int
+	// How long the breaker stays open before probing the backend again
+	Cooldown time.Duration
+	// Failure reports whether an error is a failure of the backend, storage.Transient by
+	// default; errors of the request, such as a missing user, don't count
+	Failure func(error) bool
+	// Clock, time.Now by default
+	Now func() time.Time
+}
+
+// BreakerState is a snapshot of a breaker
+// swagger:response breakerState
+type BreakerState struct {
+	// closed, open or half-open
+	State string `json:"state"`
+	// Consecutive failures
+	Failures int `json:"failures"`
+	// When the breaker last opened
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+// Breaker is a circuit breaker guarding a backend. Once the backend keeps failing, calls fail fast
+// with a storage.EcodeUnavailable error instead of piling up on it; after a cooldown a single call
+// probes whether it has recovered. One breaker is meant to guard all the stores of a backend.
+type Breaker struct {
+	opts     BreakerOptions
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+// NewBreaker returns a closed breaker
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 5
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 30 * time.Second
+	}
+	if opts.Failure == nil {
+		opts.Failure = storage.Transient
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Breaker{opts: opts, state: Closed}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerState{State: b.state, Failures: b.failures}
+	if b.state == Open && !b.opts.Now().Before(b.openedAt.Add(b.opts.Cooldown)) {
+		// the next call will probe
+		s.State = HalfOpen
+	}
+	if !b.openedAt.IsZero() {
+		openedAt := b.openedAt
+		s.OpenedAt = &openedAt
+	}
+	return s
+}
+
+// Wrap returns store with its calls guarded by the breaker
+func (b *Breaker) Wrap(store domain.Store) domain.Store {
+	return &breakerStore{Store: store, breaker: b}
+}
+
+// allow reports whether a call may go ahead, turning an open breaker half-open once the cooldown
+// has passed
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if b.opts.Now().Before(b.openedAt.Add(b.opts.Cooldown)) {
+			return stacktrace.NewErrorWithCode(storage.EcodeUnavailable, "storage is unavailable")
+		}
+		b.state = HalfOpen
+		return nil
+	case HalfOpen:
+		// a probe is under way
+		return stacktrace.NewErrorWithCode(storage.EcodeUnavailable, "storage is unavailable")
+	}
+	return nil
+}
+
+// done records the outcome of a call that was allowed
+func (b *Breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || !b.opts.Failure(err) {
+		b.state = Closed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.opts.Threshold {
+		b.state = Open
+		b.openedAt = b.opts.Now()
+	}
+}
+
+// breakerStore guards the calls of a store with a breaker
+type breakerStore struct {
+	domain.Store
+	breaker *Breaker
+}
+
+// call runs fn unless the breaker is open
+func (s *breakerStore) call(fn func() error) error {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	s.breaker.done(err)
+	return err
+}
+
+func (s *breakerStore) ListUsers(ctx context.Context) (list []entities.User, err error) {
+	err = s.call(func() error {
+		list, err = s.Store.ListUsers(ctx)
+		return err
+	})
+	return list, err
+}
+
+func (s *breakerStore) GetUser(ctx context.Context, i int) (u entities.User, err error) {
+	err = s.call(func() error {
+		u, err = s.Store.GetUser(ctx, i)
+		return err
+	})
+	return u, err
+}
+
+func (s *breakerStore) GetUsers(ctx context.Context, ids []int) (list []entities.User, err error) {
+	err = s.call(func() error {
+		list, err = s.Store.GetUsers(ctx, ids)
+		return err
+	})
+	return list, err
+}
+
+func (s *breakerStore) AddUser(ctx context.Context, u entities.User) (entities.User, error) {
+	err := s.call(func() (err error) {
+		u, err = s.Store.AddUser(ctx, u)
+		return err
+	})
+	return u, err
+}
+
+func (s *breakerStore) UpdateUser(ctx context.Context, u entities.User) (entities.User, error) {
+	err := s.call(func() (err error) {
+		u, err = s.Store.UpdateUser(ctx, u)
+		return err
+	})
+	return u, err
+}
+
+func (s *breakerStore) DeleteUser(ctx context.Context, i int, opts storage.DeleteOptions) (d storage.Deletion, err error) {
+	err = s.call(func() error {
+		d, err = s.Store.DeleteUser(ctx, i, opts)
+		return err
+	})
+	return d, err
+}
+
+func (s *breakerStore) ListPassports(ctx context.Context) (list []entities.Passport, err error) {
+	err = s.call(func() error {
+		list, err = s.Store.ListPassports(ctx)
+		return err
+	})
+	return list, err
+}
+
+func (s *breakerStore) ListExpiringPassports(ctx context.Context, before time.Time) (list []entities.Passport, err error) {
+	err = s.call(func() error {
+		list, err = s.Store.ListExpiringPassports(ctx, before)
+		return err
+	})
+	return list, err
+}
+
+func (s *breakerStore) ListUserPassports(ctx context.Context, uid int) (list []entities.Passport, err error) {
+	err = s.call(func() error {
+		list, err = s.Store.ListUserPassports(ctx, uid)
+		return err
+	})
+	return list, err
+}
+
+func (s *breakerStore) ListPassportsOfUsers(ctx context.Context, uids []int) (list []entities.Passport, err error) {
+	err = s.call(func() error {
+		list, err = s.Store.ListPassportsOfUsers(ctx, uids)
+		return err
+	})
+	return list, err
+}
+
+func (s *breakerStore) GetPassport(ctx context.Context, id string) (p entities.Passport, err error) {
+	err = s.call(func() error {
+		p, err = s.Store.GetPassport(ctx, id)
+		return err
+	})
+	return p, err
+}
+
+func (s *breakerStore) AddPassport(ctx context.Context, p entities.Passport) (entities.Passport, error) {
+	err := s.call(func() (err error) {
+		p, err = s.Store.AddPassport(ctx, p)
+		return err
+	})
+	return p, err
+}
+
+func (s *breakerStore) UpdatePassport(ctx context.Context, p entities.Passport) (entities.Passport, error) {
+	err := s.call(func() (err error) {
+		p, err = s.Store.UpdatePassport(ctx, p)
+		return err
+	})
+	return p, err
+}
+
+func (s *breakerStore) DeletePassport(ctx context.Context, id string) error {
+	return s.call(func() error {
+		return s.Store.DeletePassport(ctx, id)
+	})
+}
+
+func (s *breakerStore) AddRecords(ctx context.Context, records []storage.Record) (added []storage.Record, err error) {
+	err = s.call(func() error {
+		added, err = s.Store.AddRecords(ctx, records)
+		return err
+	})
+	return added, err
+}
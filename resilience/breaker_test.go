@@ -0,0 +1,46 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewBreaker(BreakerOptions{Threshold: 2, Cooldown: time.Minute, Now: func() time.Time { return now }})
+	db := &flakyStore{Store: storage.NewMockDB(), failures: 5}
+	s := b.Wrap(db)
+
+	s.GetUser(t.Context(), 1)
+	assert.Equal(t, Closed, b.State().State, "they should be equal")
+	s.GetUser(t.Context(), 1)
+	assert.Equal(t, Open, b.State().State, "they should be equal")
+	assert.Equal(t, 2, b.State().Failures, "they should be equal")
+
+	_, err := s.GetUser(t.Context(), 1)
+	assert.Equal(t, storage.EcodeUnavailable, stacktrace.GetCode(err), "an open breaker fails fast")
+	assert.Equal(t, 2, db.calls, "they should be equal")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, HalfOpen, b.State().State, "they should be equal")
+	_, err = s.GetUser(t.Context(), 1)
+	assert.True(t, storage.Transient(err), "the probe reaches the store")
+	assert.Equal(t, Open, b.State().State, "a failed probe reopens the breaker")
+
+	db.failures = 0
+	now = now.Add(time.Minute)
+	u, err := s.GetUser(t.Context(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane", u.FirstName, "they should be equal")
+	assert.Equal(t, Closed, b.State().State, "a successful probe closes the breaker")
+	assert.Equal(t, 0, b.State().Failures, "they should be equal")
+
+	for i := 0; i < 3; i++ {
+		s.GetUser(t.Context(), 10)
+	}
+	assert.Equal(t, Closed, b.State().State, "errors of the request don't open the breaker")
+}
@@ -0,0 +1,141 @@
+package resilience
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+)
+
+// RetryOptions configures Retry; zero values are replaced by defaults
+type RetryOptions struct {
+	// Calls are given up after this many attempts
+	Attempts int
+	// Upper bound of the delay before the first retry, doubled for every further retry
+	InitialBackoff time.Duration
+	// Upper bound of the delay between retries
+	MaxBackoff time.Duration
+	// Retryable reports whether a failed call is worth retrying, storage.Transient by default
+	Retryable func(error) bool
+}
+
+// retryStore retries the reads of a store
+type retryStore struct {
+	domain.Store
+	opts RetryOptions
+}
+
+// Retry returns store with its reads retried on retryable errors, waiting a random delay of up to
+// an exponentially growing backoff between attempts ("full jitter"), so that clients recovering
+// from the same outage don't retry in lockstep. Writes aren't retried: a write that failed in
+// transit may have been applied, and applying it twice isn't safe for every write.
+func Retry(store domain.Store, opts RetryOptions) domain.Store {
+	if opts.Attempts <= 0 {
+		opts.Attempts = 3
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = 50 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Second
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = storage.Transient
+	}
+	return &retryStore{Store: store, opts: opts}
+}
+
+// do calls fn until it succeeds, fails for good or the context is done
+func (s *retryStore) do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !s.opts.Retryable(err) || attempt >= s.opts.Attempts {
+			return err
+		}
+		timer := time.NewTimer(s.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return stacktrace.Propagate(err, "gave up retrying: %v", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay before the given retry
+func (s *retryStore) backoff(attempt int) time.Duration {
+	ceiling := s.opts.MaxBackoff
+	if shifted := s.opts.InitialBackoff << uint(attempt-1); attempt < 32 && shifted > 0 && shifted < ceiling {
+		ceiling = shifted
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func (s *retryStore) ListUsers(ctx context.Context) (list []entities.User, err error) {
+	err = s.do(ctx, func() error {
+		list, err = s.Store.ListUsers(ctx)
+		return err
+	})
+	return list, err
+}
+
+func (s *retryStore) GetUser(ctx context.Context, i int) (u entities.User, err error) {
+	err = s.do(ctx, func() error {
+		u, err = s.Store.GetUser(ctx, i)
+		return err
+	})
+	return u, err
+}
+
+func (s *retryStore) GetUsers(ctx context.Context, ids []int) (list []entities.User, err error) {
+	err = s.do(ctx, func() error {
+		list, err = s.Store.GetUsers(ctx, ids)
+		return err
+	})
+	return list, err
+}
+
+func (s *retryStore) ListPassports(ctx context.Context) (list []entities.Passport, err error) {
+	err = s.do(ctx, func() error {
+		list, err = s.Store.ListPassports(ctx)
+		return err
+	})
+	return list, err
+}
+
+func (s *retryStore) ListExpiringPassports(ctx context.Context, before time.Time) (list []entities.Passport, err error) {
+	err = s.do(ctx, func() error {
+		list, err = s.Store.ListExpiringPassports(ctx, before)
+		return err
+	})
+	return list, err
+}
+
+func (s *retryStore) ListUserPassports(ctx context.Context, uid int) (list []entities.Passport, err error) {
+	err = s.do(ctx, func() error {
+		list, err = s.Store.ListUserPassports(ctx, uid)
+		return err
+	})
+	return list, err
+}
+
+func (s *retryStore) ListPassportsOfUsers(ctx context.Context, uids []int) (list []entities.Passport, err error) {
+	err = s.do(ctx, func() error {
+		list, err = s.Store.ListPassportsOfUsers(ctx, uids)
+		return err
+	})
+	return list, err
+}
+
+func (s *retryStore) GetPassport(ctx context.Context, id string) (p entities.Passport, err error) {
+	err = s.do(ctx, func() error {
+		p, err = s.Store.GetPassport(ctx, id)
+		return err
+	})
+	return p, err
+}
@@ -0,0 +1,77 @@
+package resilience
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyStore fails its next calls with a transient error
+type flakyStore struct {
+	domain.Store
+	failures int
+	calls    int
+}
+
+func (s *flakyStore) fail() error {
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return stacktrace.NewErrorWithCode(storage.EcodeTransient, "connection reset")
+	}
+	return nil
+}
+
+func (s *flakyStore) GetUser(ctx context.Context, i int) (entities.User, error) {
+	if err := s.fail(); err != nil {
+		return entities.User{}, err
+	}
+	return s.Store.GetUser(ctx, i)
+}
+
+func (s *flakyStore) AddUser(ctx context.Context, u entities.User) (entities.User, error) {
+	if err := s.fail(); err != nil {
+		return u, err
+	}
+	return s.Store.AddUser(ctx, u)
+}
+
+func TestRetry(t *testing.T) {
+	db := &flakyStore{Store: storage.NewMockDB(), failures: 2}
+	s := Retry(db, RetryOptions{InitialBackoff: time.Millisecond})
+	u, err := s.GetUser(t.Context(), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Jane", u.FirstName, "they should be equal")
+	assert.Equal(t, 3, db.calls, "they should be equal")
+
+	db.failures, db.calls = 3, 0
+	_, err = s.GetUser(t.Context(), 1)
+	assert.True(t, storage.Transient(err), "the last error is returned")
+	assert.Equal(t, 3, db.calls, "they should be equal")
+
+	db.failures, db.calls = 0, 0
+	_, err = s.GetUser(t.Context(), 10)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, db.calls, "errors of the request aren't retried")
+
+	db.failures, db.calls = 1, 0
+	_, err = s.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, db.calls, "writes aren't retried")
+}
+
+func TestRetryStopsWithContext(t *testing.T) {
+	db := &flakyStore{Store: storage.NewMockDB(), failures: 10}
+	s := Retry(db, RetryOptions{Attempts: 10, InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.GetUser(ctx, 1)
+	assert.True(t, storage.Transient(err), "they should be equal")
+	assert.True(t, db.calls < 10, "no retries after the deadline")
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/palantir/stacktrace"
+)
+
+// Record is a user along with the passports it holds, the unit of batch imports. A record whose
+// user id is NoHolder carries detached passports and no user.
+type Record struct {
+	User      entities.User
+	Passports []entities.Passport
+}
+
+// Detached reports whether the record carries detached passports rather than a user
+func (r Record) Detached() bool {
+	return r.User.ID == NoHolder
+}
+
+// RecordError is the failure of a batch of records, caused by the record at Index
+type RecordError struct {
+	Index int
+	Err   error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the record
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// AddRecords adds users under new ids along with their passports, and detached passports. Either
+// every record is added or none is: the records are checked before anything changes, and the
+// changes and their events are made under a single lock, so that no reader sees part of a batch.
+func (db *MockDB) AddRecords(ctx context.Context, records []Record) ([]Record, error) {
+	if err := db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.Unlock()
+	ids := make(map[string]bool)
+	for i, r := range records {
+		for _, p := range r.Passports {
+			if _, ok := db.PassportList[p.ID]; ok || ids[p.ID] {
+				return nil, &RecordError{Index: i, Err: stacktrace.NewError("Failure trying to add passport with duplicate id")}
+			}
+			ids[p.ID] = true
+		}
+	}
+	added := make([]Record, len(records))
+	for i, r := range records {
+		u := r.User
+		if !r.Detached() {
+			db.MaxUserID++
+			u.ID = db.MaxUserID
+			db.UserList[u.ID] = u
+			db.record(entities.UserCreated, u)
+		}
+		added[i].User = u
+		for _, p := range r.Passports {
+			p.UserID = u.ID
+			p.Status = ""
+			db.PassportList[p.ID] = p
+			db.record(entities.PassportCreated, p)
+			added[i].Passports = append(added[i].Passports, p)
+		}
+	}
+	return added, nil
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"github.com/palantir/stacktrace"
+)
+
+// DeletePolicy decides what happens to the passports of a deleted user
+type DeletePolicy string
+
+// Delete policies
+const (
+	// Restrict refuses to delete a user holding passports
+	Restrict DeletePolicy = "restrict"
+	// Cascade deletes the passports along with their holder
+	Cascade DeletePolicy = "cascade"
+	// Detach keeps the passports, without a holder
+	Detach DeletePolicy = "detach"
+)
+
+// NoHolder is the user id of detached passports
+const NoHolder = -1
+
+// EcodeUserHasPassports marks the errors of deletions refused by the Restrict policy
+const EcodeUserHasPassports = stacktrace.ErrorCode(1)
+
+// ParseDeletePolicy validates a policy name, the empty name standing for Restrict
+func ParseDeletePolicy(s string) (DeletePolicy, error) {
+	switch p := DeletePolicy(s); p {
+	case "":
+		return Restrict, nil
+	case Restrict, Cascade, Detach:
+		return p, nil
+	}
+	return "", stacktrace.NewError("unknown delete policy %q, use restrict, cascade or detach", s)
+}
+
+// DeleteOptions configures the deletion of a user
+type DeleteOptions struct {
+	Policy DeletePolicy
+	// DryRun reports what would happen without changing anything
+	DryRun bool
+}
+
+// Deletion reports the effects of deleting a user
+// swagger:response deletion
+type Deletion struct {
+	// UID of the deleted user
+	UserID int `json:"userId"`
+	// Policy applied to the passports of the user
+	Policy DeletePolicy `json:"policy"`
+	// Whether nothing was actually changed
+	DryRun bool `json:"dryRun"`
+	// Passports deleted along with the user
+	DeletedPassports []string `json:"deletedPassports"`
+	// Passports left without a holder
+	DetachedPassports []string `json:"detachedPassports"`
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"github.com/palantir/stacktrace"
+)
+
+// Error codes of backend failures, as opposed to failures of the request itself
+const (
+	// EcodeTransient marks failures that may go away when the call is retried, such as a dropped
+	// connection or a failed over database
+	EcodeTransient = stacktrace.ErrorCode(2)
+	// EcodeUnavailable marks calls refused without trying because the backend is unhealthy
+	EcodeUnavailable = stacktrace.ErrorCode(3)
+)
+
+// Transient reports whether err is a failure worth retrying
+func Transient(err error) bool {
+	return err != nil && stacktrace.GetCode(err) == EcodeTransient
+}
+
+// Unavailable reports whether err is due to the backend rather than the request: a transient
+// failure that persisted, or a call refused because the backend is unhealthy
+func Unavailable(err error) bool {
+	code := stacktrace.GetCode(err)
+	return err != nil && (code == EcodeTransient || code == EcodeUnavailable)
+}
@@ -1,18 +1,33 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/kostiamol/go-rest-api-template/entities"
 	"github.com/palantir/stacktrace"
 )
 
-// MockDB will hold the connection and key db info
+// Fixtures is the document format of the fixtures file, also used for data export
+type Fixtures struct {
+	Users     []entities.User     `json:"users"`
+	Passports []entities.Passport `json:"passports,omitempty"`
+}
+
+// MockDB will hold the connection and key db info. Every mutation records its event in the
+// outbox while holding the same lock as the change itself.
 type MockDB struct {
-	UserList  map[int]entities.User
-	MaxUserID int
+	UserList     map[int]entities.User
+	PassportList map[string]entities.Passport
+	MaxUserID    int
+
+	mu     sync.Mutex
+	tenant string
+	outbox *outbox
 }
 
 // NewMockDB initialises a database for test purposes
@@ -35,42 +50,77 @@ func NewMockDB() *MockDB {
 		LocationOfBirth: "Milton Keynes",
 	}
 	return &MockDB{
-		UserList:  list,
-		MaxUserID: 1,
+		UserList:     list,
+		PassportList: make(map[string]entities.Passport),
+		MaxUserID:    1,
 	}
 }
 
 // LoadFixturesIntoMockDB loads data from fixtures file into MockDB
 func LoadFixturesIntoMockDB(fixturesFile string) (*MockDB, error) {
-	var jsonObject map[string][]entities.User
+	var fixtures Fixtures
 	file, err := ioutil.ReadFile(fixturesFile)
 	if err != nil {
 		return nil, stacktrace.Propagate(err, "error reading fixtures file")
 	}
-	err = json.Unmarshal(file, &jsonObject)
+	err = json.Unmarshal(file, &fixtures)
 	if err != nil {
 		return nil, stacktrace.Propagate(err, "error parsing fixtures file")
 	}
-	list := make(map[int]entities.User)
-	list[0] = jsonObject["users"][0]
-	list[1] = jsonObject["users"][1]
-	return &MockDB{
-		UserList:  list,
-		MaxUserID: 1,
-	}, nil
+	db := &MockDB{
+		UserList:     make(map[int]entities.User),
+		PassportList: make(map[string]entities.Passport),
+		MaxUserID:    -1,
+	}
+	for _, u := range fixtures.Users {
+		db.UserList[u.ID] = u
+		if u.ID > db.MaxUserID {
+			db.MaxUserID = u.ID
+		}
+	}
+	for _, p := range fixtures.Passports {
+		if _, ok := db.UserList[p.UserID]; !ok && p.UserID != NoHolder {
+			return nil, stacktrace.NewError("passport %s in fixtures file refers to unknown user %d", p.ID, p.UserID)
+		}
+		db.PassportList[p.ID] = p
+	}
+	return db, nil
+}
+
+// lock takes the database lock unless the request has been cancelled or has run out of time,
+// checking again once the lock is held so that a request that waited too long changes nothing
+func (db *MockDB) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return stacktrace.Propagate(err, "storage call abandoned")
+	}
+	db.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		db.mu.Unlock()
+		return stacktrace.Propagate(err, "storage call abandoned")
+	}
+	return nil
 }
 
 // ListUsers returns a list of JSON documents
-func (db *MockDB) ListUsers() ([]entities.User, error) {
+func (db *MockDB) ListUsers(ctx context.Context) ([]entities.User, error) {
+	if err := db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.Unlock()
 	var list []entities.User
 	for _, v := range db.UserList {
 		list = append(list, v)
 	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
 	return list, nil
 }
 
 // GetUser returns a single JSON document
-func (db *MockDB) GetUser(i int) (entities.User, error) {
+func (db *MockDB) GetUser(ctx context.Context, i int) (entities.User, error) {
+	if err := db.lock(ctx); err != nil {
+		return entities.User{}, err
+	}
+	defer db.mu.Unlock()
 	user, ok := db.UserList[i]
 	if !ok {
 		return entities.User{}, stacktrace.NewError("Failure trying to retrieve user")
@@ -78,31 +128,236 @@ func (db *MockDB) GetUser(i int) (entities.User, error) {
 	return user, nil
 }
 
+// GetUsers returns the users with the given ids ordered by id, leaving out the ids of no user
+func (db *MockDB) GetUsers(ctx context.Context, ids []int) ([]entities.User, error) {
+	if err := db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.Unlock()
+	var list []entities.User
+	seen := make(map[int]bool)
+	for _, i := range ids {
+		if u, ok := db.UserList[i]; ok && !seen[i] {
+			seen[i] = true
+			list = append(list, u)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
 // AddUser adds a User JSON document, returns the JSON document with the generated id
-func (db *MockDB) AddUser(u entities.User) (entities.User, error) {
+func (db *MockDB) AddUser(ctx context.Context, u entities.User) (entities.User, error) {
+	if err := db.lock(ctx); err != nil {
+		return u, err
+	}
+	defer db.mu.Unlock()
 	db.MaxUserID = db.MaxUserID + 1
 	u.ID = db.MaxUserID
 	db.UserList[db.MaxUserID] = u
+	db.record(entities.UserCreated, u)
 	return u, nil
 }
 
 // UpdateUser updates an existing user
-func (db *MockDB) UpdateUser(u entities.User) (entities.User, error) {
+func (db *MockDB) UpdateUser(ctx context.Context, u entities.User) (entities.User, error) {
+	if err := db.lock(ctx); err != nil {
+		return u, err
+	}
+	defer db.mu.Unlock()
 	id := u.ID
 	_, ok := db.UserList[id]
 	if !ok {
 		return u, stacktrace.NewError("Failure trying to update user")
 	}
 	db.UserList[id] = u
+	db.record(entities.UserUpdated, u)
 	return db.UserList[id], nil
 }
 
-// DeleteUser deletes a user
-func (db *MockDB) DeleteUser(i int) error {
-	_, ok := db.UserList[i]
-	if !ok {
-		return stacktrace.NewError("Failure trying to delete user")
+// DeleteUser deletes a user, handling its passports according to the policy
+func (db *MockDB) DeleteUser(ctx context.Context, i int, opts DeleteOptions) (Deletion, error) {
+	if err := db.lock(ctx); err != nil {
+		return Deletion{}, err
+	}
+	defer db.mu.Unlock()
+	policy, err := ParseDeletePolicy(string(opts.Policy))
+	if err != nil {
+		return Deletion{}, err
+	}
+	d := Deletion{UserID: i, Policy: policy, DryRun: opts.DryRun, DeletedPassports: []string{}, DetachedPassports: []string{}}
+	if _, ok := db.UserList[i]; !ok {
+		return d, stacktrace.NewError("Failure trying to delete user")
+	}
+	var held []string
+	for id, p := range db.PassportList {
+		if p.UserID == i {
+			held = append(held, id)
+		}
+	}
+	sort.Strings(held)
+	switch {
+	case len(held) == 0:
+	case policy == Restrict:
+		return d, stacktrace.NewErrorWithCode(EcodeUserHasPassports, "user %d holds %d passports", i, len(held))
+	case policy == Cascade:
+		d.DeletedPassports = held
+	case policy == Detach:
+		d.DetachedPassports = held
+	}
+	if opts.DryRun {
+		return d, nil
+	}
+	for _, id := range d.DeletedPassports {
+		delete(db.PassportList, id)
+		db.record(entities.PassportDeleted, entities.Deleted{ID: id})
+	}
+	for _, id := range d.DetachedPassports {
+		p := db.PassportList[id]
+		p.UserID = NoHolder
+		db.PassportList[id] = p
+		db.record(entities.PassportUpdated, p)
 	}
 	delete(db.UserList, i)
+	db.record(entities.UserDeleted, entities.Deleted{ID: i})
+	return d, nil
+}
+
+// ListPassports returns all passports ordered by id
+func (db *MockDB) ListPassports(ctx context.Context) ([]entities.Passport, error) {
+	if err := db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.Unlock()
+	var list []entities.Passport
+	for _, v := range db.PassportList {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// ListUserPassports returns the passports of a single user
+func (db *MockDB) ListUserPassports(ctx context.Context, uid int) ([]entities.Passport, error) {
+	if err := db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.Unlock()
+	if _, ok := db.UserList[uid]; !ok {
+		return nil, stacktrace.NewError("Failure trying to retrieve passports of user")
+	}
+	var list []entities.Passport
+	for _, v := range db.PassportList {
+		if v.UserID == uid {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// ListPassportsOfUsers returns the passports held by any of the given users ordered by id;
+// unknown users hold none
+func (db *MockDB) ListPassportsOfUsers(ctx context.Context, uids []int) ([]entities.Passport, error) {
+	if err := db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.Unlock()
+	holders := make(map[int]bool, len(uids))
+	for _, uid := range uids {
+		holders[uid] = true
+	}
+	var list []entities.Passport
+	for _, v := range db.PassportList {
+		if holders[v.UserID] {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// ListExpiringPassports returns the passports expiring before the given time, soonest first
+func (db *MockDB) ListExpiringPassports(ctx context.Context, before time.Time) ([]entities.Passport, error) {
+	if err := db.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer db.mu.Unlock()
+	var list []entities.Passport
+	for _, v := range db.PassportList {
+		if !v.DateOfExpiry.IsZero() && v.DateOfExpiry.Before(before) {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].DateOfExpiry.Equal(list[j].DateOfExpiry) {
+			return list[i].DateOfExpiry.Before(list[j].DateOfExpiry)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// GetPassport returns a single passport
+func (db *MockDB) GetPassport(ctx context.Context, id string) (entities.Passport, error) {
+	if err := db.lock(ctx); err != nil {
+		return entities.Passport{}, err
+	}
+	defer db.mu.Unlock()
+	p, ok := db.PassportList[id]
+	if !ok {
+		return entities.Passport{}, stacktrace.NewError("Failure trying to retrieve passport")
+	}
+	return p, nil
+}
+
+// AddPassport adds a passport to an existing user. Passport ids are assigned by the issuing
+// authority, so unlike users the id is taken from the passport itself and must be unique.
+func (db *MockDB) AddPassport(ctx context.Context, p entities.Passport) (entities.Passport, error) {
+	if err := db.lock(ctx); err != nil {
+		return p, err
+	}
+	defer db.mu.Unlock()
+	if _, ok := db.UserList[p.UserID]; !ok {
+		return p, stacktrace.NewError("Failure trying to add passport to unknown user")
+	}
+	if _, ok := db.PassportList[p.ID]; ok {
+		return p, stacktrace.NewError("Failure trying to add passport with duplicate id")
+	}
+	p.Status = ""
+	db.PassportList[p.ID] = p
+	db.record(entities.PassportCreated, p)
+	return p, nil
+}
+
+// UpdatePassport updates an existing passport
+func (db *MockDB) UpdatePassport(ctx context.Context, p entities.Passport) (entities.Passport, error) {
+	if err := db.lock(ctx); err != nil {
+		return p, err
+	}
+	defer db.mu.Unlock()
+	if _, ok := db.PassportList[p.ID]; !ok {
+		return p, stacktrace.NewError("Failure trying to update passport")
+	}
+	if _, ok := db.UserList[p.UserID]; !ok {
+		return p, stacktrace.NewError("Failure trying to move passport to unknown user")
+	}
+	p.Status = ""
+	db.PassportList[p.ID] = p
+	db.record(entities.PassportUpdated, p)
+	return p, nil
+}
+
+// DeletePassport deletes a passport
+func (db *MockDB) DeletePassport(ctx context.Context, id string) error {
+	if err := db.lock(ctx); err != nil {
+		return err
+	}
+	defer db.mu.Unlock()
+	if _, ok := db.PassportList[id]; !ok {
+		return stacktrace.NewError("Failure trying to delete passport")
+	}
+	delete(db.PassportList, id)
+	db.record(entities.PassportDeleted, entities.Deleted{ID: id})
 	return nil
 }
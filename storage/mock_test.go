@@ -1,16 +1,19 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/palantir/stacktrace"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestListUsers(t *testing.T) {
 	db := NewMockDB()
-	list, _ := db.ListUsers()
+	list, _ := db.ListUsers(t.Context())
 	count := len(list)
 	assert.Equal(t, 2, count, "There should be 2 items in the list.")
 }
@@ -18,7 +21,7 @@ func TestListUsers(t *testing.T) {
 func TestGetUserSuccess(t *testing.T) {
 	db := NewMockDB()
 	dt, _ := time.Parse(time.RFC3339, "1985-12-31T00:00:00Z")
-	u, err := db.GetUser(0)
+	u, err := db.GetUser(t.Context(), 0)
 	if assert.Nil(t, err) {
 		assert.Equal(t, 0, u.ID, "they should be equal")
 		assert.Equal(t, "John", u.FirstName, "they should be equal")
@@ -30,7 +33,7 @@ func TestGetUserSuccess(t *testing.T) {
 
 func TestGetUserFail(t *testing.T) {
 	db := NewMockDB()
-	_, err := db.GetUser(10)
+	_, err := db.GetUser(t.Context(), 10)
 	assert.NotNil(t, err)
 }
 
@@ -43,11 +46,11 @@ func TestAddUser(t *testing.T) {
 		DateOfBirth:     dt,
 		LocationOfBirth: "Cambridge",
 	}
-	u, _ = db.AddUser(u)
+	u, _ = db.AddUser(t.Context(), u)
 	// we should now have a user object with a database Id
 	assert.Equal(t, 2, u.ID, "Expected database Id should be 2.")
 	// we should now have 3 items in the list
-	list, _ := db.ListUsers()
+	list, _ := db.ListUsers(t.Context())
 	count := len(list)
 	assert.Equal(t, 3, count, "There should be 3 items in the list.")
 }
@@ -63,7 +66,7 @@ func TestUpdateUserSuccess(t *testing.T) {
 		LocationOfBirth: "Southend",
 	}
 	// check if there are no errors
-	u2, err := db.UpdateUser(u)
+	u2, err := db.UpdateUser(t.Context(), u)
 	assert.Nil(t, err)
 	// check returned user
 	assert.Equal(t, 0, u2.ID, "they should be equal")
@@ -83,18 +86,218 @@ func TestUpdateUserFail(t *testing.T) {
 		DateOfBirth:     dt,
 		LocationOfBirth: "Southend",
 	}
-	_, err := db.UpdateUser(u)
+	_, err := db.UpdateUser(t.Context(), u)
 	assert.NotNil(t, err)
 }
 
 func TestDeleteUserSuccess(t *testing.T) {
 	db := NewMockDB()
-	err := db.DeleteUser(1)
+	_, err := db.DeleteUser(t.Context(), 1, DeleteOptions{})
 	assert.Nil(t, err)
 }
 
 func TestDeleteUserFail(t *testing.T) {
 	db := NewMockDB()
-	err := db.DeleteUser(10)
+	_, err := db.DeleteUser(t.Context(), 10, DeleteOptions{})
 	assert.NotNil(t, err)
 }
+
+func TestLoadFixturesIntoMockDB(t *testing.T) {
+	db, err := LoadFixturesIntoMockDB("../fixtures.json")
+	if assert.Nil(t, err) {
+		list, _ := db.ListUsers(t.Context())
+		assert.Equal(t, 2, len(list), "There should be 2 items in the list.")
+		assert.Equal(t, 1, db.MaxUserID, "they should be equal")
+	}
+}
+
+func TestAddPassportSuccess(t *testing.T) {
+	db := NewMockDB()
+	p, err := db.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789", p.ID, "they should be equal")
+	list, _ := db.ListUserPassports(t.Context(), 1)
+	assert.Equal(t, 1, len(list), "There should be 1 item in the list.")
+	list, _ = db.ListUserPassports(t.Context(), 0)
+	assert.Equal(t, 0, len(list), "There should be no items in the list.")
+}
+
+func TestLookupsByIDs(t *testing.T) {
+	db := NewMockDB()
+	users, err := db.GetUsers(t.Context(), []int{1, 10, 0, 1})
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(users), "There should be 2 items in the list.") {
+		assert.Equal(t, 0, users[0].ID, "they should be equal")
+		assert.Equal(t, 1, users[1].ID, "they should be equal")
+	}
+
+	db.AddPassport(t.Context(), entities.Passport{ID: "222222222", Authority: "HMPO", UserID: 1})
+	db.AddPassport(t.Context(), entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 0})
+	list, err := db.ListPassportsOfUsers(t.Context(), []int{1, 10})
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(list), "There should be 1 item in the list.") {
+		assert.Equal(t, "222222222", list[0].ID, "they should be equal")
+	}
+	list, _ = db.ListPassportsOfUsers(t.Context(), []int{0, 1})
+	assert.Equal(t, 2, len(list), "There should be 2 items in the list.")
+}
+
+func TestAddPassportFail(t *testing.T) {
+	db := NewMockDB()
+	_, err := db.AddPassport(t.Context(), entities.Passport{ID: "123456789", UserID: 10})
+	assert.NotNil(t, err, "unknown user")
+	db.AddPassport(t.Context(), entities.Passport{ID: "123456789", UserID: 1})
+	_, err = db.AddPassport(t.Context(), entities.Passport{ID: "123456789", UserID: 0})
+	assert.NotNil(t, err, "duplicate id")
+}
+
+func TestUpdatePassport(t *testing.T) {
+	db := NewMockDB()
+	db.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	p, err := db.UpdatePassport(t.Context(), entities.Passport{ID: "123456789", Authority: "IPS", UserID: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "IPS", p.Authority, "they should be equal")
+	_, err = db.UpdatePassport(t.Context(), entities.Passport{ID: "987654321", UserID: 1})
+	assert.NotNil(t, err)
+}
+
+func TestDeletePassport(t *testing.T) {
+	db := NewMockDB()
+	db.AddPassport(t.Context(), entities.Passport{ID: "123456789", UserID: 1})
+	assert.Nil(t, db.DeletePassport(t.Context(), "123456789"))
+	_, err := db.GetPassport(t.Context(), "123456789")
+	assert.NotNil(t, err)
+	assert.NotNil(t, db.DeletePassport(t.Context(), "123456789"))
+}
+
+func TestMutationsRecordEvents(t *testing.T) {
+	db := NewMockDB()
+	db.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	db.AddPassport(t.Context(), entities.Passport{ID: "123", UserID: 2})
+	db.DeleteUser(t.Context(), 10, DeleteOptions{})
+	db.DeletePassport(t.Context(), "123")
+	events, _ := db.PendingEvents(0)
+	if assert.Equal(t, 3, len(events), "failed mutations record nothing") {
+		assert.Equal(t, entities.UserCreated, events[0].Type, "they should be equal")
+		assert.Equal(t, entities.PassportCreated, events[1].Type, "they should be equal")
+		assert.Equal(t, entities.Deleted{ID: "123"}, events[2].Data, "they should be equal")
+	}
+	db.MarkPublished([]string{events[0].ID, events[2].ID})
+	events, _ = db.PendingEvents(10)
+	if assert.Equal(t, 1, len(events), "they should be equal") {
+		assert.Equal(t, "2", events[0].ID, "they should be equal")
+	}
+}
+
+func TestAddRecords(t *testing.T) {
+	db := NewMockDB()
+	db.AddPassport(t.Context(), entities.Passport{ID: "123", UserID: 1})
+	_, err := db.AddRecords(t.Context(), []Record{
+		{User: entities.User{FirstName: "Apple"}, Passports: []entities.Passport{{ID: "456"}}},
+		{User: entities.User{FirstName: "Big"}, Passports: []entities.Passport{{ID: "123"}}},
+	})
+	var recordErr *RecordError
+	if assert.True(t, errors.As(err, &recordErr)) {
+		assert.Equal(t, 1, recordErr.Index, "they should be equal")
+	}
+	users, _ := db.ListUsers(t.Context())
+	assert.Equal(t, 2, len(users), "a failed batch adds nothing")
+	events, _ := db.PendingEvents(0)
+	assert.Equal(t, 1, len(events), "a failed batch records nothing")
+
+	added, err := db.AddRecords(t.Context(), []Record{
+		{User: entities.User{FirstName: "Apple"}, Passports: []entities.Passport{{ID: "456", UserID: 7}}},
+		{User: entities.User{ID: NoHolder}, Passports: []entities.Passport{{ID: "789"}}},
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, added[0].User.ID, "they should be equal")
+		assert.Equal(t, 2, db.PassportList["456"].UserID, "they should be equal")
+		assert.Equal(t, NoHolder, db.PassportList["789"].UserID, "they should be equal")
+	}
+	events, _ = db.PendingEvents(0)
+	assert.Equal(t, 4, len(events), "they should be equal")
+}
+
+func TestListExpiringPassports(t *testing.T) {
+	db := NewMockDB()
+	dt, _ := time.Parse(time.RFC3339, "2024-01-01T00:00:00Z")
+	db.AddPassport(t.Context(), entities.Passport{ID: "111", UserID: 0, DateOfExpiry: dt.AddDate(1, 0, 0)})
+	db.AddPassport(t.Context(), entities.Passport{ID: "222", UserID: 0, DateOfExpiry: dt})
+	db.AddPassport(t.Context(), entities.Passport{ID: "333", UserID: 1})
+	db.AddPassport(t.Context(), entities.Passport{ID: "444", UserID: 1, DateOfExpiry: dt.AddDate(3, 0, 0)})
+	list, err := db.ListExpiringPassports(t.Context(), dt.AddDate(2, 0, 0))
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(list), "they should be equal") {
+		assert.Equal(t, "222", list[0].ID, "soonest first")
+		assert.Equal(t, "111", list[1].ID, "they should be equal")
+	}
+}
+
+func TestDeleteUserPolicies(t *testing.T) {
+	db := NewMockDB()
+	db.AddPassport(t.Context(), entities.Passport{ID: "222222222", UserID: 1})
+	db.AddPassport(t.Context(), entities.Passport{ID: "111111111", UserID: 1})
+
+	_, err := db.DeleteUser(t.Context(), 1, DeleteOptions{Policy: Restrict})
+	assert.Equal(t, EcodeUserHasPassports, stacktrace.GetCode(err), "they should be equal")
+
+	d, err := db.DeleteUser(t.Context(), 1, DeleteOptions{Policy: Cascade, DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"111111111", "222222222"}, d.DeletedPassports, "they should be equal")
+	_, err = db.GetUser(t.Context(), 1)
+	assert.Nil(t, err, "a dry run changes nothing")
+
+	d, err = db.DeleteUser(t.Context(), 1, DeleteOptions{Policy: Detach})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"111111111", "222222222"}, d.DetachedPassports, "they should be equal")
+	p, _ := db.GetPassport(t.Context(), "111111111")
+	assert.Equal(t, NoHolder, p.UserID, "they should be equal")
+
+	db.AddPassport(t.Context(), entities.Passport{ID: "333333333", UserID: 0})
+	d, err = db.DeleteUser(t.Context(), 0, DeleteOptions{Policy: Cascade})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"333333333"}, d.DeletedPassports, "they should be equal")
+	_, err = db.GetPassport(t.Context(), "333333333")
+	assert.NotNil(t, err)
+
+	_, err = db.DeleteUser(t.Context(), 0, DeleteOptions{Policy: "orphan"})
+	assert.NotNil(t, err)
+}
+
+func TestTenants(t *testing.T) {
+	db := NewMockDB()
+	tenants := NewTenants(db, "acme")
+	acme, ok := tenants.For("acme")
+	assert.True(t, ok, "the tenant is known")
+	_, ok = tenants.For("globex")
+	assert.False(t, ok, "the tenant is unknown")
+	u, _ := acme.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	assert.Equal(t, 0, u.ID, "every tenant has its own id sequence")
+	u, _ = db.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	assert.Equal(t, 2, u.ID, "they should be equal")
+	list, _ := acme.ListUsers(t.Context())
+	assert.Equal(t, 1, len(list), "tenants don't see each other's users")
+	assert.Equal(t, []*MockDB{acme, db}, tenants.All(), "they should be equal")
+
+	events, _ := db.PendingEvents(0)
+	if assert.Equal(t, 2, len(events), "the outbox is shared") {
+		assert.Equal(t, "acme", events[0].Tenant, "they should be equal")
+		assert.Equal(t, "default", events[1].Tenant, "they should be equal")
+		assert.Equal(t, "2", events[1].ID, "they should be equal")
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	db := NewMockDB()
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := db.AddUser(ctx, entities.User{FirstName: "Apple", LastName: "Jack"})
+	assert.Equal(t, context.Canceled, stacktrace.RootCause(err), "they should be equal")
+	_, err = db.ListUsers(ctx)
+	assert.NotNil(t, err)
+
+	list, _ := db.ListUsers(t.Context())
+	assert.Equal(t, 2, len(list), "nothing is stored once the request is cancelled")
+	events, _ := db.PendingEvents(0)
+	assert.Equal(t, 0, len(events), "they should be equal")
+}
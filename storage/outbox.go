@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+)
+
+// outbox holds the events of committed changes until they are published. Event ids are
+// increasing sequence numbers, so consumers can order and deduplicate them. The databases of
+// all tenants share one outbox.
+type outbox struct {
+	mu      sync.Mutex
+	pending []entities.Event
+	seq     int64
+	notify  chan struct{}
+}
+
+func newOutbox() *outbox {
+	return &outbox{notify: make(chan struct{}, 1)}
+}
+
+func (o *outbox) record(tenant, typ string, data interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.seq++
+	o.pending = append(o.pending, entities.Event{
+		ID:     strconv.FormatInt(o.seq, 10),
+		Type:   typ,
+		Tenant: tenant,
+		Time:   time.Now().UTC(),
+		Data:   data,
+	})
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// record appends the event of a change; the caller holds the lock of the change
+func (db *MockDB) record(typ string, data interface{}) {
+	db.events().record(db.tenant, typ, data)
+}
+
+// events returns the outbox of the database, creating it for databases built as literals
+func (db *MockDB) events() *outbox {
+	if db.outbox == nil {
+		db.outbox = newOutbox()
+	}
+	return db.outbox
+}
+
+// RecordEvent records an event that isn't the result of a change, such as a reminder
+func (db *MockDB) RecordEvent(ctx context.Context, typ string, data interface{}) error {
+	if err := db.lock(ctx); err != nil {
+		return err
+	}
+	defer db.mu.Unlock()
+	db.record(typ, data)
+	return nil
+}
+
+// PendingEvents returns up to limit unpublished events, oldest first
+func (db *MockDB) PendingEvents(limit int) ([]entities.Event, error) {
+	db.mu.Lock()
+	o := db.events()
+	db.mu.Unlock()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if limit <= 0 || limit > len(o.pending) {
+		limit = len(o.pending)
+	}
+	return append([]entities.Event{}, o.pending[:limit]...), nil
+}
+
+// MarkPublished removes published events from the outbox
+func (db *MockDB) MarkPublished(ids []string) error {
+	db.mu.Lock()
+	o := db.events()
+	db.mu.Unlock()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	published := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		published[id] = true
+	}
+	kept := o.pending[:0]
+	for _, e := range o.pending {
+		if !published[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	o.pending = kept
+	return nil
+}
+
+// OutboxNotify signals that events were recorded since the last receive
+func (db *MockDB) OutboxNotify() <-chan struct{} {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.events().notify
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+)
+
+// Tenants keeps a separate MockDB per known tenant, so that tenants can neither see nor change
+// each other's users and passports and each has its own user id sequence. The databases share the
+// outbox of the default tenant's database, whose events carry the tenant they belong to.
+type Tenants struct {
+	mu  sync.Mutex
+	dbs map[string]*MockDB
+}
+
+// NewTenants serves the default tenant from db and the tenants of ids from empty databases. Other
+// tenants are unknown.
+func NewTenants(db *MockDB, ids ...string) *Tenants {
+	db.mu.Lock()
+	db.tenant = tenant.Default
+	events := db.events()
+	db.mu.Unlock()
+	t := &Tenants{dbs: map[string]*MockDB{tenant.Default: db}}
+	for _, id := range ids {
+		if _, ok := t.dbs[id]; ok {
+			continue
+		}
+		t.dbs[id] = &MockDB{
+			UserList:     make(map[int]entities.User),
+			PassportList: make(map[string]entities.Passport),
+			MaxUserID:    -1,
+			tenant:       id,
+			outbox:       events,
+		}
+	}
+	return t
+}
+
+// For returns the database of a tenant, false if the tenant is unknown
+func (t *Tenants) For(id string) (*MockDB, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	db, ok := t.dbs[id]
+	return db, ok
+}
+
+// All returns the databases of all tenants, ordered by tenant id
+func (t *Tenants) All() []*MockDB {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.dbs))
+	for id := range t.dbs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	list := make([]*MockDB, len(ids))
+	for i, id := range ids {
+		list[i] = t.dbs[id]
+	}
+	return list
+}
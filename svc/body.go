@@ -0,0 +1,136 @@
+package svc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxBodySize bounds the body of a request, in bytes, when the context doesn't set a limit
+const DefaultMaxBodySize = 1 << 20
+
+// routeBodySizes overrides the body size limit of the routes that take larger bodies by design
+var routeBodySizes = map[string]int64{
+	"BatchUsers": 64 << 20,
+}
+
+// maxBodySizeFor returns how large the body of a route's requests may be
+func maxBodySizeFor(ctx Context, name string) int64 {
+	if size, ok := routeBodySizes[name]; ok {
+		return size
+	}
+	if ctx.MaxBodySize > 0 {
+		return ctx.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+// limitBody caps the body of the requests served by h. Bodies announced as too large are refused
+// at once; others fail once they are read past the limit, see readFailed.
+func limitBody(ctx Context, size int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > size {
+			tooLarge(w, req, ctx, size)
+			return
+		}
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(w, req.Body, size)
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// tooLarge answers a request whose body exceeds size bytes
+func tooLarge(w http.ResponseWriter, req *http.Request, ctx Context, size int64) {
+	response := status{
+		Status:  "413",
+		Message: "the body exceeds " + strconv.FormatInt(size, 10) + " bytes",
+	}
+	respond(w, req, ctx, http.StatusRequestEntityTooLarge, response)
+}
+
+// bodyError is a body the handlers refuse before looking at its content, answered with code
+type bodyError struct {
+	code    int
+	message string
+}
+
+func (e *bodyError) Error() string {
+	return e.message
+}
+
+// isJSONContent reports whether a Content-Type is JSON. Requests without one are taken as JSON,
+// so that clients predating the check keep working.
+func isJSONContent(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// decodeJSON reads the body of a request into v, see decodeBody
+func decodeJSON(req *http.Request, v interface{}) error {
+	return decodeBody(req, func(d *json.Decoder) error {
+		return d.Decode(v)
+	})
+}
+
+// decodeBody reads the body of a request with decode, see decodeStrict. The body must be JSON.
+func decodeBody(req *http.Request, decode func(*json.Decoder) error) error {
+	if !isJSONContent(req.Header.Get("Content-Type")) {
+		return &bodyError{http.StatusUnsupportedMediaType, "the body must be application/json"}
+	}
+	return decodeStrict(req.Body, decode)
+}
+
+// decodeStrict reads r with decode, given a decoder refusing the fields its target doesn't know
+// of. r must hold a single JSON document.
+func decodeStrict(r io.Reader, decode func(*json.Decoder) error) error {
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := decode(d); err != nil {
+		return strictError(err)
+	}
+	var extra json.RawMessage
+	if err := d.Decode(&extra); err != io.EOF {
+		if e, ok := strictError(err).(*bodyError); ok {
+			return e
+		}
+		return &bodyError{http.StatusBadRequest, "the body must hold a single JSON document"}
+	}
+	return nil
+}
+
+// strictError turns the errors of reading a body past its limit, and of unknown fields, into
+// body errors; others are returned as they are
+func strictError(err error) error {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return &bodyError{http.StatusRequestEntityTooLarge, "the body exceeds " + strconv.FormatInt(maxBytes.Limit, 10) + " bytes"}
+	}
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return &bodyError{http.StatusBadRequest, strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	return err
+}
+
+// readFailed answers a request whose body couldn't be decoded: body errors with their own status,
+// others with 400 and message
+func readFailed(w http.ResponseWriter, req *http.Request, ctx Context, err error, message string) {
+	log.Println(err)
+	code := http.StatusBadRequest
+	if e, ok := err.(*bodyError); ok {
+		code, message = e.code, e.message
+	}
+	response := status{
+		Status:  strconv.Itoa(code),
+		Message: message,
+	}
+	respond(w, req, ctx, code, response)
+}
@@ -0,0 +1,92 @@
+package svc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveBody sends a request with a body of contentType to the service
+func serveBody(ctx Context, method, target, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	NewHandler(ctx).ServeHTTP(w, req)
+	return w
+}
+
+func TestStrictDecoding(t *testing.T) {
+	for _, c := range []struct {
+		method, target, contentType, body string
+		code                              int
+		message                           string
+	}{
+		{"POST", "/users", "application/json", `{"firstName": "Apple", "lastName": "Jack"}`, http.StatusCreated, ""},
+		{"POST", "/users", "", `{"firstName": "Apple", "lastName": "Jack"}`, http.StatusCreated, ""},
+		{"POST", "/users", "application/json", `{"firstName": "Apple", "lastName": "Jack", "nickname": "AJ"}`, http.StatusBadRequest, `unknown field \"nickname\"`},
+		{"POST", "/v2/users", "application/json", `{"name": {"first": "Apple", "last": "Jack", "middle": "B"}}`, http.StatusBadRequest, `unknown field \"middle\"`},
+		{"POST", "/users", "application/json", `{"firstName": "Apple", "lastName": "Jack"} {"firstName": "Big"}`, http.StatusBadRequest, "the body must hold a single JSON document"},
+		{"POST", "/users", "application/json", `{"firstName": "Apple", "lastName": "Jack"} garbage`, http.StatusBadRequest, "the body must hold a single JSON document"},
+		{"POST", "/users", "text/plain", `{"firstName": "Apple", "lastName": "Jack"}`, http.StatusUnsupportedMediaType, "the body must be application/json"},
+		{"PUT", "/users/1", "application/x-www-form-urlencoded", `firstName=Apple`, http.StatusUnsupportedMediaType, "the body must be application/json"},
+		{"POST", "/users/1/passports", "application/json", `{"id": "987654321", "authority": "HMPO", "colour": "red"}`, http.StatusBadRequest, `unknown field \"colour\"`},
+		{"PUT", "/passports/123456789", "text/xml", `<passport/>`, http.StatusUnsupportedMediaType, "the body must be application/json"},
+		{"POST", "/webhooks", "application/json", `{"url": "http://127.0.0.1:1/hook", "retries": 3}`, http.StatusBadRequest, `unknown field \"retries\"`},
+		{"POST", "/graphql", "text/plain", `{"query": "{ users { id } }"}`, http.StatusUnsupportedMediaType, "the body must be application/json"},
+		{"POST", "/graphql", "application/json", `{"query": "{ users { id } }"}{}`, http.StatusBadRequest, "the body must hold a single JSON document"},
+		{"POST", "/graphql", "application/json", `{"query": "{ users { id } }", "extensions": {"persistedQuery": {}}}`, http.StatusOK, ""},
+		{"POST", "/users:batch", "text/xml", "<users/>", http.StatusUnsupportedMediaType, "application/x-ndjson or text/csv"},
+		{"POST", "/users:batch", "text/csv", "firstName,lastName\nApple,Jack\n", http.StatusBadRequest, "malformed CSV: column type is missing"},
+		{"POST", "/users:batch", "application/json", `[{"firstName": "Apple", "lastName": "Jack", "age": 3}]`, http.StatusBadRequest, `unknown field \"age\"`},
+	} {
+		w := serveBody(newE2EContext(t), c.method, c.target, c.contentType, strings.NewReader(c.body))
+		assert.Equal(t, c.code, w.Code, "%s %s %s: %s", c.method, c.target, c.body, w.Body.String())
+		assert.Contains(t, w.Body.String(), c.message)
+	}
+
+	// a malformed NDJSON line only fails its own item
+	ctx := NewContext()
+	defer ctx.Close()
+	w := serveBody(ctx, "POST", "/users:batch", "application/x-ndjson",
+		strings.NewReader("{\"firstName\": \"Apple\", \"lastName\": \"Jack\"}\n{\"firstName\": \"Big\", \"lastName\": \"Mac\", \"age\": 3}\n"))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `"created":1`)
+	assert.Contains(t, w.Body.String(), `unknown field \"age\"`)
+}
+
+// unsized hides the length of a body, as chunked requests do
+type unsized struct {
+	io.Reader
+}
+
+func TestBodySizeLimit(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.MaxBodySize = 64
+	user := `{"firstName": "Apple", "lastName": "` + strings.Repeat("J", 64) + `"}`
+
+	// bodies announced as too large are refused before they are read
+	w := serveBody(ctx, "POST", "/users", "application/json", strings.NewReader(user))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "they should be equal")
+	assert.Equal(t, `{"status":"413","message":"the body exceeds 64 bytes"}`, w.Body.String(), "they should be equal")
+
+	// and others once they are read past the limit
+	for _, target := range []string{"/users", "/v2/users", "/users/1/passports", "/webhooks", "/graphql"} {
+		w = serveBody(ctx, "POST", target, "application/json", unsized{strings.NewReader(user)})
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "%s: %s", target, w.Body.String())
+	}
+
+	// batch imports take larger bodies
+	w = serveBody(ctx, "POST", "/users:batch", "application/json", strings.NewReader("["+user+"]"))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+
+	assert.Equal(t, int64(DefaultMaxBodySize), maxBodySizeFor(Context{}, "CreateUser"), "they should be equal")
+	assert.Equal(t, int64(64), maxBodySizeFor(ctx, "UpdatePassport"), "they should be equal")
+	assert.Equal(t, routeBodySizes["BatchUsers"], maxBodySizeFor(ctx, "BatchUsers"), "they should be equal")
+}
@@ -0,0 +1,603 @@
+package svc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+)
+
+// maxBatchLineSize limits the length of a single NDJSON line in a batch import
+const maxBatchLineSize = 1 << 20
+
+// userRecord is a user together with its passports, used as the item of bulk imports and
+// NDJSON exports so that an export can be fed straight back into POST /users:batch. A record
+// whose id is storage.NoHolder carries the detached passports, which no user holds.
+type userRecord struct {
+	entities.User
+	Passports []entities.Passport `json:"passports,omitempty"`
+}
+
+// batchItem is a single decoded item of a batch import, or the reason it couldn't be decoded
+type batchItem struct {
+	record userRecord
+	err    error
+}
+
+// batchResult reports the outcome of a single item of a batch import
+// swagger:response batchResult
+type batchResult struct {
+	// Position of the item in the request
+	Index int `json:"index"`
+	// HTTP status code of the item
+	Status string `json:"status"`
+	// The error message
+	Message string `json:"message,omitempty"`
+	// The created user
+	User *userRecord `json:"user,omitempty"`
+}
+
+// batchResults holds the per-item results of a batch import
+// swagger:response batchResults
+type batchResults struct {
+	// Number of created items
+	Created int `json:"created"`
+	// Number of rejected items
+	Failed int `json:"failed"`
+	// Per-item results, in request order
+	Results []batchResult `json:"results"`
+}
+
+// BatchUsersHandler creates many users, with their passports, in a single request
+func BatchUsersHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route POST /users:batch users batchUsers
+	//
+	// Creates users in bulk.
+	//
+	// Accepts a JSON array or NDJSON stream of users, each optionally carrying its passports, or
+	// a document in the fixtures format as exported, whose passports go to the users with their
+	// userId. Users are created under new ids. With atomic=true either every item is created or
+	// none is.
+	//
+	//     Responses:
+	//       200: batchResults
+	//       400: status
+	//       422: batchResults
+
+	atomic, _ := strconv.ParseBool(req.URL.Query().Get("atomic"))
+	items, err := decodeBatch(req)
+	if err != nil {
+		readFailed(w, req, ctx, err, "malformed batch: "+errorMessage(err))
+		return
+	}
+	res, ok := importUserRecords(req.Context(), ctx.DB, items, atomic)
+	if !ok {
+		respond(w, req, ctx, http.StatusUnprocessableEntity, res)
+		return
+	}
+	respond(w, req, ctx, http.StatusOK, res)
+}
+
+// importUserRecords validates and stores the items of a batch. It reports false when an atomic
+// batch has been rejected as a whole, in which case nothing has been stored.
+func importUserRecords(reqCtx context.Context, db Storager, items []batchItem, atomic bool) (batchResults, bool) {
+	results := make([]batchResult, len(items))
+	failed := 0
+	for i, item := range items {
+		results[i] = batchResult{Index: i}
+		if err := validateBatchItem(item); err != nil {
+			results[i].Status = "400"
+			results[i].Message = errorMessage(err)
+			failed++
+		}
+	}
+	if atomic && failed > 0 {
+		markSkipped(results, "not created, batch is atomic")
+		return batchResults{Failed: failed, Results: results}, false
+	}
+	if atomic {
+		records := make([]storage.Record, len(items))
+		for i, item := range items {
+			records[i] = item.record.storageRecord()
+		}
+		added, err := db.AddRecords(reqCtx, records)
+		if err != nil {
+			log.Println(err)
+			var recordErr *storage.RecordError
+			if errors.As(err, &recordErr) {
+				failRecord(&results[recordErr.Index], recordErr.Err)
+				failed = 1
+			} else {
+				for i := range results {
+					failRecord(&results[i], err)
+				}
+				failed = len(results)
+			}
+			markSkipped(results, "not created, batch is atomic")
+			return batchResults{Failed: failed, Results: results}, false
+		}
+		for i, r := range added {
+			record := userRecordOf(r)
+			results[i].Status = "201"
+			results[i].User = &record
+		}
+		return batchResults{Created: len(added), Results: results}, true
+	}
+	created := 0
+	for i, item := range items {
+		if results[i].Status != "" {
+			continue
+		}
+		added, err := db.AddRecords(reqCtx, []storage.Record{item.record.storageRecord()})
+		if err != nil {
+			log.Println(err)
+			var recordErr *storage.RecordError
+			if errors.As(err, &recordErr) {
+				err = recordErr.Err
+			}
+			failRecord(&results[i], err)
+			failed++
+			continue
+		}
+		record := userRecordOf(added[0])
+		created++
+		results[i].Status = "201"
+		results[i].User = &record
+	}
+	return batchResults{Created: created, Failed: failed, Results: results}, true
+}
+
+// failRecord reports the error of an item that couldn't be stored
+func failRecord(result *batchResult, err error) {
+	result.Status = strconv.Itoa(ruleStatus(err))
+	result.Message = errorMessage(err)
+}
+
+// storageRecord returns the record as the storage takes it
+func (r userRecord) storageRecord() storage.Record {
+	return storage.Record{User: r.User, Passports: r.Passports}
+}
+
+// userRecordOf returns a record of the storage as the API shows it
+func userRecordOf(r storage.Record) userRecord {
+	return userRecord{User: r.User, Passports: r.Passports}
+}
+
+// decodeBatch reads the items of a batch import. NDJSON is decoded line by line so that a
+// malformed line only fails its own item, while a JSON array must be valid as a whole. Both are
+// decoded as strictly as single bodies, see decodeBody.
+func decodeBatch(req *http.Request) ([]batchItem, error) {
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "text/csv") {
+		fixtures, err := decodeCSV(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		return fixtureItems(fixtures)
+	}
+	ndjson := strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-ndjson")
+	if !ndjson && !isJSONContent(req.Header.Get("Content-Type")) {
+		return nil, &bodyError{http.StatusUnsupportedMediaType, "the body must be application/json, application/x-ndjson or text/csv"}
+	}
+	body := bufio.NewReader(req.Body)
+	first, err := peekNonSpace(body)
+	if err != nil {
+		return nil, strictError(err)
+	}
+	if first == '{' && !ndjson {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, strictError(err)
+		}
+		if isFixtures(data) {
+			var fixtures storage.Fixtures
+			err := decodeStrict(bytes.NewReader(data), func(d *json.Decoder) error {
+				return d.Decode(&fixtures)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return fixtureItems(fixtures)
+		}
+		body = bufio.NewReader(bytes.NewReader(data))
+	}
+	var items []batchItem
+	if first == '[' && !ndjson {
+		var records []userRecord
+		err := decodeStrict(body, func(d *json.Decoder) error {
+			return d.Decode(&records)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			items = append(items, batchItem{record: r})
+		}
+		return items, nil
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxBatchLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var item batchItem
+		item.err = decodeStrict(bytes.NewReader(line), func(d *json.Decoder) error {
+			return d.Decode(&item.record)
+		})
+		items = append(items, item)
+	}
+	return items, strictError(scanner.Err())
+}
+
+// isFixtures reports whether a body starts with a document in the fixtures format rather than with
+// the first line of NDJSON sent without its content type
+func isFixtures(data []byte) bool {
+	var document map[string]json.RawMessage
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&document); err != nil {
+		return false
+	}
+	_, ok := document["users"]
+	return ok
+}
+
+// fixtureItems turns a document in the fixtures format, as exported, into batch items: every user
+// along with the passports whose userId is its id in the document, followed by the detached
+// passports if there are any
+func fixtureItems(fixtures storage.Fixtures) ([]batchItem, error) {
+	items := make([]batchItem, len(fixtures.Users))
+	index := make(map[int]int)
+	for i, u := range fixtures.Users {
+		if _, ok := index[u.ID]; ok {
+			return nil, &bodyError{http.StatusBadRequest, fmt.Sprintf("user %d appears twice", u.ID)}
+		}
+		index[u.ID] = i
+		items[i].record.User = u
+	}
+	var detached []entities.Passport
+	for _, p := range fixtures.Passports {
+		if p.UserID == storage.NoHolder {
+			detached = append(detached, p)
+			continue
+		}
+		i, ok := index[p.UserID]
+		if !ok {
+			return nil, &bodyError{http.StatusBadRequest, fmt.Sprintf("passport %s refers to unknown user %d", p.ID, p.UserID)}
+		}
+		items[i].record.Passports = append(items[i].record.Passports, p)
+	}
+	if len(detached) > 0 {
+		items = append(items, batchItem{record: detachedRecord(detached)})
+	}
+	return items, nil
+}
+
+// detachedRecord returns the record carrying detached passports
+func detachedRecord(passports []entities.Passport) userRecord {
+	return userRecord{User: entities.User{ID: storage.NoHolder}, Passports: passports}
+}
+
+// peekNonSpace returns the first non-whitespace byte of r without consuming it
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return 0, fmt.Errorf("empty batch")
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			return b[0], nil
+		}
+		r.ReadByte()
+	}
+}
+
+// validateBatchItem checks a decoded item before anything gets stored
+func validateBatchItem(item batchItem) error {
+	if item.err != nil {
+		return item.err
+	}
+	if item.record.ID != storage.NoHolder {
+		if err := validateUser(item.record.User); err != nil {
+			return err
+		}
+	} else if len(item.record.Passports) == 0 {
+		return fmt.Errorf("a record of detached passports must have passports")
+	}
+	for _, p := range item.record.Passports {
+		if err := validatePassport(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markSkipped fills in the results of items that were never attempted
+func markSkipped(results []batchResult, message string) {
+	for i := range results {
+		if results[i].Status == "" {
+			results[i].Status = "424"
+			results[i].Message = message
+		}
+	}
+}
+
+// ExportHandler streams every user and passport as JSON, NDJSON or CSV
+func ExportHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /export export exportData
+	//
+	// Exports all data.
+	//
+	// Streams all users and passports. format=json (default) produces a fixtures file,
+	// format=ndjson one user with its passports per line and format=csv one row per entity.
+	// Without a format parameter the format is taken from the Accept header.
+	//
+	//     Responses:
+	//       200: description: the exported data
+	//       400: status
+	//       500: status
+
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormat(req.Header.Get("Accept"))
+	}
+	if format != "json" && format != "ndjson" && format != "csv" {
+		response := status{
+			Status:  "400",
+			Message: "unsupported export format " + format,
+		}
+		respond(w, req, ctx, http.StatusBadRequest, response)
+		return
+	}
+	list, err := ctx.DB.ListUsers(req.Context())
+	if err != nil {
+		exportFailed(w, req, ctx, err)
+		return
+	}
+	passports, err := ctx.DB.ListPassports(req.Context())
+	if err != nil {
+		exportFailed(w, req, ctx, err)
+		return
+	}
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		err = json.NewEncoder(w).Encode(storage.Fixtures{Users: list, Passports: passports})
+	case "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		err = writeNDJSON(w, list, passports)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="export.csv"`)
+		w.WriteHeader(http.StatusOK)
+		err = writeCSV(w, list, passports)
+	}
+	if err != nil {
+		// the status line is already sent, all we can do is stop streaming
+		log.Println(err)
+	}
+}
+
+// exportFormats are the media types of the export formats, in order of preference
+var exportFormats = []struct {
+	mediaType string
+	format    string
+}{
+	{"application/json", "json"},
+	{"application/x-ndjson", "ndjson"},
+	{"text/csv", "csv"},
+}
+
+// exportFormat picks the export format from an Accept header when no format parameter is given
+func exportFormat(accept string) string {
+	for _, r := range acceptRanges(accept) {
+		for _, f := range exportFormats {
+			if r.matches(f.mediaType) {
+				return f.format
+			}
+		}
+	}
+	return "json"
+}
+
+// exportFailed reports an error that happened before anything was streamed
+func exportFailed(w http.ResponseWriter, req *http.Request, ctx Context, err error) {
+	if unavailable(w, req, ctx, err) {
+		return
+	}
+	response := status{
+		Status:  "500",
+		Message: "something went wrong",
+	}
+	log.Println(err)
+	respond(w, req, ctx, http.StatusInternalServerError, response)
+}
+
+// writeNDJSON writes one userRecord per line, flushing as it goes
+func writeNDJSON(w http.ResponseWriter, list []entities.User, passports []entities.Passport) error {
+	enc := json.NewEncoder(w)
+	for _, r := range exportRecords(list, passports) {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+		flush(w)
+	}
+	return nil
+}
+
+// exportRecords groups passports with their holders, followed by the record of detached passports
+// if there are any
+func exportRecords(list []entities.User, passports []entities.Passport) []userRecord {
+	byUser := make(map[int][]entities.Passport)
+	for _, p := range passports {
+		byUser[p.UserID] = append(byUser[p.UserID], p)
+	}
+	records := make([]userRecord, 0, len(list)+1)
+	for _, u := range list {
+		records = append(records, userRecord{User: u, Passports: byUser[u.ID]})
+	}
+	if detached := byUser[storage.NoHolder]; len(detached) > 0 {
+		records = append(records, detachedRecord(detached))
+	}
+	return records
+}
+
+// csvHeader lists the columns of a CSV export, shared by user and passport rows
+var csvHeader = []string{
+	"type", "id", "userId", "firstName", "lastName", "dateOfBirth", "locationOfBirth",
+	"authority", "dateOfIssue", "dateOfExpiry",
+}
+
+// writeCSV writes all users followed by all passports
+func writeCSV(w http.ResponseWriter, list []entities.User, passports []entities.Passport) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, u := range list {
+		cw.Write(csvRow(
+			"user", strconv.Itoa(u.ID), "", u.FirstName, u.LastName, csvTime(u.DateOfBirth),
+			u.LocationOfBirth, "", "", "",
+		))
+	}
+	for _, p := range passports {
+		cw.Write(csvRow(
+			"passport", p.ID, strconv.Itoa(p.UserID), "", "", "", "",
+			p.Authority, csvTime(p.DateOfIssue), csvTime(p.DateOfExpiry),
+		))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvRow escapes the cells of a row, see csvCell
+func csvRow(cells ...string) []string {
+	for i, c := range cells {
+		cells[i] = csvCell(c)
+	}
+	return cells
+}
+
+// csvEscaped lists the first characters of the cells escaped by csvCell: those a spreadsheet
+// takes for the start of a formula, and the quote of the escape itself
+const csvEscaped = "=+-@\t\r'"
+
+// csvCell escapes a cell that a spreadsheet would take for a formula by prefixing it with a
+// quote, so that user data opened in a spreadsheet is shown rather than evaluated. Numbers,
+// such as the user id of detached passports, are left alone.
+func csvCell(c string) string {
+	if _, err := strconv.Atoi(c); err == nil {
+		return c
+	}
+	if c != "" && strings.ContainsRune(csvEscaped, rune(c[0])) {
+		return "'" + c
+	}
+	return c
+}
+
+// csvUnescape reverses csvCell
+func csvUnescape(c string) string {
+	if len(c) > 1 && c[0] == '\'' && strings.ContainsRune(csvEscaped, rune(c[1])) {
+		return c[1:]
+	}
+	return c
+}
+
+// csvTime formats a time of a CSV export, leaving zero times empty
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// decodeCSV reads an export in CSV format as fixtures. Columns are found by their header, so
+// that their order doesn't matter, and empty dates are zero.
+func decodeCSV(r io.Reader) (storage.Fixtures, error) {
+	var fixtures storage.Fixtures
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return fixtures, &bodyError{http.StatusBadRequest, "malformed CSV: the header is missing"}
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range csvHeader {
+		if _, ok := columns[name]; !ok {
+			return fixtures, &bodyError{http.StatusBadRequest, "malformed CSV: column " + name + " is missing"}
+		}
+	}
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return fixtures, nil
+		}
+		if err != nil {
+			return fixtures, &bodyError{http.StatusBadRequest, "malformed CSV: " + err.Error()}
+		}
+		cell := func(name string) string {
+			return csvUnescape(row[columns[name]])
+		}
+		date := func(name string) time.Time {
+			if err != nil || cell(name) == "" {
+				return time.Time{}
+			}
+			var t time.Time
+			t, err = parseDate(cell(name))
+			return t
+		}
+		switch cell("type") {
+		case "user":
+			var u entities.User
+			u.ID, err = strconv.Atoi(cell("id"))
+			u.FirstName, u.LastName, u.LocationOfBirth = cell("firstName"), cell("lastName"), cell("locationOfBirth")
+			u.DateOfBirth = date("dateOfBirth")
+			fixtures.Users = append(fixtures.Users, u)
+		case "passport":
+			p := entities.Passport{
+				ID:           cell("id"),
+				Authority:    cell("authority"),
+				DateOfIssue:  date("dateOfIssue"),
+				DateOfExpiry: date("dateOfExpiry"),
+			}
+			if err == nil {
+				p.UserID, err = strconv.Atoi(cell("userId"))
+			}
+			fixtures.Passports = append(fixtures.Passports, p)
+		default:
+			err = fmt.Errorf("unknown type %q", cell("type"))
+		}
+		if err != nil {
+			return fixtures, &bodyError{http.StatusBadRequest, fmt.Sprintf("malformed CSV on line %d: %s", line, errorMessage(err))}
+		}
+	}
+}
+
+// flush sends buffered data to the client if the writer supports it
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// errorMessage returns an error message without stack information, fit for API clients
+func errorMessage(err error) string {
+	return fmt.Sprintf("%#s", err)
+}
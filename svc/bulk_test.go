@@ -0,0 +1,237 @@
+package svc
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchUsersHandlerJSONArray(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	body := `[
+		{"firstName": "Apple", "lastName": "Jack", "passports": [{"id": "123456789", "authority": "HMPO"}]},
+		{"firstName": "", "lastName": "Nameless"}
+	]`
+	req, _ := http.NewRequest("POST", "/users:batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	makeHandler(ctx, BatchUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var res batchResults
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, 1, res.Created, "they should be equal")
+	assert.Equal(t, 1, res.Failed, "they should be equal")
+	if assert.Equal(t, 2, len(res.Results)) {
+		assert.Equal(t, "201", res.Results[0].Status, "they should be equal")
+		assert.Equal(t, 2, res.Results[0].User.ID, "they should be equal")
+		assert.Equal(t, 2, res.Results[0].User.Passports[0].UserID, "they should be equal")
+		assert.Equal(t, "400", res.Results[1].Status, "they should be equal")
+	}
+	list, _ := ctx.DB.ListUsers(t.Context())
+	assert.Equal(t, 3, len(list), "There should be 3 items in the list.")
+}
+
+func TestBatchUsersHandlerNDJSON(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	body := "{\"firstName\": \"Apple\", \"lastName\": \"Jack\"}\n{not json}\n\n{\"firstName\": \"Big\", \"lastName\": \"Mac\"}\n"
+	req, _ := http.NewRequest("POST", "/users:batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	makeHandler(ctx, BatchUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var res batchResults
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, 2, res.Created, "they should be equal")
+	assert.Equal(t, 1, res.Failed, "they should be equal")
+}
+
+func TestBatchUsersHandlerAtomic(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	body := `[
+		{"firstName": "Apple", "lastName": "Jack"},
+		{"firstName": "Big", "lastName": "Mac", "passports": [{"id": "123456789", "authority": "HMPO"}]}
+	]`
+	req, _ := http.NewRequest("POST", "/users:batch?atomic=true", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	makeHandler(ctx, BatchUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "they should be equal")
+	var res batchResults
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, 0, res.Created, "they should be equal")
+	assert.Equal(t, "424", res.Results[0].Status, "they should be equal")
+	assert.Equal(t, "409", res.Results[1].Status, "they should be equal")
+	list, _ := ctx.DB.ListUsers(t.Context())
+	assert.Equal(t, 2, len(list), "the batch should have been rolled back")
+}
+
+func TestExportHandlerRoundTrip(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	req, _ := http.NewRequest("GET", "/export?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ExportHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "\n"), "there should be one line per user")
+
+	// importing the export into an empty database restores every user and passport
+	ctx.DB = &storage.MockDB{UserList: map[int]entities.User{}, PassportList: map[string]entities.Passport{}, MaxUserID: -1}
+	req, _ = http.NewRequest("POST", "/users:batch?atomic=true", strings.NewReader(w.Body.String()))
+	w = httptest.NewRecorder()
+	makeHandler(ctx, BatchUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	list, _ := ctx.DB.ListUsers(t.Context())
+	assert.Equal(t, 2, len(list), "they should be equal")
+	passports, _ := ctx.DB.ListUserPassports(t.Context(), 1)
+	assert.Equal(t, 1, len(passports), "they should be equal")
+}
+
+func TestBatchUsersHandlerFixtures(t *testing.T) {
+	ctx := newE2EContext(t)
+	w := httptest.NewRecorder()
+	NewHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/export", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+
+	// the users are added after those already there, and the passport follows its user
+	target := NewContext()
+	defer target.Close()
+	req := httptest.NewRequest("POST", "/users:batch?atomic=true", strings.NewReader(w.Body.String()))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	NewHandler(target).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var res batchResults
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 2, res.Created, "they should be equal")
+	passports, _ := target.DB.ListUserPassports(t.Context(), 3)
+	if assert.Equal(t, 1, len(passports), "they should be equal") {
+		assert.Equal(t, "123456789", passports[0].ID, "they should be equal")
+		assert.Equal(t, 3, passports[0].UserID, "they should be equal")
+	}
+
+	body := `{"users": [{"id": 7, "firstName": "Apple", "lastName": "Jack"}], "passports": [{"id": "987654321", "authority": "HMPO", "userId": 8}]}`
+	req = httptest.NewRequest("POST", "/users:batch", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	NewHandler(target).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "passport 987654321 refers to unknown user 8")
+
+	req = httptest.NewRequest("POST", "/users:batch", strings.NewReader(`{"users": [{"id": 7, "lastName": 3}]}`))
+	w = httptest.NewRecorder()
+	NewHandler(target).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "/users/0/lastName")
+}
+
+func TestExportHandlerCSV(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	req, _ := http.NewRequest("GET", "/export?format=csv", nil)
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ExportHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "text/csv; charset=UTF-8", w.Header().Get("Content-Type"), "they should be equal")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(rows), "header, two users and a passport")
+	assert.Equal(t, "passport", rows[3][0], "they should be equal")
+}
+
+func TestBatchUsersHandlerAtomicDuplicates(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	body := `[
+		{"firstName": "Apple", "lastName": "Jack", "passports": [{"id": "123456789", "authority": "HMPO"}]},
+		{"firstName": "Big", "lastName": "Mac", "passports": [{"id": "123456789", "authority": "IPS"}]}
+	]`
+	req, _ := http.NewRequest("POST", "/users:batch?atomic=true", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	makeHandler(ctx, BatchUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "they should be equal")
+	var res batchResults
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "424", res.Results[0].Status, "they should be equal")
+	assert.Equal(t, "409", res.Results[1].Status, "they should be equal")
+	assert.Nil(t, res.Results[0].User, "nothing was created")
+	list, _ := ctx.DB.ListUsers(t.Context())
+	assert.Equal(t, 2, len(list), "they should be equal")
+	passports, _ := ctx.DB.ListPassports(t.Context())
+	assert.Equal(t, 0, len(passports), "they should be equal")
+}
+
+func TestExportHandlerDetachedRoundTrip(t *testing.T) {
+	for _, format := range []string{"json", "ndjson", "csv"} {
+		ctx := NewContext()
+		ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+		ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "987654321", Authority: "IPS", UserID: 0})
+		_, err := ctx.DB.DeleteUser(t.Context(), 1, storage.DeleteOptions{Policy: storage.Detach})
+		assert.Nil(t, err)
+		req, _ := http.NewRequest("GET", "/export?format="+format, nil)
+		w := httptest.NewRecorder()
+		makeHandler(ctx, ExportHandler).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, format)
+		ctx.Close()
+
+		// the detached passport is restored without a holder, the other one follows its user
+		target := &storage.MockDB{UserList: map[int]entities.User{}, PassportList: map[string]entities.Passport{}, MaxUserID: -1}
+		ctx = NewContext()
+		ctx.DB = target
+		req, _ = http.NewRequest("POST", "/users:batch?atomic=true", strings.NewReader(w.Body.String()))
+		req.Header.Set("Content-Type", w.Header().Get("Content-Type"))
+		w = httptest.NewRecorder()
+		makeHandler(ctx, BatchUsersHandler).ServeHTTP(w, req)
+		ctx.Close()
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		list, _ := target.ListUsers(t.Context())
+		assert.Equal(t, 1, len(list), format)
+		assert.Equal(t, storage.NoHolder, target.PassportList["123456789"].UserID, format)
+		assert.Equal(t, 0, target.PassportList["987654321"].UserID, format)
+	}
+}
+
+func TestExportHandlerCSVEscaping(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB.UpdateUser(t.Context(), entities.User{ID: 1, FirstName: "=HYPERLINK(\"x\")", LastName: "'@SUM(A1)"})
+	req, _ := http.NewRequest("GET", "/export?format=csv", nil)
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ExportHandler).ServeHTTP(w, req)
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if !assert.Nil(t, err) || !assert.Equal(t, 3, len(rows)) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"user", "1", "", "'=HYPERLINK(\"x\")", "''@SUM(A1)", "", "", "", "", ""}, rows[2], "they should be equal")
+
+	// the escaping is undone on import
+	fixtures, err := decodeCSV(strings.NewReader(w.Body.String()))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "=HYPERLINK(\"x\")", fixtures.Users[1].FirstName, "they should be equal")
+		assert.Equal(t, "'@SUM(A1)", fixtures.Users[1].LastName, "they should be equal")
+		assert.True(t, fixtures.Users[1].DateOfBirth.IsZero())
+	}
+}
+
+func TestExportFormat(t *testing.T) {
+	for accept, format := range map[string]string{
+		"":                                 "json",
+		"text/csv":                         "csv",
+		"text/csv;q=0, application/json":   "json",
+		"application/json;q=0.5, text/csv": "csv",
+		"application/x-ndjson, text/csv":   "ndjson",
+		"text/*":                           "csv",
+		"image/png":                        "json",
+	} {
+		assert.Equal(t, format, exportFormat(accept), accept)
+	}
+}
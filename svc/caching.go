@@ -0,0 +1,56 @@
+package svc
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/cache"
+	"github.com/kostiamol/go-rest-api-template/domain"
+)
+
+// lastModifier is implemented by storage that knows when users last changed, such as cache.Store
+type lastModifier interface {
+	UsersModified() (time.Time, bool)
+	UserModified(i int) (time.Time, bool)
+}
+
+// Cached puts the user reads of a tenant's store behind c, unless caching is disabled
+func Cached(store domain.Store, c cache.Cache, tenant string, ttl time.Duration) Storager {
+	if c == nil || ttl <= 0 {
+		return store
+	}
+	return cache.NewStore(store, c, tenant, ttl)
+}
+
+// cacheHeaders sets the headers that let clients cache a read and reports whether the copy the
+// client already has is still current, in which case it has responded 304. Reads are private to
+// the tenant and the token they were made with, so only clients cache them, not shared proxies.
+//
+// Last-Modified has a precision of a second, so the time the data was cached is rounded up to the
+// next second, and the header is only sent once that second is over: data that changes again
+// within the second is cached anew before then, and no two versions share a Last-Modified date.
+func cacheHeaders(w http.ResponseWriter, req *http.Request, ctx Context, modified time.Time, known bool) bool {
+	if ctx.CacheTTL <= 0 {
+		return false
+	}
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(ctx.CacheTTL/time.Second)))
+	w.Header().Add("Vary", "Authorization, X-Tenant-ID")
+	if !known {
+		return false
+	}
+	lastModified := modified.UTC().Truncate(time.Second)
+	if lastModified.Before(modified) {
+		lastModified = lastModified.Add(time.Second)
+	}
+	if time.Now().Before(lastModified) {
+		return false
+	}
+	w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,209 @@
+package svc
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressionMinSize is the size, in bytes, from which responses are compressed when the
+// context doesn't set one. Smaller responses gain too little to be worth it.
+const DefaultCompressionMinSize = 1024
+
+// gzipBodies are the routes that take gzip-compressed request bodies, large by design
+var gzipBodies = map[string]bool{
+	"BatchUsers": true,
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// compressible reports whether responses of a content type gain from compression. Event streams
+// are left alone so that every event reaches the client as soon as it is flushed.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/x-ndjson" || mediaType == "application/javascript"
+}
+
+// withCompression gzips the responses of h for clients accepting it, once they reach the minimum
+// size of the context, see DefaultCompressionMinSize
+func withCompression(ctx Context, h http.Handler) http.Handler {
+	minSize := ctx.CompressionMinSize
+	if minSize <= 0 {
+		minSize = DefaultCompressionMinSize
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if req.Method == "HEAD" || !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, minSize: minSize}
+		defer cw.Close()
+		h.ServeHTTP(cw, req)
+	})
+}
+
+// compressWriter holds back the status and the start of a response until it knows whether to
+// compress it: when the response reaches the minimum size, or is flushed as streams are, it is
+// compressed if its type is worth it; when it ends before, it is sent as it is.
+type compressWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	code    int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.started {
+		c.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if c.code == 0 {
+		c.code = code
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.started {
+		return c.write(p)
+	}
+	if c.code == 0 {
+		c.code = http.StatusOK
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.minSize {
+		if err := c.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush starts the response, compressed if its type is worth it, and sends what is buffered
+func (c *compressWriter) Flush() {
+	if !c.started {
+		c.start(true)
+	}
+	if c.gz != nil {
+		c.gz.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the response, sending it as it is if it never reached the minimum size
+func (c *compressWriter) Close() error {
+	if !c.started {
+		if err := c.start(false); err != nil {
+			return err
+		}
+	}
+	if c.gz != nil {
+		return c.gz.Close()
+	}
+	return nil
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// start writes the status held back and the buffered start of the response, compressed if
+// compress is set and the response is worth it
+func (c *compressWriter) start(compress bool) error {
+	c.started = true
+	h := c.Header()
+	if compress && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		c.gz = gzip.NewWriter(c.ResponseWriter)
+	}
+	if c.code != 0 {
+		c.ResponseWriter.WriteHeader(c.code)
+	}
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := c.write(buf)
+	return err
+}
+
+func (c *compressWriter) write(p []byte) (int, error) {
+	if c.gz != nil {
+		return c.gz.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// decompressBody serves h the request bodies sent gzip-compressed, if accept is set, decompressed
+// and limited to size bytes once decompressed. Bodies in other encodings are answered with 415.
+func decompressBody(ctx Context, accept bool, size int64, h http.Handler) http.Handler {
+	accepted := "identity"
+	if accept {
+		accepted = "gzip, identity"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+		switch {
+		case encoding == "" || encoding == "identity":
+		case accept && (encoding == "gzip" || encoding == "x-gzip"):
+			gz, err := gzip.NewReader(req.Body)
+			if err != nil {
+				readFailed(w, req, ctx, strictError(err), "the body isn't valid gzip")
+				return
+			}
+			req.Body = http.MaxBytesReader(w, gz, size)
+			req.Header.Del("Content-Encoding")
+			req.ContentLength = -1
+		default:
+			w.Header().Set("Accept-Encoding", accepted)
+			response := status{
+				Status:  "415",
+				Message: "the body can't be encoded with " + encoding + ", use " + accepted,
+			}
+			respond(w, req, ctx, http.StatusUnsupportedMediaType, response)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
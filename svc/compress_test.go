@@ -0,0 +1,150 @@
+package svc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gunzip decompresses a response body
+func gunzip(t *testing.T, body []byte) string {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	data, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+// gzipped compresses a request body
+func gzipped(s string) *bytes.Buffer {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte(s))
+	gz.Close()
+	return &b
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, accepts := range map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"gzip, deflate, br":     true,
+		"deflate, GZIP;q=0.5":   true,
+		"br;q=1.0, gzip;q=0":    false,
+		"*":                     true,
+		"*;q=0":                 false,
+		"*, gzip;q=0":           false,
+		"identity, x-gzip;q=.8": true,
+		"deflate":               false,
+	} {
+		assert.Equal(t, accepts, acceptsGzip(header), "Accept-Encoding: %s", header)
+	}
+}
+
+func TestResponseCompression(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.CompressionMinSize = 256
+	handler := NewHandler(ctx)
+	serve := func(target, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	plain := serve("/openapi.json", "")
+	assert.Equal(t, "", plain.Header().Get("Content-Encoding"), "they should be equal")
+	assert.Contains(t, plain.Header()["Vary"], "Accept-Encoding")
+
+	w := serve("/openapi.json", "gzip, br")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), "they should be equal")
+	assert.Equal(t, "", w.Header().Get("Content-Length"), "they should be equal")
+	assert.True(t, w.Body.Len() < plain.Body.Len(), "the response is smaller")
+	assert.Equal(t, plain.Body.String(), gunzip(t, w.Body.Bytes()), "they should be equal")
+
+	// errors keep their status
+	w = serve("/"+strings.Repeat("x", 512), "gzip")
+	assert.Equal(t, http.StatusNotFound, w.Code, "they should be equal")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), "they should be equal")
+	assert.Contains(t, gunzip(t, w.Body.Bytes()), `"status":"404"`)
+
+	// responses below the minimum size are sent as they are
+	w = serve("/users/1", "gzip")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"), "they should be equal")
+	assert.Contains(t, w.Body.String(), `"firstName"`)
+
+	// streamed exports are compressed as they are flushed
+	w = serve("/export?format=ndjson", "gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), "they should be equal")
+	assert.Contains(t, gunzip(t, w.Body.Bytes()), `"firstName"`)
+
+	w = serve("/users", "identity")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"), "they should be equal")
+}
+
+func TestEventStreamIsNotCompressed(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.CompressionMinSize = 1
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	reqCtx, cancel := context.WithCancel(req.Context())
+	cancel()
+	w := httptest.NewRecorder()
+	NewHandler(ctx).ServeHTTP(w, req.WithContext(reqCtx))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"), "they should be equal")
+}
+
+func TestCompressedRequestBodies(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ndjson := "{\"firstName\": \"Apple\", \"lastName\": \"Jack\"}\n{\"firstName\": \"Big\", \"lastName\": \"Mac\"}\n"
+	serve := func(ctx Context, target, encoding string, body *bytes.Buffer) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, body)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("Content-Encoding", encoding)
+		w := httptest.NewRecorder()
+		NewHandler(ctx).ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(ctx, "/users:batch", "gzip", gzipped(ndjson))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"created":2`)
+
+	w = serve(ctx, "/users:batch", "gzip", bytes.NewBufferString(ndjson))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "the body isn't valid gzip")
+
+	w = serve(ctx, "/users:batch", "br", bytes.NewBufferString(ndjson))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code, "they should be equal")
+	assert.Equal(t, "gzip, identity", w.Header().Get("Accept-Encoding"), "they should be equal")
+
+	// other routes don't take compressed bodies
+	w = serve(ctx, "/users", "gzip", gzipped(`{"firstName": "Apple", "lastName": "Jack"}`))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code, "they should be equal")
+	assert.Equal(t, "identity", w.Header().Get("Accept-Encoding"), "they should be equal")
+
+	// the size limit applies to the decompressed body
+	saved := routeBodySizes["BatchUsers"]
+	routeBodySizes["BatchUsers"] = 1024
+	defer func() { routeBodySizes["BatchUsers"] = saved }()
+	w = serve(ctx, "/users:batch", "gzip", gzipped(strings.Repeat(ndjson, 100)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+}
@@ -0,0 +1,412 @@
+package svc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxValidatedResponse bounds the responses checked against the OpenAPI document; larger ones,
+// such as big exports, are passed through unchecked
+const maxValidatedResponse = 1 << 20
+
+// validationError locates a part of a request, or response, that doesn't match the OpenAPI
+// document: a body field by its JSON pointer, or a parameter by its name
+type validationError struct {
+	// JSON pointer of the offending body field, "" for the body as a whole
+	Pointer string `json:"pointer,omitempty"`
+	// Name of the offending parameter
+	Parameter string `json:"parameter,omitempty"`
+	// What is wrong with it
+	Message string `json:"message"`
+}
+
+// invalidRequest is the response to a request that doesn't match the OpenAPI document
+// swagger:response invalidRequest
+type invalidRequest struct {
+	// HTTP status code
+	Status string `json:"status"`
+	// The status message
+	Message string `json:"message"`
+	// What is wrong with the request
+	Errors []validationError `json:"errors"`
+}
+
+// conformance checks requests and responses against the schemas of an OpenAPI document
+type conformance struct {
+	schemas map[string]*schema
+	// responses are checked too, and mismatches logged
+	responses bool
+}
+
+// validated checks the requests of an operation before h handles them, answering 400 with the
+// details of every mismatch. Bodies are checked when they are JSON; other content types, such as
+// NDJSON batches, are left to the handler.
+func (c conformance) validated(ctx Context, op *openAPIOperation, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		errs := c.request(op, req)
+		if len(errs) > 0 {
+			response := invalidRequest{
+				Status:  "400",
+				Message: "invalid request",
+				Errors:  errs,
+			}
+			respond(w, req, ctx, http.StatusBadRequest, response)
+			return
+		}
+		if !c.responses || !producesJSON(op) {
+			h.ServeHTTP(w, req)
+			return
+		}
+		rec := &responseRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, req)
+		if rec.overflow {
+			return
+		}
+		for _, e := range c.response(op, rec.code, rec.Header().Get("Content-Type"), rec.body.Bytes()) {
+			log.Printf("response %d of %s doesn't match the OpenAPI document: %s %s", rec.code, op.OperationID, e.Pointer, e.Message)
+		}
+	})
+}
+
+// producesJSON reports whether an operation answers with JSON rather than a stream
+func producesJSON(op *openAPIOperation) bool {
+	for _, r := range op.Responses {
+		if _, ok := r.Content["application/json"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// request returns the mismatches of a request, sorted
+func (c conformance) request(op *openAPIOperation, req *http.Request) []validationError {
+	var errs []validationError
+	vars := mux.Vars(req)
+	q := req.URL.Query()
+	for _, p := range op.Parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw, present = vars[p.Name]
+		case "query":
+			raw, present = q.Get(p.Name), q.Has(p.Name)
+		case "header":
+			raw = req.Header.Get(p.Name)
+			present = raw != ""
+		}
+		if !present {
+			if p.Required {
+				errs = append(errs, validationError{Parameter: p.Name, Message: "is required"})
+			}
+			continue
+		}
+		if message := c.parameter(p.Schema, raw); message != "" {
+			errs = append(errs, validationError{Parameter: p.Name, Message: message})
+		}
+	}
+	if op.RequestBody != nil {
+		errs = append(errs, c.body(op.RequestBody, req)...)
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Parameter != errs[j].Parameter {
+			return errs[i].Parameter < errs[j].Parameter
+		}
+		return errs[i].Pointer < errs[j].Pointer
+	})
+	return errs
+}
+
+// parameter checks the raw value of a parameter, returning what is wrong with it
+func (c conformance) parameter(s *schema, raw string) string {
+	switch s.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(raw, 10, 64); err != nil {
+			return "must be an integer"
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			return "must be a number"
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(raw); err != nil {
+			return "must be true or false"
+		}
+	case "string":
+		var errs []validationError
+		c.value(s, raw, "", &errs)
+		if len(errs) > 0 {
+			return errs[0].Message
+		}
+	}
+	return ""
+}
+
+// body checks a JSON request body, which it leaves for the handler to read again. A body that
+// can't be read, e.g. as it is too large, is left to the handler to fail on.
+func (c conformance) body(rb *openAPIRequestBody, req *http.Request) []validationError {
+	media, ok := rb.Content["application/json"]
+	if !ok || !isJSONContent(req.Header.Get("Content-Type")) || req.Body == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(data), req.Body))
+		return nil
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if len(bytes.TrimSpace(data)) == 0 {
+		if rb.Required {
+			return []validationError{{Message: "a body is required"}}
+		}
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return []validationError{{Message: "the body is not valid JSON"}}
+	}
+	var errs []validationError
+	c.value(media.Schema, v, "", &errs)
+	return errs
+}
+
+// response returns the mismatches of a JSON response
+func (c conformance) response(op *openAPIOperation, code int, contentType string, body []byte) []validationError {
+	r, ok := op.Responses[strconv.Itoa(code)]
+	if !ok {
+		r, ok = op.Responses["default"]
+	}
+	if !ok {
+		return []validationError{{Message: "the status " + strconv.Itoa(code) + " isn't documented"}}
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := r.Content[mediaType]
+	if mediaType != "application/json" || !ok || len(body) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return []validationError{{Message: "the body is not valid JSON"}}
+	}
+	var errs []validationError
+	c.value(media.Schema, v, "", &errs)
+	return errs
+}
+
+// value checks a decoded JSON value against a schema, adding the mismatches found at pointer and
+// below to errs. Null stands for a missing value and matches any schema.
+func (c conformance) value(s *schema, v interface{}, pointer string, errs *[]validationError) {
+	if s == nil || v == nil {
+		return
+	}
+	fail := func(message string) {
+		*errs = append(*errs, validationError{Pointer: pointer, Message: message})
+	}
+	if s.Ref != "" {
+		c.value(c.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")], v, pointer, errs)
+	}
+	for _, sub := range s.AllOf {
+		c.value(sub, v, pointer, errs)
+	}
+	if len(s.OneOf) > 0 {
+		c.oneOf(s.OneOf, v, pointer, errs)
+	}
+	switch s.Type {
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		c.format(s, str, fail)
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("must be an integer")
+			return
+		}
+		if _, err := n.Int64(); err != nil {
+			if f, err := n.Float64(); err != nil || f != math.Trunc(f) {
+				fail("must be an integer")
+			}
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			fail("must be a number")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be true or false")
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range list {
+			c.value(s.Items, item, pointer+"/"+strconv.Itoa(i), errs)
+		}
+	case "object":
+		if _, ok := v.(map[string]interface{}); !ok {
+			fail("must be an object")
+			return
+		}
+	}
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, name := range s.Required {
+		if value, ok := object[name]; !ok || value == nil {
+			*errs = append(*errs, validationError{Pointer: pointer + "/" + escapePointer(name), Message: "is required"})
+		}
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := pointer + "/" + escapePointer(name)
+		if property, ok := s.Properties[name]; ok {
+			c.value(property, object[name], field, errs)
+		} else if s.AdditionalProperties != nil {
+			c.value(s.AdditionalProperties, object[name], field, errs)
+		}
+	}
+}
+
+// oneOf checks a value against the schemas it must match one of. When it matches none, the
+// mismatches reported are those of the first schema of the value's type.
+func (c conformance) oneOf(schemas []*schema, v interface{}, pointer string, errs *[]validationError) {
+	var closest []validationError
+	for _, s := range schemas {
+		var mismatches []validationError
+		c.value(s, v, pointer, &mismatches)
+		if len(mismatches) == 0 {
+			return
+		}
+		if closest == nil && c.typeOf(s) == jsonType(v) {
+			closest = mismatches
+		}
+	}
+	if closest == nil {
+		closest = []validationError{{Pointer: pointer, Message: "doesn't match any of the allowed schemas"}}
+	}
+	*errs = append(*errs, closest...)
+}
+
+// typeOf returns the type of a schema, following its reference
+func (c conformance) typeOf(s *schema) string {
+	for s != nil && s.Type == "" && s.Ref != "" {
+		s = c.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	if s == nil {
+		return ""
+	}
+	if s.Type == "integer" {
+		return "number"
+	}
+	return s.Type
+}
+
+// jsonType returns the schema type of a decoded JSON value, number for integers
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return ""
+}
+
+// format checks the enum, pattern and format of a string
+func (c conformance) format(s *schema, str string, fail func(string)) {
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || e == str
+		}
+		if !found {
+			fail("must be one of " + strings.Join(s.Enum, ", "))
+		}
+	}
+	if s.Pattern != "" {
+		if ok, err := regexp.MatchString(s.Pattern, str); err == nil && !ok {
+			fail("must match " + s.Pattern)
+		}
+	}
+	switch s.Format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, str); err != nil {
+			fail("must be a date-time such as 2006-01-02T15:04:05Z")
+		}
+	case "date":
+		if _, err := parseDate(str); err != nil {
+			fail("must be a date such as 2006-01-02")
+		}
+	}
+}
+
+// escapePointer escapes a name for use in a JSON pointer
+func escapePointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
+
+// responseRecorder passes a response through while keeping a copy of its body for validation
+type responseRecorder struct {
+	http.ResponseWriter
+	code     int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if !r.overflow {
+		if r.body.Len()+len(p) > maxValidatedResponse {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,101 @@
+package svc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveRouter sends a request through the router of a test context
+func serveRouter(method, target, body, contentType string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	ctx := NewContext()
+	defer ctx.Close()
+	w := httptest.NewRecorder()
+	NewRouter(ctx).ServeHTTP(w, req)
+	return w
+}
+
+func TestRequestValidation(t *testing.T) {
+	w := serveRouter("POST", "/users", `{"firstName": 1, "dateOfBirth": "yesterday"}`, "application/json")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	var res invalidRequest
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "invalid request", res.Message, "they should be equal")
+	assert.Equal(t, []validationError{
+		{Pointer: "/dateOfBirth", Message: "must be a date-time such as 2006-01-02T15:04:05Z"},
+		{Pointer: "/firstName", Message: "must be a string"},
+		{Pointer: "/lastName", Message: "is required"},
+	}, res.Errors, "they should be equal")
+
+	w = serveRouter("POST", "/users:batch", `[{"firstName": "Apple", "lastName": "Jack", "passports": [{"id": 7}]}]`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `"pointer":"/0/passports/0/id"`)
+
+	w = serveRouter("DELETE", "/users/1?dryRun=maybe", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `{"parameter":"dryRun","message":"must be true or false"}`)
+
+	w = serveRouter("GET", "/passports?expiringBefore=soon", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `"parameter":"expiringBefore"`)
+
+	w = serveRouter("GET", "/export?format=xml", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `must be one of json, ndjson, csv`)
+
+	w = serveRouter("GET", "/users/99999999999999999999", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+
+	w = serveRouter("POST", "/users", "", "application/json")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "a body is required")
+
+	// valid requests, and bodies that aren't JSON, reach the handler
+	w = serveRouter("POST", "/users", `{"firstName": "Apple", "lastName": "Jack", "dateOfBirth": "1990-01-01T00:00:00Z"}`, "application/json")
+	assert.Equal(t, http.StatusCreated, w.Code, "they should be equal")
+	w = serveRouter("POST", "/users:batch", `{"firstName": "Apple", "lastName": "Jack"}`+"\n", "application/x-ndjson")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+}
+
+func TestResponseValidation(t *testing.T) {
+	doc, err := buildOpenAPI("0.0.0", allRoutes())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	c := conformance{schemas: doc.Components.Schemas, responses: true}
+	op := doc.Paths["/users/{uid}"]["get"]
+
+	errs := c.response(op, http.StatusOK, "application/json; charset=UTF-8", []byte(`{"id": 1, "firstName": "Jane", "lastName": "Doe"}`))
+	assert.Equal(t, 0, len(errs), "they should be equal")
+	errs = c.response(op, http.StatusOK, "application/json", []byte(`{"id": "1", "firstName": "Jane"}`))
+	assert.Equal(t, []validationError{{Pointer: "/id", Message: "must be an integer"}}, errs, "they should be equal")
+	errs = c.response(op, http.StatusNotFound, "application/json", []byte(`{"status": 404, "message": "can't find user"}`))
+	assert.Equal(t, []validationError{{Pointer: "/status", Message: "must be a string"}}, errs, "they should be equal")
+
+	// what the handlers answer matches the document
+	ctx := NewContext()
+	defer ctx.Close()
+	for _, target := range []string{"/users", "/users/1", "/users/7", "/passports", "/health", "/ready", "/webhooks"} {
+		route := NewRouter(ctx)
+		var match mux.RouteMatch
+		req, _ := http.NewRequest("GET", target, nil)
+		if !assert.True(t, route.Match(req, &match)) {
+			continue
+		}
+		template, _ := match.Route.GetPathTemplate()
+		path, _ := openAPIPath(template)
+		w := httptest.NewRecorder()
+		route.ServeHTTP(w, req)
+		errs := c.response(doc.Paths[path]["get"], w.Code, w.Header().Get("Content-Type"), w.Body.Bytes())
+		assert.Equal(t, 0, len(errs), "they should be equal")
+	}
+}
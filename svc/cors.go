@@ -0,0 +1,184 @@
+package svc
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS is the policy for cross-origin requests from browsers. It is disabled without allowed origins.
+type CORS struct {
+	// Origins allowed to call the API, e.g. https://app.example.com. * allows any origin, but only
+	// without credentials, and https://*.example.com any subdomain.
+	AllowedOrigins []string
+	// Methods allowed cross-origin, those of the route if empty
+	AllowedMethods []string
+	// Request headers allowed cross-origin, besides the ones browsers always allow
+	AllowedHeaders []string
+	// Whether cross-origin requests may carry cookies and Authorization headers
+	AllowCredentials bool
+	// How long browsers may cache the answer to a preflight request, not cached if zero
+	MaxAge time.Duration
+}
+
+// corsExposedHeaders are the response headers set by the API that scripts may read
+var corsExposedHeaders = []string{"Deprecation", "Sunset", "Link", "Last-Modified", "Location"}
+
+// enabled reports whether cross-origin requests are allowed at all
+func (c CORS) enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for an origin, "" if the origin
+// isn't allowed. Credentialed responses name the origin, as browsers reject * for them; * doesn't
+// allow credentialed requests at all, as reflecting any origin would let every site read them.
+func (c CORS) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range c.AllowedOrigins {
+		switch {
+		case allowed == "*":
+			if !c.AllowCredentials {
+				return "*"
+			}
+		case strings.EqualFold(allowed, origin):
+			return origin
+		case strings.Contains(allowed, "://*."):
+			scheme := allowed[:strings.Index(allowed, "*")]
+			suffix := allowed[strings.Index(allowed, "*")+1:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) && len(origin) > len(scheme)+len(suffix) {
+				return origin
+			}
+		}
+	}
+	return ""
+}
+
+// allowMethods returns the methods of a route allowed cross-origin
+func (c CORS) allowMethods(methods []string) []string {
+	if len(c.AllowedMethods) == 0 {
+		return methods
+	}
+	var allowed []string
+	for _, m := range methods {
+		for _, a := range c.AllowedMethods {
+			if strings.EqualFold(m, a) {
+				allowed = append(allowed, m)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// allowHeaders reports whether the request headers named by Access-Control-Request-Headers are all allowed
+func (c CORS) allowHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" || corsSafelisted(h) {
+			continue
+		}
+		found := false
+		for _, a := range c.AllowedHeaders {
+			found = found || a == "*" || strings.EqualFold(a, h)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// corsSafelisted reports whether browsers send a header cross-origin without asking
+func corsSafelisted(h string) bool {
+	switch strings.ToLower(h) {
+	case "accept", "accept-language", "content-language", "content-type":
+		return true
+	}
+	return false
+}
+
+// withCORS adds the CORS headers to the responses to cross-origin requests of allowed origins.
+// Preflight requests are answered by the OPTIONS routes of the router, see preflight.
+func withCORS(ctx Context, h http.Handler) http.Handler {
+	if !ctx.CORS.enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "OPTIONS" {
+			h.ServeHTTP(w, req)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if origin := ctx.CORS.allowOrigin(req.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			if ctx.CORS.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// routeMethods returns the methods each pattern of the routes is served with
+func routeMethods(routes Routes) map[string][]string {
+	methods := make(map[string][]string)
+	for _, route := range routes {
+		methods[route.Pattern] = append(methods[route.Pattern], route.Method)
+	}
+	for _, m := range methods {
+		sort.Strings(m)
+	}
+	return methods
+}
+
+// preflight answers the OPTIONS requests for a path served with methods. Preflight requests of
+// allowed origins, for allowed methods and headers, get the CORS headers allowing the request;
+// others, and plain OPTIONS requests, only get the Allow header.
+func preflight(ctx Context, methods []string) http.Handler {
+	allow := strings.Join(append(append([]string{}, methods...), "OPTIONS"), ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", allow)
+		method := req.Header.Get("Access-Control-Request-Method")
+		if !ctx.CORS.enabled() || method == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		origin := ctx.CORS.allowOrigin(req.Header.Get("Origin"))
+		allowed := ctx.CORS.allowMethods(methods)
+		requested := req.Header.Get("Access-Control-Request-Headers")
+		if origin == "" || !contains(allowed, method) || !ctx.CORS.allowHeaders(requested) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+		if requested != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requested)
+		}
+		if ctx.CORS.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if ctx.CORS.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(ctx.CORS.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
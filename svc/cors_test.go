@@ -0,0 +1,113 @@
+package svc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveCORS sends a request with headers to the service under a CORS policy
+func serveCORS(policy CORS, method, target string, headers map[string]string) *httptest.ResponseRecorder {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.CORS = policy
+	req, _ := http.NewRequest(method, target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	NewHandler(ctx).ServeHTTP(w, req)
+	return w
+}
+
+func TestPreflight(t *testing.T) {
+	policy := CORS{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		AllowedHeaders: []string{"Authorization", "X-Tenant-ID"},
+		MaxAge:         10 * time.Minute,
+	}
+	w := serveCORS(policy, "OPTIONS", "/v2/users/1", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "content-type, x-tenant-id",
+	})
+	assert.Equal(t, http.StatusNoContent, w.Code, "they should be equal")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+	assert.Equal(t, "DELETE, GET, PUT", w.Header().Get("Access-Control-Allow-Methods"), "they should be equal")
+	assert.Equal(t, "content-type, x-tenant-id", w.Header().Get("Access-Control-Allow-Headers"), "they should be equal")
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"), "they should be equal")
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Credentials"), "they should be equal")
+	assert.Equal(t, "DELETE, GET, PUT, OPTIONS", w.Header().Get("Allow"), "they should be equal")
+	assert.Contains(t, w.Header()["Vary"], "Origin")
+
+	w = serveCORS(policy, "OPTIONS", "/users", map[string]string{
+		"Origin":                        "https://admin.example.org",
+		"Access-Control-Request-Method": "POST",
+	})
+	assert.Equal(t, "https://admin.example.org", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"), "they should be equal")
+
+	// origins, methods and headers that aren't allowed get no CORS headers
+	for _, headers := range []map[string]string{
+		{"Origin": "https://evil.example.com", "Access-Control-Request-Method": "GET"},
+		{"Origin": "http://app.example.org", "Access-Control-Request-Method": "GET"},
+		{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST"},
+		{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Debug"},
+	} {
+		w = serveCORS(policy, "OPTIONS", "/v2/users/1", headers)
+		assert.Equal(t, http.StatusNoContent, w.Code, "they should be equal")
+		assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+	}
+
+	// methods can be restricted further
+	policy.AllowedMethods = []string{"GET"}
+	w = serveCORS(policy, "OPTIONS", "/v2/users/1", map[string]string{
+		"Origin":                        "https://app.example.com",
+		"Access-Control-Request-Method": "DELETE",
+	})
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+
+	// without a policy, or without asking for a method, OPTIONS lists the methods
+	w = serveCORS(CORS{}, "OPTIONS", "/passports/1", map[string]string{
+		"Origin":                        "https://app.example.com",
+		"Access-Control-Request-Method": "GET",
+	})
+	assert.Equal(t, http.StatusNoContent, w.Code, "they should be equal")
+	assert.Equal(t, "DELETE, GET, PUT, OPTIONS", w.Header().Get("Allow"), "they should be equal")
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+}
+
+func TestCORSResponses(t *testing.T) {
+	policy := CORS{AllowedOrigins: []string{"*"}}
+	w := serveCORS(policy, "GET", "/v1/users/1", map[string]string{"Origin": "https://app.example.com"})
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+	assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "Deprecation")
+
+	// credentials require the origin to be named, * doesn't allow them
+	policy.AllowCredentials = true
+	w = serveCORS(policy, "GET", "/users", map[string]string{"Origin": "https://evil.example.com"})
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Credentials"), "they should be equal")
+	w = serveCORS(policy, "OPTIONS", "/users", map[string]string{
+		"Origin":                        "https://evil.example.com",
+		"Access-Control-Request-Method": "POST",
+	})
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+
+	policy.AllowedOrigins = []string{"*", "https://app.example.com"}
+	w = serveCORS(policy, "GET", "/nope", map[string]string{"Origin": "https://app.example.com"})
+	assert.Equal(t, http.StatusNotFound, w.Code, "they should be equal")
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"), "they should be equal")
+
+	w = serveCORS(policy, "GET", "/users", nil)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+	assert.Contains(t, w.Header()["Vary"], "Origin")
+
+	w = serveCORS(CORS{}, "GET", "/users", map[string]string{"Origin": "https://app.example.com"})
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"), "they should be equal")
+}
@@ -0,0 +1,180 @@
+package svc
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+// e2eCase is a request sent to the whole service and the status it is answered with
+type e2eCase struct {
+	method string
+	target string
+	body   string
+	code   int
+}
+
+// newE2EContext returns a test context whose user 1 holds a passport
+func newE2EContext(t *testing.T) Context {
+	ctx := NewContext()
+	t.Cleanup(ctx.Close)
+	_, err := ctx.DB.AddPassport(t.Context(), entities.Passport{
+		ID:          "123456789",
+		Authority:   "HMPO",
+		UserID:      1,
+		DateOfIssue: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return ctx
+}
+
+// e2eCases returns a request for every route, the versioned ones in every version
+func e2eCases() []e2eCase {
+	cases := []e2eCase{
+		{"GET", "/health", "", http.StatusOK},
+		{"GET", "/ready", "", http.StatusOK},
+		{"GET", "/openapi.json", "", http.StatusOK},
+		{"GET", "/docs", "", http.StatusOK},
+		{"POST", "/users:batch", `[{"firstName": "Apple", "lastName": "Jack"}]`, http.StatusOK},
+		{"GET", "/export", "", http.StatusOK},
+		{"GET", "/graphql", "", http.StatusOK},
+		{"POST", "/graphql", `{"query": "{ users { id } }"}`, http.StatusOK},
+		{"GET", "/events", "", http.StatusOK},
+		{"GET", "/webhooks", "", http.StatusOK},
+		{"POST", "/webhooks", `{"url": "http://127.0.0.1:1/hook"}`, http.StatusCreated},
+		{"DELETE", "/webhooks/nope", "", http.StatusNotFound},
+		{"GET", "/webhooks/deadletters", "", http.StatusOK},
+	}
+	users := map[string]string{
+		"":    `{"id": 1, "firstName": "Apple", "lastName": "Jack"}`,
+		"/v1": `{"id": 1, "firstName": "Apple", "lastName": "Jack"}`,
+		"/v2": `{"id": 1, "name": {"first": "Apple", "last": "Jack"}}`,
+	}
+	for _, prefix := range []string{"", "/v1", "/v2"} {
+		cases = append(cases,
+			e2eCase{"GET", prefix + "/", "", http.StatusOK},
+			e2eCase{"GET", prefix + "/users", "", http.StatusOK},
+			e2eCase{"GET", prefix + "/users/1", "", http.StatusOK},
+			e2eCase{"POST", prefix + "/users", users[prefix], http.StatusCreated},
+			e2eCase{"PUT", prefix + "/users/1", users[prefix], http.StatusOK},
+			e2eCase{"DELETE", prefix + "/users/0", "", http.StatusNoContent},
+			e2eCase{"GET", prefix + "/users/1/passports", "", http.StatusOK},
+			e2eCase{"GET", prefix + "/passports", "", http.StatusOK},
+			e2eCase{"GET", prefix + "/passports/123456789", "", http.StatusOK},
+			e2eCase{"POST", prefix + "/users/0/passports", `{"id": "987654321", "authority": "HMPO"}`, http.StatusCreated},
+			e2eCase{"PUT", prefix + "/passports/123456789", `{"id": "123456789", "authority": "HMPO", "userId": 1, "dateOfExpiry": "2030-01-01T00:00:00Z"}`, http.StatusOK},
+			e2eCase{"DELETE", prefix + "/passports/123456789", "", http.StatusNoContent},
+		)
+	}
+	return cases
+}
+
+// serveE2E sends a request to a fresh service. The event stream is sent a cancelled request, so
+// that it ends once it has started.
+func serveE2E(t *testing.T, c e2eCase) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(c.method, c.target, strings.NewReader(c.body))
+	if c.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.target == "/events" {
+		reqCtx, cancel := context.WithCancel(req.Context())
+		cancel()
+		req = req.WithContext(reqCtx)
+	}
+	w := httptest.NewRecorder()
+	NewHandler(newE2EContext(t)).ServeHTTP(w, req)
+	return w
+}
+
+func TestEveryRoute(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	router := NewRouter(ctx)
+	served := make(map[string]bool)
+	for _, c := range e2eCases() {
+		w := serveE2E(t, c)
+		assert.Equal(t, c.code, w.Code, "%s %s: %s", c.method, c.target, w.Body.String())
+		assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"), "they should be equal")
+
+		req, _ := http.NewRequest(c.method, c.target, nil)
+		var match mux.RouteMatch
+		if router.Match(req, &match) && match.MatchErr == nil {
+			template, _ := match.Route.GetPathTemplate()
+			served[c.method+" "+template] = true
+		}
+	}
+	for _, route := range allRoutes() {
+		assert.True(t, served[route.Method+" "+route.Pattern], "%s %s isn't tested", route.Method, route.Pattern)
+	}
+}
+
+func TestUnroutedRequests(t *testing.T) {
+	w := serveE2E(t, e2eCase{method: "GET", target: "/nope"})
+	assert.Equal(t, http.StatusNotFound, w.Code, "they should be equal")
+	assert.Equal(t, `{"status":"404","message":"no route for /nope"}`, w.Body.String(), "they should be equal")
+
+	for _, target := range []string{"/users/abc", "/v3/users", "/users/1/passports/2", "/webhooks/a/b"} {
+		w = serveE2E(t, e2eCase{method: "GET", target: target})
+		assert.Equal(t, http.StatusNotFound, w.Code, "%s should not be found", target)
+	}
+
+	w = serveE2E(t, e2eCase{method: "DELETE", target: "/health"})
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "they should be equal")
+	assert.Equal(t, "GET, OPTIONS", w.Header().Get("Allow"), "they should be equal")
+
+	w = serveE2E(t, e2eCase{method: "PATCH", target: "/v2/users/1"})
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "they should be equal")
+	assert.Equal(t, "GET, PUT, DELETE, OPTIONS", w.Header().Get("Allow"), "they should be equal")
+	assert.Equal(t, `{"status":"405","message":"method PATCH not allowed, use GET, PUT, DELETE, OPTIONS"}`, w.Body.String(), "they should be equal")
+
+	w = serveE2E(t, e2eCase{method: "PUT", target: "/users"})
+	assert.Equal(t, "GET, POST, OPTIONS", w.Header().Get("Allow"), "they should be equal")
+
+	// a trailing slash is redirected away
+	w = serveE2E(t, e2eCase{method: "GET", target: "/users/"})
+	assert.Equal(t, http.StatusMovedPermanently, w.Code, "they should be equal")
+	assert.Equal(t, "/users", w.Header().Get("Location"), "they should be equal")
+}
+
+func TestHandlerOverHTTP(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	srv := httptest.NewServer(NewHandler(ctx))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/users/")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode, "they should be equal")
+	assert.Equal(t, "/users", res.Request.URL.Path, "they should be equal")
+	assert.Contains(t, string(body), `"count":2`)
+}
+
+func TestRunStops(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.Port = "0"
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- Run(ctx, stop) }()
+	close(stop)
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return once stopped")
+	}
+}
@@ -0,0 +1,97 @@
+package svc
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+)
+
+// sseBuffer is the number of events a stream connection may lag behind before it is dropped
+const sseBuffer = 64
+
+// sseKeepAlive is the interval of the comments keeping idle streams open through proxies
+var sseKeepAlive = 15 * time.Second
+
+// StreamEventsHandler streams the changes to users and passports as server-sent events
+func StreamEventsHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /events events streamEvents
+	//
+	// Stream changes to users and passports as server-sent events.
+	//
+	// Filter with ?types=user,passport. Reconnecting clients send the Last-Event-ID header
+	// (or ?lastEventId=) to receive the buffered events they missed.
+	//
+	// Produces:
+	// - text/event-stream
+	//
+	// Responses:
+	//   200:
+	//   500: status
+	flusher, ok := w.(http.Flusher)
+	if !ok || ctx.Events == nil {
+		respond(w, req, ctx, http.StatusInternalServerError, status{Status: "500", Message: "event streaming is not supported"})
+		return
+	}
+	var types []string
+	if t := req.URL.Query().Get("types"); t != "" {
+		types = strings.Split(t, ",")
+	}
+	lastID := req.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = req.URL.Query().Get("lastEventId")
+	}
+	sub, replay, found := ctx.Events.Subscribe(tenant.FromContext(req.Context()), types, lastID, sseBuffer)
+	defer sub.Cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if !found {
+		// the requested event has left the buffer: everything still buffered follows
+		w.Write([]byte(": some events were lost\n\n"))
+	}
+	for _, e := range replay {
+		if err := writeSSE(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				// too slow to keep up, the client reconnects with Last-Event-ID
+				return
+			}
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e entities.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	_, err = w.Write([]byte("id: " + e.ID + "\nevent: " + e.Type + "\ndata: " + string(data) + "\n\n"))
+	return err
+}
@@ -0,0 +1,69 @@
+package svc
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// readSSE reads the next event of a stream, returning its id, type and data
+func readSSE(t *testing.T, r *bufio.Reader) (string, string, string) {
+	var id, typ, data string
+	for {
+		line, err := r.ReadString('\n')
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && id != "":
+			return id, typ, data
+		case strings.HasPrefix(line, "id: "):
+			id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			typ = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			data = line[6:]
+		}
+	}
+}
+
+func TestStreamEventsHandler(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	srv := httptest.NewServer(makeHandler(ctx, StreamEventsHandler))
+	defer srv.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(srv.URL + "?types=passport")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"), "they should be equal")
+
+	ctx.DB.AddUser(t.Context(), entities.User{FirstName: "Apple", LastName: "Jack"})
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 2})
+	id, typ, data := readSSE(t, bufio.NewReader(resp.Body))
+	assert.Equal(t, "2", id, "they should be equal")
+	assert.Equal(t, entities.PassportCreated, typ, "they should be equal")
+	assert.Contains(t, data, `"data":{"id":"123456789",`, "they should be equal")
+
+	// resuming after the passport event replays nothing, resuming before it replays it
+	ctx.DB.DeleteUser(t.Context(), 2, storage.DeleteOptions{Policy: storage.Cascade})
+	req, _ := http.NewRequest("GET", srv.URL+"?types=user", nil)
+	req.Header.Set("Last-Event-ID", id)
+	resumed, err := client.Do(req)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer resumed.Body.Close()
+	_, typ, _ = readSSE(t, bufio.NewReader(resumed.Body))
+	assert.Equal(t, entities.UserDeleted, typ, "they should be equal")
+}
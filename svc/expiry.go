@@ -0,0 +1,35 @@
+package svc
+
+import (
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/palantir/stacktrace"
+)
+
+// withStatus returns the passport with its expiry status computed for now
+func withStatus(ctx Context, p entities.Passport) entities.Passport {
+	p.Status = p.ExpiryStatus(time.Now(), ctx.ExpiryWindow)
+	return p
+}
+
+// withStatuses computes the expiry status of a list of passports
+func withStatuses(ctx Context, list []entities.Passport) []entities.Passport {
+	out := make([]entities.Passport, len(list))
+	for i, p := range list {
+		out[i] = withStatus(ctx, p)
+	}
+	return out
+}
+
+// parseDate accepts RFC 3339 times as well as plain dates, which stand for midnight UTC
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, stacktrace.NewError("%q is not a date", s)
+	}
+	return t, nil
+}
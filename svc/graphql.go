@@ -0,0 +1,756 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
+)
+
+// graphQLSchema is the GraphQL schema served at /graphql, over entities.User and entities.Passport
+const graphQLSchema = `"RFC 3339 date and time"
+scalar DateTime
+
+type Query {
+  users: [User!]!
+  user(id: Int!): User
+  passports(expiringBefore: DateTime): [Passport!]!
+  passport(id: String!): Passport
+}
+
+type Mutation {
+  createUser(input: UserInput!): User
+  updateUser(id: Int!, input: UserInput!): User
+  deleteUser(id: Int!): Boolean
+  createPassport(userId: Int!, input: PassportInput!): Passport
+  updatePassport(id: String!, input: PassportInput!): Passport
+  deletePassport(id: String!): Boolean
+}
+
+type User {
+  id: Int!
+  firstName: String!
+  lastName: String!
+  dateOfBirth: DateTime
+  locationOfBirth: String!
+  passports: [Passport!]!
+}
+
+type Passport {
+  id: String!
+  dateOfIssue: DateTime
+  dateOfExpiry: DateTime
+  authority: String!
+  userId: Int!
+  status: String!
+  user: User
+}
+
+input UserInput {
+  firstName: String!
+  lastName: String!
+  dateOfBirth: DateTime
+  locationOfBirth: String
+}
+
+input PassportInput {
+  id: String
+  authority: String!
+  dateOfIssue: DateTime
+  dateOfExpiry: DateTime
+}
+`
+
+// gqlSchema is graphQLSchema loaded, which requests are parsed and validated against
+var gqlSchema = gqlparser.MustLoadSchema(&ast.Source{Name: "schema.graphql", Input: graphQLSchema})
+
+// Limits of the operations executed, so that a small document can't make the service do
+// unbounded work: how deeply fields may nest, and how many fields an operation may select once
+// its fragments are expanded
+const (
+	gqlMaxDepth      = 10
+	gqlMaxComplexity = 1000
+)
+
+// gqlRequest is the body of a GraphQL POST request
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	// Extensions of the protocol, e.g. persisted queries, which are accepted but not supported
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+// gqlError is an entry of the errors list of a GraphQL response
+type gqlError struct {
+	Message   string              `json:"message"`
+	Locations []gqlerror.Location `json:"locations,omitempty"`
+	Path      []interface{}       `json:"path,omitempty"`
+}
+
+// gqlResponse is the body of a GraphQL response
+type gqlResponse struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// gqlErrors converts the errors of the parser and validator to response errors
+func gqlErrors(list gqlerror.List) []gqlError {
+	errs := make([]gqlError, len(list))
+	for i, err := range list {
+		errs[i] = gqlError{Message: err.Message, Locations: err.Locations}
+		for _, p := range err.Path {
+			errs[i].Path = append(errs[i].Path, p)
+		}
+	}
+	return errs
+}
+
+// GraphQLHandler executes GraphQL queries and mutations against the storage
+func GraphQLHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route POST /graphql graphql graphql
+	//
+	// Executes a GraphQL request.
+	//
+	// Accepts {"query", "operationName", "variables"} as JSON. Queries may also be sent with
+	// GET /graphql?query=..., and GET /graphql without a query returns the schema.
+	//
+	//     Responses:
+	//       200: description: the GraphQL response
+	//       400: description: the GraphQL response
+
+	var r gqlRequest
+	if req.Method == http.MethodGet {
+		q := req.URL.Query()
+		if q.Get("query") == "" {
+			ctx.Render.Text(w, http.StatusOK, graphQLSchema)
+			return
+		}
+		r.Query = q.Get("query")
+		r.OperationName = q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &r.Variables); err != nil {
+				ctx.Render.JSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: "malformed variables"}}})
+				return
+			}
+		}
+	} else if err := decodeJSON(req, &r); err != nil {
+		log.Println(err)
+		code, message := http.StatusBadRequest, "malformed GraphQL request"
+		if e, ok := err.(*bodyError); ok {
+			code, message = e.code, e.message
+		}
+		ctx.Render.JSON(w, code, gqlResponse{Errors: []gqlError{{Message: message}}})
+		return
+	}
+	doc, errs := gqlparser.LoadQuery(gqlSchema, r.Query)
+	if len(errs) > 0 {
+		ctx.Render.JSON(w, http.StatusBadRequest, gqlResponse{Errors: gqlErrors(errs)})
+		return
+	}
+	op, err := operation(doc, r.OperationName)
+	if err != nil {
+		ctx.Render.JSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: errorMessage(err)}}})
+		return
+	}
+	if op.Operation == ast.Mutation && req.Method == http.MethodGet {
+		ctx.Render.JSON(w, http.StatusMethodNotAllowed, gqlResponse{Errors: []gqlError{{Message: "mutations require POST"}}})
+		return
+	}
+	if err := checkLimits(doc, op); err != nil {
+		ctx.Render.JSON(w, http.StatusBadRequest, gqlResponse{Errors: []gqlError{{Message: errorMessage(err)}}})
+		return
+	}
+	variables, err := validator.VariableValues(gqlSchema, op, r.Variables)
+	if err != nil {
+		var gqlErr *gqlerror.Error
+		if !errors.As(err, &gqlErr) {
+			gqlErr = gqlerror.Wrap(err)
+		}
+		ctx.Render.JSON(w, http.StatusBadRequest, gqlResponse{Errors: gqlErrors(gqlerror.List{gqlErr})})
+		return
+	}
+	e := &gqlExecutor{ctx: ctx, reqCtx: req.Context(), db: ctx.DB, doc: doc, variables: variables}
+	data := e.execute(op)
+	ctx.Render.JSON(w, http.StatusOK, gqlResponse{Data: data, Errors: e.errors})
+}
+
+// operation picks the operation to execute from the document
+func operation(doc *ast.QueryDocument, name string) (*ast.OperationDefinition, error) {
+	if op := doc.Operations.ForName(name); op != nil {
+		return op, nil
+	}
+	if name == "" {
+		return nil, stacktrace.NewError("operationName is required for documents with several operations")
+	}
+	return nil, stacktrace.NewError("unknown operation %s", name)
+}
+
+// gqlTypeName maps an operation to its root type
+func gqlTypeName(op ast.Operation) string {
+	if op == ast.Mutation {
+		return "Mutation"
+	}
+	return "Query"
+}
+
+// gqlCost is how deeply the fields of a selection set nest and how many there are, fragments
+// expanded
+type gqlCost struct {
+	depth  int
+	fields int
+}
+
+// checkLimits rejects operations nesting deeper than gqlMaxDepth or selecting more than
+// gqlMaxComplexity fields
+func checkLimits(doc *ast.QueryDocument, op *ast.OperationDefinition) error {
+	cost := measure(doc, op.SelectionSet, make(map[string]gqlCost))
+	if cost.depth > gqlMaxDepth {
+		return stacktrace.NewError("the operation nests deeper than %d levels", gqlMaxDepth)
+	}
+	if cost.fields > gqlMaxComplexity {
+		return stacktrace.NewError("the operation selects more than %d fields", gqlMaxComplexity)
+	}
+	return nil
+}
+
+// measure returns the cost of a selection set. Fragments are measured once and their cost reused
+// wherever they are spread, so that measuring a document doesn't expand it; the validator has
+// already refused fragments spreading themselves. Counts stop just above gqlMaxComplexity.
+func measure(doc *ast.QueryDocument, set ast.SelectionSet, fragments map[string]gqlCost) gqlCost {
+	var cost gqlCost
+	spread := make(map[string]bool)
+	for _, sel := range set {
+		var inner gqlCost
+		switch sel := sel.(type) {
+		case *ast.Field:
+			inner = measure(doc, sel.SelectionSet, fragments)
+			inner.depth++
+			inner.fields++
+		case *ast.InlineFragment:
+			inner = measure(doc, sel.SelectionSet, fragments)
+		case *ast.FragmentSpread:
+			// a fragment spread twice in a selection set is collected once
+			if spread[sel.Name] {
+				continue
+			}
+			spread[sel.Name] = true
+			c, ok := fragments[sel.Name]
+			if !ok {
+				if def := doc.Fragments.ForName(sel.Name); def != nil {
+					c = measure(doc, def.SelectionSet, fragments)
+				}
+				fragments[sel.Name] = c
+			}
+			inner = c
+		}
+		cost.depth = max(cost.depth, inner.depth)
+		cost.fields = min(cost.fields+inner.fields, gqlMaxComplexity+1)
+	}
+	return cost
+}
+
+// gqlExecutor runs a single operation. Fields are resolved for all parent objects of a
+// selection at once, so that nested fields such as User.passports cost one storage call per
+// level instead of one per parent.
+type gqlExecutor struct {
+	ctx Context
+	// reqCtx is the context of the request, passed on to every storage call
+	reqCtx    context.Context
+	db        Storager
+	doc       *ast.QueryDocument
+	variables map[string]interface{}
+	errors    []gqlError
+	// abandoned is set once the request is done, after which nothing more is resolved
+	abandoned bool
+}
+
+// gqlField is the fields of a selection set sharing a response key, which are resolved as one
+// field selecting what all of them select
+type gqlField struct {
+	key       string
+	field     *ast.Field
+	selection ast.SelectionSet
+}
+
+// collectFields flattens fragments into the list of fields selected on a type, merging the
+// fields of the same response key. As the spec requires, a fragment spread several times in a
+// selection set is only collected once, and fields skipped by @skip or @include are left out.
+func (e *gqlExecutor) collectFields(typ string, set ast.SelectionSet) []*gqlField {
+	var fields []*gqlField
+	byKey := make(map[string]*gqlField)
+	visited := make(map[string]bool)
+	var collect func(set ast.SelectionSet)
+	collect = func(set ast.SelectionSet) {
+		for _, sel := range set {
+			switch sel := sel.(type) {
+			case *ast.Field:
+				if !e.included(sel.Directives) {
+					continue
+				}
+				if f, ok := byKey[sel.Alias]; ok {
+					f.selection = append(f.selection, sel.SelectionSet...)
+					continue
+				}
+				f := &gqlField{key: sel.Alias, field: sel, selection: append(ast.SelectionSet(nil), sel.SelectionSet...)}
+				byKey[f.key] = f
+				fields = append(fields, f)
+			case *ast.InlineFragment:
+				if e.included(sel.Directives) && (sel.TypeCondition == "" || sel.TypeCondition == typ) {
+					collect(sel.SelectionSet)
+				}
+			case *ast.FragmentSpread:
+				if visited[sel.Name] || !e.included(sel.Directives) {
+					continue
+				}
+				visited[sel.Name] = true
+				if def := e.doc.Fragments.ForName(sel.Name); def != nil && def.TypeCondition == typ {
+					collect(def.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(set)
+	return fields
+}
+
+// included applies the @skip and @include directives of a selection
+func (e *gqlExecutor) included(directives ast.DirectiveList) bool {
+	if d := directives.ForName("skip"); d != nil && d.ArgumentMap(e.variables)["if"] == true {
+		return false
+	}
+	if d := directives.ForName("include"); d != nil && d.ArgumentMap(e.variables)["if"] == false {
+		return false
+	}
+	return true
+}
+
+// subPath returns a copy of path extended by key
+func subPath(path []interface{}, key interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(path)+1), path...), key)
+}
+
+// arguments returns the arguments of a field with variables substituted
+func (e *gqlExecutor) arguments(f *gqlField) map[string]interface{} {
+	return f.field.ArgumentMap(e.variables)
+}
+
+func intArgument(args map[string]interface{}, name string) (int, error) {
+	switch n := args[name].(type) {
+	case int64:
+		return int(n), nil
+	case float64:
+		if n == math.Trunc(n) {
+			return int(n), nil
+		}
+	}
+	return 0, stacktrace.NewError("argument %s must be an Int", name)
+}
+
+func stringArgument(args map[string]interface{}, name string) (string, error) {
+	if s, ok := args[name].(string); ok {
+		return s, nil
+	}
+	return "", stacktrace.NewError("argument %s must be a String", name)
+}
+
+// inputArgument decodes an input object argument into v through its JSON representation, so
+// that input fields follow the json tags of the entities
+func inputArgument(args map[string]interface{}, name string, v interface{}) error {
+	input, ok := args[name].(map[string]interface{})
+	if !ok {
+		return stacktrace.NewError("argument %s must be an input object", name)
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return stacktrace.Propagate(err, "malformed argument %s", name)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return stacktrace.Propagate(err, "malformed argument %s", name)
+	}
+	return nil
+}
+
+func (e *gqlExecutor) fail(path []interface{}, err error) {
+	log.Println(err)
+	e.errors = append(e.errors, gqlError{Message: errorMessage(err), Path: path})
+}
+
+// lookupFailed returns the error of a lookup by id that failed, or nil for one that found
+// nothing, which the field answers with null
+func (e *gqlExecutor) lookupFailed(err error) error {
+	if e.reqCtx.Err() != nil || storage.Unavailable(err) {
+		return err
+	}
+	return nil
+}
+
+// cancelled reports whether the request was cancelled or ran out of time, failing the field at
+// path the first time, so that no more work is done for a response nobody waits for
+func (e *gqlExecutor) cancelled(path []interface{}) bool {
+	if e.abandoned {
+		return true
+	}
+	if err := e.reqCtx.Err(); err != nil {
+		e.abandoned = true
+		e.fail(path, stacktrace.Propagate(err, "request abandoned"))
+		return true
+	}
+	return false
+}
+
+// execute runs the root fields of an operation; mutations run one after the other
+func (e *gqlExecutor) execute(op *ast.OperationDefinition) gqlObject {
+	typ := gqlTypeName(op.Operation)
+	var data gqlObject
+	for _, f := range e.collectFields(typ, op.SelectionSet) {
+		path := []interface{}{f.key}
+		if e.cancelled(path) {
+			break
+		}
+		if f.field.Name == "__typename" {
+			data = append(data, gqlEntry{f.key, typ})
+			continue
+		}
+		value, err := e.resolveRoot(f, path)
+		if err != nil {
+			e.fail(path, err)
+			value = nil
+		}
+		data = append(data, gqlEntry{f.key, value})
+	}
+	return data
+}
+
+func (e *gqlExecutor) resolveRoot(f *gqlField, path []interface{}) (interface{}, error) {
+	args := e.arguments(f)
+	switch f.field.Name {
+	case "users":
+		list, err := e.db.ListUsers(e.reqCtx)
+		if err != nil {
+			return nil, err
+		}
+		return e.resolveUsers(list, f.selection, path), nil
+	case "user":
+		id, err := intArgument(args, "id")
+		if err != nil {
+			return nil, err
+		}
+		u, err := e.db.GetUser(e.reqCtx, id)
+		if err != nil {
+			return nil, e.lookupFailed(err)
+		}
+		return e.resolveUsers([]entities.User{u}, f.selection, path)[0], nil
+	case "passports":
+		var list []entities.Passport
+		var err error
+		if before, ok := args["expiringBefore"].(string); ok {
+			t, perr := parseDate(before)
+			if perr != nil {
+				return nil, stacktrace.Propagate(perr, "argument expiringBefore must be a DateTime")
+			}
+			list, err = e.db.ListExpiringPassports(e.reqCtx, t)
+		} else {
+			list, err = e.db.ListPassports(e.reqCtx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return e.resolvePassports(list, f.selection, path), nil
+	case "passport":
+		id, err := stringArgument(args, "id")
+		if err != nil {
+			return nil, err
+		}
+		p, err := e.db.GetPassport(e.reqCtx, id)
+		if err != nil {
+			return nil, e.lookupFailed(err)
+		}
+		return e.resolvePassports([]entities.Passport{p}, f.selection, path)[0], nil
+	case "createUser":
+		var u entities.User
+		if err := inputArgument(args, "input", &u); err != nil {
+			return nil, err
+		}
+		if err := validateUser(u); err != nil {
+			return nil, err
+		}
+		u.ID = -1
+		u, err := e.db.AddUser(e.reqCtx, u)
+		if err != nil {
+			return nil, err
+		}
+		return e.resolveUsers([]entities.User{u}, f.selection, path)[0], nil
+	case "updateUser":
+		id, err := intArgument(args, "id")
+		if err != nil {
+			return nil, err
+		}
+		var u entities.User
+		if err := inputArgument(args, "input", &u); err != nil {
+			return nil, err
+		}
+		if err := validateUser(u); err != nil {
+			return nil, err
+		}
+		u.ID = id
+		if u, err = e.db.UpdateUser(e.reqCtx, u); err != nil {
+			return nil, stacktrace.Propagate(err, "can't find user")
+		}
+		return e.resolveUsers([]entities.User{u}, f.selection, path)[0], nil
+	case "deleteUser":
+		id, err := intArgument(args, "id")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := e.db.DeleteUser(e.reqCtx, id, storage.DeleteOptions{Policy: e.ctx.DeletePolicy}); err != nil {
+			return nil, err
+		}
+		return true, nil
+	case "createPassport":
+		uid, err := intArgument(args, "userId")
+		if err != nil {
+			return nil, err
+		}
+		var p entities.Passport
+		if err := inputArgument(args, "input", &p); err != nil {
+			return nil, err
+		}
+		p.UserID = uid
+		if err := validatePassport(p); err != nil {
+			return nil, err
+		}
+		if p, err = e.db.AddPassport(e.reqCtx, p); err != nil {
+			return nil, stacktrace.Propagate(err, "can't add passport")
+		}
+		return e.resolvePassports([]entities.Passport{p}, f.selection, path)[0], nil
+	case "updatePassport":
+		id, err := stringArgument(args, "id")
+		if err != nil {
+			return nil, err
+		}
+		existing, err := e.db.GetPassport(e.reqCtx, id)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "can't find passport")
+		}
+		var p entities.Passport
+		if err := inputArgument(args, "input", &p); err != nil {
+			return nil, err
+		}
+		p.ID, p.UserID = id, existing.UserID
+		if err := validatePassport(p); err != nil {
+			return nil, err
+		}
+		if p, err = e.db.UpdatePassport(e.reqCtx, p); err != nil {
+			return nil, err
+		}
+		return e.resolvePassports([]entities.Passport{p}, f.selection, path)[0], nil
+	case "deletePassport":
+		id, err := stringArgument(args, "id")
+		if err != nil {
+			return nil, err
+		}
+		if err := e.db.DeletePassport(e.reqCtx, id); err != nil {
+			return nil, stacktrace.Propagate(err, "can't find passport")
+		}
+		return true, nil
+	}
+	return nil, stacktrace.NewError("field %s isn't supported, GET /graphql returns the schema", f.field.Name)
+}
+
+// resolveUsers resolves a selection on many users at once
+func (e *gqlExecutor) resolveUsers(list []entities.User, set ast.SelectionSet, path []interface{}) []gqlObject {
+	objects := make([]gqlObject, len(list))
+	for _, f := range e.collectFields("User", set) {
+		key := f.key
+		if e.cancelled(subPath(path, key)) {
+			break
+		}
+		switch f.field.Name {
+		case "passports":
+			byUser, err := e.loadPassports(list)
+			if err != nil {
+				e.fail(subPath(path, key), err)
+			}
+			// resolve the passports of all users together, then hand them back to their owners
+			var all []entities.Passport
+			for _, u := range list {
+				all = append(all, byUser[u.ID]...)
+			}
+			resolved := e.resolvePassports(all, f.selection, subPath(path, key))
+			for i, u := range list {
+				n := len(byUser[u.ID])
+				owned := make([]gqlObject, n)
+				copy(owned, resolved[:n])
+				objects[i] = append(objects[i], gqlEntry{key, owned})
+				resolved = resolved[n:]
+			}
+		default:
+			for i, u := range list {
+				objects[i] = append(objects[i], gqlEntry{key, userField(u, f.field.Name)})
+			}
+		}
+	}
+	return objects
+}
+
+func userField(u entities.User, name string) interface{} {
+	switch name {
+	case "id":
+		return u.ID
+	case "firstName":
+		return u.FirstName
+	case "lastName":
+		return u.LastName
+	case "dateOfBirth":
+		return u.DateOfBirth
+	case "locationOfBirth":
+		return u.LocationOfBirth
+	case "__typename":
+		return "User"
+	}
+	return nil
+}
+
+// loadPassports fetches the passports of several users with a single storage call
+func (e *gqlExecutor) loadPassports(list []entities.User) (map[int][]entities.Passport, error) {
+	byUser := make(map[int][]entities.Passport)
+	if len(list) == 0 {
+		return byUser, nil
+	}
+	ids := make([]int, len(list))
+	for i, u := range list {
+		ids[i] = u.ID
+	}
+	passports, err := e.db.ListPassportsOfUsers(e.reqCtx, ids)
+	for _, p := range passports {
+		byUser[p.UserID] = append(byUser[p.UserID], p)
+	}
+	return byUser, err
+}
+
+// resolvePassports resolves a selection on many passports at once
+func (e *gqlExecutor) resolvePassports(list []entities.Passport, set ast.SelectionSet, path []interface{}) []gqlObject {
+	objects := make([]gqlObject, len(list))
+	for _, f := range e.collectFields("Passport", set) {
+		key := f.key
+		if e.cancelled(subPath(path, key)) {
+			break
+		}
+		switch f.field.Name {
+		case "user":
+			holders, err := e.loadUsers(list)
+			if err != nil {
+				e.fail(subPath(path, key), err)
+			}
+			var found []entities.User
+			for _, p := range list {
+				if u, ok := holders[p.UserID]; ok {
+					found = append(found, u)
+				}
+			}
+			resolved := e.resolveUsers(found, f.selection, subPath(path, key))
+			for i, p := range list {
+				if _, ok := holders[p.UserID]; !ok {
+					objects[i] = append(objects[i], gqlEntry{key, nil})
+					continue
+				}
+				objects[i] = append(objects[i], gqlEntry{key, resolved[0]})
+				resolved = resolved[1:]
+			}
+		case "status":
+			for i, p := range list {
+				objects[i] = append(objects[i], gqlEntry{key, withStatus(e.ctx, p).Status})
+			}
+		default:
+			for i, p := range list {
+				objects[i] = append(objects[i], gqlEntry{key, passportField(p, f.field.Name)})
+			}
+		}
+	}
+	return objects
+}
+
+func passportField(p entities.Passport, name string) interface{} {
+	switch name {
+	case "id":
+		return p.ID
+	case "dateOfIssue":
+		return p.DateOfIssue
+	case "dateOfExpiry":
+		return p.DateOfExpiry
+	case "authority":
+		return p.Authority
+	case "userId":
+		return p.UserID
+	case "__typename":
+		return "Passport"
+	}
+	return nil
+}
+
+// loadUsers fetches the holders of several passports with a single storage call
+func (e *gqlExecutor) loadUsers(list []entities.Passport) (map[int]entities.User, error) {
+	holders := make(map[int]entities.User)
+	seen := make(map[int]bool)
+	var ids []int
+	for _, p := range list {
+		if !seen[p.UserID] {
+			seen[p.UserID] = true
+			ids = append(ids, p.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return holders, nil
+	}
+	users, err := e.db.GetUsers(e.reqCtx, ids)
+	for _, u := range users {
+		holders[u.ID] = u
+	}
+	return holders, err
+}
+
+// gqlObject is a response object which keeps its fields in selection order
+type gqlObject []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value interface{}
+}
+
+// MarshalJSON encodes the object with its fields in order
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	if o == nil {
+		return []byte("null"), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, entry := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(entry.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,222 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingDB counts the storage calls made through it
+type countingDB struct {
+	Storager
+	calls map[string]int
+}
+
+func (db *countingDB) ListUsers(ctx context.Context) ([]entities.User, error) {
+	db.calls["ListUsers"]++
+	return db.Storager.ListUsers(ctx)
+}
+
+func (db *countingDB) GetUser(ctx context.Context, i int) (entities.User, error) {
+	db.calls["GetUser"]++
+	return db.Storager.GetUser(ctx, i)
+}
+
+func (db *countingDB) GetUsers(ctx context.Context, ids []int) ([]entities.User, error) {
+	db.calls["GetUsers"]++
+	return db.Storager.GetUsers(ctx, ids)
+}
+
+func (db *countingDB) ListPassports(ctx context.Context) ([]entities.Passport, error) {
+	db.calls["ListPassports"]++
+	return db.Storager.ListPassports(ctx)
+}
+
+func (db *countingDB) ListUserPassports(ctx context.Context, uid int) ([]entities.Passport, error) {
+	db.calls["ListUserPassports"]++
+	return db.Storager.ListUserPassports(ctx, uid)
+}
+
+func (db *countingDB) ListPassportsOfUsers(ctx context.Context, uids []int) ([]entities.Passport, error) {
+	db.calls["ListPassportsOfUsers"]++
+	return db.Storager.ListPassportsOfUsers(ctx, uids)
+}
+
+func graphQL(ctx Context, query string, variables map[string]interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(gqlRequest{Query: query, Variables: variables})
+	req, _ := http.NewRequest("POST", "/graphql", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	makeHandler(ctx, GraphQLHandler).ServeHTTP(w, req)
+	return w
+}
+
+func TestGraphQLNestedPassportsAreBatched(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 0})
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "222222222", Authority: "HMPO", UserID: 1})
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "333333333", Authority: "IPS", UserID: 1})
+	db := &countingDB{Storager: ctx.DB, calls: make(map[string]int)}
+	ctx.DB = db
+	w := graphQL(ctx, `{ users { firstName passports { id holder: user { lastName } } } }`, nil)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, `{"data":{"users":[`+
+		`{"firstName":"John","passports":[{"id":"111111111","holder":{"lastName":"Doe"}}]},`+
+		`{"firstName":"Jane","passports":[{"id":"222222222","holder":{"lastName":"Doe"}},{"id":"333333333","holder":{"lastName":"Doe"}}]}`+
+		`]}}`, strings.TrimSpace(w.Body.String()), "they should be equal")
+	assert.Equal(t, map[string]int{"ListUsers": 1, "ListPassportsOfUsers": 1, "GetUsers": 1}, db.calls, "one storage call per level")
+}
+
+func TestGraphQLUserWithVariablesAndFragments(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	query := `
+		query GetUser($id: Int!) {
+			user(id: $id) { ...names __typename passports { id } }
+			missing: user(id: 10) { id }
+		}
+		fragment names on User { firstName, lastName }`
+	w := graphQL(ctx, query, map[string]interface{}{"id": 1})
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, `{"data":{"user":{"firstName":"Jane","lastName":"Doe","__typename":"User","passports":[]},"missing":null}}`,
+		strings.TrimSpace(w.Body.String()), "they should be equal")
+}
+
+func TestGraphQLLookupFailures(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB = brokenDB{ctx.DB}
+
+	// a user that can't be looked up is a field error rather than null
+	w := graphQL(ctx, `{ user(id: 1) { id } passport(id: "123456789") { id } }`, nil)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var res gqlResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if assert.Equal(t, 1, len(res.Errors)) {
+		assert.Equal(t, "connection refused", res.Errors[0].Message, "they should be equal")
+		assert.Equal(t, []interface{}{"user"}, res.Errors[0].Path, "they should be equal")
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	w := graphQL(ctx, `mutation {
+		createUser(input: {firstName: "Apple", lastName: "Jack", dateOfBirth: "1972-03-07T00:00:00Z"}) { id dateOfBirth }
+		createPassport(userId: 2, input: {id: "123456789", authority: "HMPO"}) { id userId }
+	}`, nil)
+	assert.Equal(t, `{"data":{"createUser":{"id":2,"dateOfBirth":"1972-03-07T00:00:00Z"},"createPassport":{"id":"123456789","userId":2}}}`,
+		strings.TrimSpace(w.Body.String()), "they should be equal")
+
+	w = graphQL(ctx, `mutation { createUser(input: {firstName: "Apple", lastName: ""}) { id } }`, nil)
+	var res map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, map[string]interface{}{"createUser": nil}, res["data"], "they should be equal")
+	assert.Equal(t, "lastName is required", res["errors"].([]interface{})[0].(map[string]interface{})["message"], "they should be equal")
+}
+
+func TestGraphQLValidation(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	w := graphQL(ctx, `{ users { id nickname } user { id } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	var res gqlResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if assert.Equal(t, 2, len(res.Errors)) {
+		assert.Equal(t, `Cannot query field "nickname" on type "User". Did you mean "firstName" or "lastName"?`, res.Errors[0].Message, "they should be equal")
+		assert.Equal(t, `Field "user" argument "id" of type "Int!" is required, but it was not provided.`, res.Errors[1].Message, "they should be equal")
+	}
+	w = graphQL(ctx, `{ users { id `, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+}
+
+func TestGraphQLMergesFieldsOfAnAlias(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	w := graphQL(ctx, `{ a: user(id: 1) { id } a: user(id: 1) { firstName id } }`, nil)
+	assert.Equal(t, `{"data":{"a":{"id":1,"firstName":"Jane"}}}`, strings.TrimSpace(w.Body.String()), "they should be equal")
+
+	// fields of one alias that can't be merged are refused
+	w = graphQL(ctx, `{ a: user(id: 1) { id } a: users { id } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+
+	// a fragment spread twice in a selection set is expanded once
+	var doubled strings.Builder
+	doubled.WriteString("{ users { ...F0 } }\n")
+	for i := 0; i < 25; i++ {
+		fmt.Fprintf(&doubled, "fragment F%d on User { id ...F%d ...F%d }\n", i, i+1, i+1)
+	}
+	doubled.WriteString("fragment F25 on User { firstName }\n")
+	w := graphQL(ctx, doubled.String(), nil)
+	assert.Equal(t, `{"data":{"users":[{"id":0,"firstName":"John"},{"id":1,"firstName":"Jane"}]}}`,
+		strings.TrimSpace(w.Body.String()), "they should be equal")
+
+	// fragments doubling the fields selected on every level are refused without being expanded
+	var nested strings.Builder
+	nested.WriteString("{ passports { ...F0 } }\n")
+	for i := 0; i < 25; i++ {
+		fmt.Fprintf(&nested, "fragment F%d on Passport { id user { passports { ...F%d } } again: user { passports { ...F%d } } }\n", i, i+1, i+1)
+	}
+	nested.WriteString("fragment F25 on Passport { id }\n")
+	w = graphQL(ctx, nested.String(), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "nests deeper than 10 levels")
+
+	// as are operations selecting too many fields, here ten aliases of ten aliases of ten
+	wide := func(typ, field, inner string) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "on %s {", typ)
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(&b, " a%d: %s { %s }", i, field, inner)
+		}
+		return b.String() + " }"
+	}
+	w = graphQL(ctx, "{ users { ...F0 } }\n"+
+		"fragment F0 "+wide("User", "passports", "...F1")+"\n"+
+		"fragment F1 "+wide("Passport", "user", "...F2")+"\n"+
+		"fragment F2 "+wide("User", "passports", "id")+"\n", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "selects more than 1000 fields")
+}
+
+func TestGraphQLStopsOnceCancelled(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	db := &countingDB{Storager: ctx.DB, calls: make(map[string]int)}
+	ctx.DB = db
+	reqCtx, cancel := context.WithCancel(t.Context())
+	cancel()
+	body, _ := json.Marshal(gqlRequest{Query: `{ users { id } passports { id } }`})
+	req, _ := http.NewRequestWithContext(reqCtx, "POST", "/graphql", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	GraphQLHandler(w, req, ctx)
+	var res gqlResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if assert.Equal(t, 1, len(res.Errors)) {
+		assert.Equal(t, "request abandoned: context canceled", res.Errors[0].Message, "they should be equal")
+	}
+	assert.Equal(t, map[string]int{}, db.calls, "nothing is resolved")
+}
+
+func TestGraphQLSchema(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	req, _ := http.NewRequest("GET", "/graphql", nil)
+	w := httptest.NewRecorder()
+	makeHandler(ctx, GraphQLHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "type User {\n  id: Int!\n", "they should be equal")
+	assert.Contains(t, w.Body.String(), "  user(id: Int!): User\n", "they should be equal")
+}
@@ -0,0 +1,397 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/proto/userspb"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// grpcServer implements the UserService of proto/users.proto. Its methods are given the context
+// scoped to the tenant of the call by the interceptors of newGRPCServer, see grpcContext.
+type grpcServer struct {
+	userspb.UnimplementedUserServiceServer
+	ctx Context
+}
+
+// grpcContextKey carries the context scoped to the tenant of a call
+type grpcContextKey struct{}
+
+// grpcContext returns the context of the service scoped to the tenant of a call
+func grpcContext(reqCtx context.Context) Context {
+	return reqCtx.Value(grpcContextKey{}).(Context)
+}
+
+// RunGRPC serves the gRPC API on ctx.GRPCPort, see ServeGRPC
+func RunGRPC(ctx Context, stop <-chan struct{}) error {
+	addr := ":" + ctx.GRPCPort
+	if ctx.Env == Local {
+		addr = "localhost" + addr
+	}
+	srv, err := newGRPCServer(ctx)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't listen for gRPC")
+	}
+	log.Println("===> Starting gRPC service (v" + ctx.Version + ") on port " + ctx.GRPCPort + " in " + ctx.Env + " mode.")
+	return serveUntil(stop, []shutdowner{grpcShutdown{srv}}, []func() error{func() error { return srv.Serve(lis) }})
+}
+
+// ServeGRPC serves the gRPC API on an existing listener: over TLS, with the certificates of the
+// HTTPS server, if the context has a certificate, and unencrypted HTTP/2 otherwise, as gRPC
+// clients expect when dialing without transport credentials
+func ServeGRPC(ctx Context, lis net.Listener) error {
+	srv, err := newGRPCServer(ctx)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(lis)
+}
+
+func newGRPCServer(ctx Context) (*grpc.Server, error) {
+	s := &grpcServer{ctx: ctx}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unary),
+		grpc.ChainStreamInterceptor(s.stream),
+	}
+	if ctx.TLS.enabled() {
+		config, err := ctx.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	srv := grpc.NewServer(opts...)
+	userspb.RegisterUserServiceServer(srv, s)
+	return srv, nil
+}
+
+// grpcShutdown shuts a gRPC server down as http.Server.Shutdown does: it stops taking calls and
+// lets those in flight finish until ctx is done, then closes their connections
+type grpcShutdown struct {
+	*grpc.Server
+}
+
+func (s grpcShutdown) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (s *grpcServer) unary(reqCtx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	reqCtx, cancel, err := s.begin(reqCtx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	resp, err := handler(reqCtx, req)
+	return resp, grpcStatus(reqCtx, err)
+}
+
+func (s *grpcServer) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	reqCtx, cancel, err := s.begin(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	return grpcStatus(reqCtx, handler(srv, scopedStream{ss, reqCtx}))
+}
+
+// scopedStream is a server stream whose context is scoped to the tenant of the call
+type scopedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s scopedStream) Context() context.Context {
+	return s.ctx
+}
+
+// begin scopes a call to its tenant, as makeHandler does requests, and bounds it by the timeout
+// of its method on top of the deadline of the client
+func (s *grpcServer) begin(reqCtx context.Context, method string) (context.Context, context.CancelFunc, error) {
+	ctx, req, err := scope(s.ctx, grpcRequest(reqCtx, method))
+	if err != nil {
+		code := codes.InvalidArgument
+		switch tenantStatus(err) {
+		case http.StatusUnauthorized:
+			code = codes.Unauthenticated
+		case http.StatusForbidden:
+			code = codes.PermissionDenied
+		}
+		return nil, nil, grpcstatus.Error(code, errorMessage(err))
+	}
+	reqCtx = context.WithValue(req.Context(), grpcContextKey{}, ctx)
+	name := method[strings.LastIndex(method, "/")+1:]
+	if timeout := timeoutFor(ctx, name); timeout > 0 {
+		reqCtx, cancel := context.WithTimeout(reqCtx, timeout)
+		return reqCtx, cancel, nil
+	}
+	reqCtx, cancel := context.WithCancel(reqCtx)
+	return reqCtx, cancel, nil
+}
+
+// grpcRequest presents the metadata and the peer of a call as the HTTP/2 request carrying it, as
+// the tenant resolver expects
+func grpcRequest(reqCtx context.Context, method string) *http.Request {
+	req, _ := http.NewRequestWithContext(reqCtx, "POST", method, nil)
+	md, _ := metadata.FromIncomingContext(reqCtx)
+	for k, v := range md {
+		if !strings.HasPrefix(k, ":") {
+			req.Header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	if authority := md.Get(":authority"); len(authority) > 0 {
+		req.Host = authority[0]
+	}
+	if p, ok := peer.FromContext(reqCtx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &info.State
+		}
+	}
+	return req
+}
+
+// grpcStatus returns the status a call ends with: its own, DEADLINE_EXCEEDED or CANCELLED if it
+// ran out of time, UNAVAILABLE if the storage is, and INTERNAL for anything else, logged
+func grpcStatus(reqCtx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch reqCtx.Err() {
+	case context.DeadlineExceeded:
+		return grpcstatus.Error(codes.DeadlineExceeded, "deadline exceeded")
+	case context.Canceled:
+		return grpcstatus.Error(codes.Canceled, "request cancelled")
+	}
+	if storage.Unavailable(err) {
+		return grpcRuleError(err)
+	}
+	if _, ok := grpcstatus.FromError(err); ok {
+		return err
+	}
+	log.Println(err)
+	return grpcstatus.Error(codes.Internal, "something went wrong")
+}
+
+func (s *grpcServer) Health(reqCtx context.Context, in *emptypb.Empty) (*userspb.HealthResponse, error) {
+	ctx := grpcContext(reqCtx)
+	return &userspb.HealthResponse{SvcName: "go-rest-api-template", Version: ctx.Version}, nil
+}
+
+func (s *grpcServer) ListUsers(reqCtx context.Context, in *emptypb.Empty) (*userspb.ListUsersResponse, error) {
+	list, err := grpcContext(reqCtx).DB.ListUsers(reqCtx)
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "can't list users")
+	}
+	return userListProto(list), nil
+}
+
+func (s *grpcServer) GetUser(reqCtx context.Context, in *userspb.UserRequest) (*userspb.User, error) {
+	user, err := grpcContext(reqCtx).DB.GetUser(reqCtx, int(in.GetUid()))
+	if err != nil {
+		return nil, grpcLookupError(err, "can't find user")
+	}
+	return userProto(user), nil
+}
+
+func (s *grpcServer) CreateUser(reqCtx context.Context, in *userspb.User) (*userspb.User, error) {
+	u, err := userFromProto(in)
+	if err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, "malformed user object")
+	}
+	if err := validateUser(u); err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, errorMessage(err))
+	}
+	u.ID = -1
+	u, err = grpcContext(reqCtx).DB.AddUser(reqCtx, u)
+	if err != nil {
+		return nil, grpcRuleError(err)
+	}
+	return userProto(u), nil
+}
+
+func (s *grpcServer) UpdateUser(reqCtx context.Context, in *userspb.User) (*userspb.User, error) {
+	u, err := userFromProto(in)
+	if err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, "malformed user object")
+	}
+	if err := validateUser(u); err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, errorMessage(err))
+	}
+	u, err = grpcContext(reqCtx).DB.UpdateUser(reqCtx, u)
+	if err != nil {
+		return nil, grpcRuleError(err)
+	}
+	return userProto(u), nil
+}
+
+func (s *grpcServer) DeleteUser(reqCtx context.Context, in *userspb.DeleteUserRequest) (*userspb.Deletion, error) {
+	ctx := grpcContext(reqCtx)
+	opts := storage.DeleteOptions{Policy: ctx.DeletePolicy, DryRun: in.GetDryRun()}
+	deletion, err := ctx.DB.DeleteUser(reqCtx, int(in.GetUid()), opts)
+	if err != nil {
+		return nil, grpcRuleError(err)
+	}
+	return deletionProto(deletion), nil
+}
+
+func (s *grpcServer) BatchUsers(reqCtx context.Context, in *userspb.BatchUsersRequest) (*userspb.BatchUsersResponse, error) {
+	items := make([]batchItem, len(in.GetRecords()))
+	for i, r := range in.GetRecords() {
+		items[i].record, items[i].err = userRecordFromProto(r)
+	}
+	res, ok := importUserRecords(reqCtx, grpcContext(reqCtx).DB, items, in.GetAtomic())
+	if !ok {
+		return nil, grpcstatus.Error(codes.FailedPrecondition, fmt.Sprintf("atomic batch rejected, %d items failed", res.Failed))
+	}
+	return batchResultsProto(res), nil
+}
+
+func (s *grpcServer) Export(in *emptypb.Empty, stream userspb.UserService_ExportServer) error {
+	reqCtx := stream.Context()
+	ctx := grpcContext(reqCtx)
+	list, err := ctx.DB.ListUsers(reqCtx)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't list users")
+	}
+	passports, err := ctx.DB.ListPassports(reqCtx)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't list passports")
+	}
+	for _, r := range exportRecords(list, passports) {
+		if err := stream.Send(userRecordProto(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *grpcServer) ListPassports(reqCtx context.Context, in *userspb.ListPassportsRequest) (*userspb.ListPassportsResponse, error) {
+	ctx := grpcContext(reqCtx)
+	var list []entities.Passport
+	var err error
+	if in.GetExpiringBefore() != nil {
+		before, terr := timeFromProto(in.GetExpiringBefore())
+		if terr != nil {
+			return nil, grpcstatus.Error(codes.InvalidArgument, "expiringBefore "+errorMessage(terr))
+		}
+		list, err = ctx.DB.ListExpiringPassports(reqCtx, before)
+	} else {
+		list, err = ctx.DB.ListPassports(reqCtx)
+	}
+	if err != nil {
+		return nil, grpcRuleError(err)
+	}
+	return passportListProto(withStatuses(ctx, list)), nil
+}
+
+func (s *grpcServer) ListUserPassports(reqCtx context.Context, in *userspb.UserRequest) (*userspb.ListPassportsResponse, error) {
+	ctx := grpcContext(reqCtx)
+	list, err := ctx.DB.ListUserPassports(reqCtx, int(in.GetUid()))
+	if err != nil {
+		return nil, grpcLookupError(err, "can't find user")
+	}
+	return passportListProto(withStatuses(ctx, list)), nil
+}
+
+func (s *grpcServer) GetPassport(reqCtx context.Context, in *userspb.PassportRequest) (*userspb.Passport, error) {
+	ctx := grpcContext(reqCtx)
+	p, err := ctx.DB.GetPassport(reqCtx, in.GetPid())
+	if err != nil {
+		return nil, grpcLookupError(err, "can't find passport")
+	}
+	return passportProto(withStatus(ctx, p)), nil
+}
+
+func (s *grpcServer) CreateUserPassport(reqCtx context.Context, in *userspb.Passport) (*userspb.Passport, error) {
+	ctx := grpcContext(reqCtx)
+	p, err := passportFromProto(in)
+	if err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, "malformed passport object")
+	}
+	if err := validatePassport(p); err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, errorMessage(err))
+	}
+	p, err = ctx.DB.AddPassport(reqCtx, p)
+	if err != nil {
+		return nil, grpcRuleError(err)
+	}
+	return passportProto(withStatus(ctx, p)), nil
+}
+
+func (s *grpcServer) UpdatePassport(reqCtx context.Context, in *userspb.Passport) (*userspb.Passport, error) {
+	ctx := grpcContext(reqCtx)
+	p, err := passportFromProto(in)
+	if err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, "malformed passport object")
+	}
+	if err := validatePassport(p); err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, errorMessage(err))
+	}
+	p, err = ctx.DB.UpdatePassport(reqCtx, p)
+	if err != nil {
+		return nil, grpcRuleError(err)
+	}
+	return passportProto(withStatus(ctx, p)), nil
+}
+
+func (s *grpcServer) DeletePassport(reqCtx context.Context, in *userspb.PassportRequest) (*emptypb.Empty, error) {
+	if err := grpcContext(reqCtx).DB.DeletePassport(reqCtx, in.GetPid()); err != nil {
+		return nil, grpcLookupError(err, "can't find passport")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// grpcLookupError maps a failed lookup to NOT_FOUND, unless the storage is unavailable
+func grpcLookupError(err error, message string) error {
+	if storage.Unavailable(err) {
+		return grpcRuleError(err)
+	}
+	return grpcstatus.Error(codes.NotFound, message)
+}
+
+// grpcRuleError maps an error of the domain service to a gRPC status
+func grpcRuleError(err error) error {
+	if storage.Unavailable(err) {
+		log.Println(err)
+		return grpcstatus.Error(codes.Unavailable, "storage is unavailable, try again later")
+	}
+	switch domain.KindOf(err) {
+	case domain.NotFound:
+		return grpcstatus.Error(codes.NotFound, errorMessage(err))
+	case domain.Conflict:
+		return grpcstatus.Error(codes.FailedPrecondition, errorMessage(err))
+	case domain.Invalid:
+		return grpcstatus.Error(codes.InvalidArgument, errorMessage(err))
+	}
+	return err
+}
@@ -0,0 +1,191 @@
+package svc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/proto/userspb"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// newGRPCTestConn serves the gRPC API of ctx on a bufconn listener and returns a connection to
+// it, without transport credentials unless opts give some
+func newGRPCTestConn(t *testing.T, ctx Context, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Cleanup(ctx.Close)
+	lis := bufconn.Listen(1 << 20)
+	go ServeGRPC(ctx, lis)
+	t.Cleanup(func() { lis.Close() })
+	opts = append([]grpc.DialOption{
+		grpc.WithContextDialer(func(dialCtx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(dialCtx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)
+	conn, err := grpc.NewClient("passthrough:///bufconn", opts...)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCHealth(t *testing.T) {
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, NewContext()))
+	out, err := c.Health(t.Context(), &emptypb.Empty{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "go-rest-api-template", out.GetSvcName(), "they should be equal")
+		assert.Equal(t, "0.0.0", out.GetVersion(), "they should be equal")
+	}
+}
+
+func TestGRPCUsers(t *testing.T) {
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, NewContext()))
+	dt, _ := time.Parse(time.RFC3339, "1972-03-07T00:00:00Z")
+	out, err := c.CreateUser(t.Context(), userProto(entities.User{
+		FirstName:   "Apple",
+		LastName:    "Jack",
+		DateOfBirth: dt,
+	}))
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	u, err := userFromProto(out)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, u.ID, "they should be equal")
+	assert.Equal(t, dt, u.DateOfBirth, "they should be equal")
+
+	req := &userspb.UserRequest{Uid: 2}
+	out, err = c.GetUser(t.Context(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "Apple", out.GetFirstName(), "they should be equal")
+
+	list, err := c.ListUsers(t.Context(), &emptypb.Empty{})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), list.GetCount(), "they should be equal")
+	assert.Equal(t, 3, len(list.GetUsers()), "they should be equal")
+
+	deletion, err := c.DeleteUser(t.Context(), &userspb.DeleteUserRequest{Uid: 2, DryRun: true})
+	if assert.Nil(t, err) {
+		assert.Equal(t, true, deletion.GetDryRun(), "they should be equal")
+		assert.Equal(t, "restrict", deletion.GetPolicy(), "they should be equal")
+	}
+	_, err = c.GetUser(t.Context(), req)
+	assert.Nil(t, err, "a dry run deletes nothing")
+	_, err = c.DeleteUser(t.Context(), &userspb.DeleteUserRequest{Uid: 2})
+	assert.Nil(t, err)
+	_, err = c.GetUser(t.Context(), req)
+	assert.Equal(t, codes.NotFound, grpcstatus.Code(err), "they should be equal")
+	assert.Equal(t, "can't find user", grpcstatus.Convert(err).Message(), "they should be equal")
+}
+
+func TestGRPCCreateUserInvalid(t *testing.T) {
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, NewContext()))
+	_, err := c.CreateUser(t.Context(), &userspb.User{FirstName: "Apple"})
+	assert.Equal(t, codes.InvalidArgument, grpcstatus.Code(err), "they should be equal")
+	assert.Equal(t, "lastName is required", grpcstatus.Convert(err).Message(), "they should be equal")
+}
+
+func TestGRPCPassportsAndExport(t *testing.T) {
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, NewContext()))
+	p := entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1}
+	_, err := c.CreateUserPassport(t.Context(), passportProto(p))
+	assert.Nil(t, err)
+	_, err = c.CreateUserPassport(t.Context(), passportProto(p))
+	assert.Equal(t, codes.FailedPrecondition, grpcstatus.Code(err), "they should be equal")
+	_, err = c.DeleteUser(t.Context(), &userspb.DeleteUserRequest{Uid: 1})
+	assert.Equal(t, codes.FailedPrecondition, grpcstatus.Code(err), "conflicts have the same code everywhere")
+
+	stream, err := c.Export(t.Context(), &emptypb.Empty{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	var records []userRecord
+	for {
+		m, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if !assert.Nil(t, err) {
+			break
+		}
+		r, err := userRecordFromProto(m)
+		assert.Nil(t, err)
+		records = append(records, r)
+	}
+	if assert.Equal(t, 2, len(records), "one message per user") {
+		assert.Equal(t, []entities.Passport{p}, records[1].Passports, "they should be equal")
+	}
+}
+
+func TestGRPCListPassports(t *testing.T) {
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, NewContext()))
+	expiry := time.Now().AddDate(0, 1, 0).UTC().Truncate(time.Second)
+	for _, p := range []entities.Passport{
+		{ID: "123456789", Authority: "HMPO", UserID: 1},
+		{ID: "987654321", Authority: "IPS", UserID: 1, DateOfExpiry: expiry},
+	} {
+		_, err := c.CreateUserPassport(t.Context(), passportProto(p))
+		assert.Nil(t, err)
+	}
+	list, err := c.ListPassports(t.Context(), &userspb.ListPassportsRequest{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, int32(2), list.GetCount(), "they should be equal")
+	}
+	list, err = c.ListPassports(t.Context(), &userspb.ListPassportsRequest{ExpiringBefore: timestampProto(expiry.AddDate(0, 0, 1))})
+	if assert.Nil(t, err) && assert.Equal(t, int32(1), list.GetCount(), "they should be equal") {
+		assert.Equal(t, "987654321", list.GetPassports()[0].GetId(), "they should be equal")
+		assert.Equal(t, "expiring", list.GetPassports()[0].GetStatus(), "they should be equal")
+	}
+}
+
+func TestGRPCBatchUsers(t *testing.T) {
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, NewContext()))
+	out, err := c.BatchUsers(t.Context(), &userspb.BatchUsersRequest{Records: []*userspb.UserRecord{
+		{User: &userspb.User{FirstName: "Apple", LastName: "Jack"}},
+		{User: &userspb.User{FirstName: "Big"}},
+	}})
+	if assert.Nil(t, err) {
+		assert.Equal(t, int32(1), out.GetCreated(), "they should be equal")
+		assert.Equal(t, int32(1), out.GetFailed(), "they should be equal")
+		assert.Equal(t, int32(400), out.GetResults()[1].GetStatus(), "they should be equal")
+	}
+
+	_, err = c.BatchUsers(t.Context(), &userspb.BatchUsersRequest{Atomic: true, Records: []*userspb.UserRecord{
+		{User: &userspb.User{FirstName: "Big"}},
+	}})
+	assert.Equal(t, codes.FailedPrecondition, grpcstatus.Code(err), "they should be equal")
+}
+
+func TestGRPCTenants(t *testing.T) {
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, NewContext()))
+	call := func(id string) (*userspb.ListUsersResponse, error) {
+		return c.ListUsers(metadata.AppendToOutgoingContext(t.Context(), tenant.Header, id), &emptypb.Empty{})
+	}
+
+	// the tenant is taken from the metadata of the call, as from the headers of a request
+	list, err := call("acme")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), list.GetCount(), "they should be equal")
+	_, err = call("globex")
+	assert.Equal(t, codes.PermissionDenied, grpcstatus.Code(err), "they should be equal")
+	_, err = call("Not A Tenant")
+	assert.Equal(t, codes.InvalidArgument, grpcstatus.Code(err), "they should be equal")
+}
+
+func TestGRPCUnknownMethod(t *testing.T) {
+	conn := newGRPCTestConn(t, NewContext())
+	err := conn.Invoke(t.Context(), "/gorest.v1.UserService/Frobnicate", &emptypb.Empty{}, &emptypb.Empty{})
+	assert.Equal(t, codes.Unimplemented, grpcstatus.Code(err), "they should be equal")
+}
@@ -1,13 +1,14 @@
 package svc
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/resilience"
+	"github.com/kostiamol/go-rest-api-template/storage"
 )
 
 // health stores information about service' name and version
@@ -34,12 +35,34 @@ type HandlerFunc func(http.ResponseWriter, *http.Request, Context)
 // makeHandler allows us to pass an environment struct to our handlers, without resorting to global
 // variables. It accepts an environment (Env) struct and our own handler function. It returns
 // a function of the type http.HandlerFunc so can be passed on to the HandlerFunc in main.go.
+//
+// The handler function gets the storage of the tenant of the request, whose id the request's
+// context carries.
 func makeHandler(ctx Context, fn func(http.ResponseWriter, *http.Request, Context)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, r, err := scope(ctx, r)
+		if err != nil {
+			code := tenantStatus(err)
+			response := status{
+				Status:  strconv.Itoa(code),
+				Message: errorMessage(err),
+			}
+			respond(w, r, ctx, code, response)
+			return
+		}
 		fn(w, r, ctx)
 	}
 }
 
+// readiness tells whether the service can take traffic
+// swagger:response readiness
+type readiness struct {
+	// ready or unavailable
+	Status string `json:"status"`
+	// State of the circuit breaker guarding the storage
+	Storage *resilience.BreakerState `json:"storage,omitempty"`
+}
+
 // HealthHandler returns useful info about the app
 func HealthHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
 	// swagger:route GET /health service health
@@ -51,11 +74,38 @@ func HealthHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
 	//     Responses:
 	//       200: health
 
-	check := health {
+	check := health{
 		SvcName: "go-rest-api-template",
 		Version: ctx.Version,
 	}
-	ctx.Render.JSON(w, http.StatusOK, check)
+	respond(w, req, ctx, http.StatusOK, check)
+}
+
+// ReadyHandler reports whether the service can take traffic, which it can't while the circuit
+// breaker guarding the storage is open
+func ReadyHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /ready service readiness
+	//
+	// Shows whether the service is ready.
+	//
+	// Reports the state of the circuit breaker guarding the storage; the service isn't ready
+	// while the breaker is open.
+	//
+	//     Responses:
+	//       200: readiness
+	//       503: readiness
+
+	response := readiness{Status: "ready"}
+	if ctx.Breaker != nil {
+		state := ctx.Breaker.State()
+		response.Storage = &state
+		if state.State == resilience.Open {
+			response.Status = "unavailable"
+			respond(w, req, ctx, http.StatusServiceUnavailable, response)
+			return
+		}
+	}
+	respond(w, req, ctx, http.StatusOK, response)
 }
 
 // users holds the map with the list of users and their quantity
@@ -72,28 +122,38 @@ func ListUsersHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
 	//
 	//     Responses:
 	//       200: users
+	//       304: description: not modified since If-Modified-Since
 	//       404: status
 
-	list, err := ctx.DB.ListUsers()
+	list, err := ctx.DB.ListUsers(req.Context())
 	if err != nil {
+		if unavailable(w, req, ctx, err) {
+			return
+		}
 		response := status{
 			Status:  "404",
 			Message: "can't find any users",
 		}
 		log.Println(err)
-		ctx.Render.JSON(w, http.StatusNotFound, response)
+		respond(w, req, ctx, http.StatusNotFound, response)
 		return
 	}
+	if db, ok := ctx.DB.(lastModifier); ok {
+		modified, known := db.UsersModified()
+		if cacheHeaders(w, req, ctx, modified, known) {
+			return
+		}
+	}
 	// responseObject := make(map[string]interface{})
 	responseObject := users(make(map[string]interface{}))
-	responseObject["users"] = list
+	responseObject["users"] = presentUsers(req, list)
 	responseObject["count"] = len(list)
-	ctx.Render.JSON(w, http.StatusOK, responseObject)
+	respond(w, req, ctx, http.StatusOK, responseObject)
 }
 
 // GetUserHandler returns a user object
 func GetUserHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
-	// swagger:route GET /users/{uid:[0-9]+} users listUsers
+	// swagger:route GET /users/{uid} users getUser
 	//
 	// Shows the user by uid.
 	//
@@ -101,21 +161,31 @@ func GetUserHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
 	//
 	//     Responses:
 	//       200: user
+	//       304: description: not modified since If-Modified-Since
 	//       404: status
 
 	vars := mux.Vars(req)
 	uid, _ := strconv.Atoi(vars["uid"])
-	user, err := ctx.DB.GetUser(uid)
+	user, err := ctx.DB.GetUser(req.Context(), uid)
 	if err != nil {
+		if unavailable(w, req, ctx, err) {
+			return
+		}
 		response := status{
 			Status:  "404",
 			Message: "can't find user",
 		}
 		log.Println(err)
-		ctx.Render.JSON(w, http.StatusNotFound, response)
+		respond(w, req, ctx, http.StatusNotFound, response)
 		return
 	}
-	ctx.Render.JSON(w, http.StatusOK, user)
+	if db, ok := ctx.DB.(lastModifier); ok {
+		modified, known := db.UserModified(uid)
+		if cacheHeaders(w, req, ctx, modified, known) {
+			return
+		}
+	}
+	respond(w, req, ctx, http.StatusOK, presentUser(req, user))
 }
 
 // CreateUserHandler adds a new user
@@ -129,17 +199,20 @@ func CreateUserHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
 	//     Responses:
 	//       201: user
 	//       400: status
+	//       422: status
+	//       500: status
 
-	decoder := json.NewDecoder(req.Body)
-	var u entities.User
-	err := decoder.Decode(&u)
+	u, err := decodeUser(req)
 	if err != nil {
+		readFailed(w, req, ctx, err, "malformed user object")
+		return
+	}
+	if err := validateUser(u); err != nil {
 		response := status{
-			Status:  "400",
-			Message: "malformed user object",
+			Status:  "422",
+			Message: errorMessage(err),
 		}
-		log.Println(err)
-		ctx.Render.JSON(w, http.StatusBadRequest, response)
+		respond(w, req, ctx, http.StatusUnprocessableEntity, response)
 		return
 	}
 	user := entities.User{
@@ -149,13 +222,17 @@ func CreateUserHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
 		DateOfBirth:     u.DateOfBirth,
 		LocationOfBirth: u.LocationOfBirth,
 	}
-	user, _ = ctx.DB.AddUser(user)
-	ctx.Render.JSON(w, http.StatusCreated, user)
+	user, err = ctx.DB.AddUser(req.Context(), user)
+	if err != nil {
+		ruleFailed(w, req, ctx, err)
+		return
+	}
+	respond(w, req, ctx, http.StatusCreated, presentUser(req, user))
 }
 
 // UpdateUserHandler updates a user object
 func UpdateUserHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
-	// swagger:route PUT /users users updateUser
+	// swagger:route PUT /users/{uid} users updateUser
 	//
 	// Updates the user.
 	//
@@ -164,18 +241,21 @@ func UpdateUserHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
 	//     Responses:
 	//       200: user
 	//       400: status
-	//		 500: status
+	//       404: status
+	//       422: status
+	//       500: status
 
-	decoder := json.NewDecoder(req.Body)
-	var u entities.User
-	err := decoder.Decode(&u)
+	u, err := decodeUser(req)
 	if err != nil {
+		readFailed(w, req, ctx, err, "malformed user object")
+		return
+	}
+	if err := validateUser(u); err != nil {
 		response := status{
-			Status:  "400",
-			Message: "malformed user object",
+			Status:  "422",
+			Message: errorMessage(err),
 		}
-		log.Println(err)
-		ctx.Render.JSON(w, http.StatusBadRequest, response)
+		respond(w, req, ctx, http.StatusUnprocessableEntity, response)
 		return
 	}
 	user := entities.User{
@@ -185,48 +265,267 @@ func UpdateUserHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
 		DateOfBirth:     u.DateOfBirth,
 		LocationOfBirth: u.LocationOfBirth,
 	}
-	user, err = ctx.DB.UpdateUser(user)
+	user, err = ctx.DB.UpdateUser(req.Context(), user)
 	if err != nil {
-		response := status{
-			Status:  "500",
-			Message: "something went wrong",
-		}
-		log.Println(err)
-		ctx.Render.JSON(w, http.StatusInternalServerError, response)
+		ruleFailed(w, req, ctx, err)
 		return
 	}
-	ctx.Render.JSON(w, http.StatusOK, user)
+	respond(w, req, ctx, http.StatusOK, presentUser(req, user))
 }
 
 // DeleteUserHandler deletes a user
 func DeleteUserHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
-	// swagger:route DELETE /users users deleteUser
+	// swagger:route DELETE /users/{uid} users deleteUser
 	//
 	// Deletes the user.
 	//
 	// This will delete the user.
 	//
+	// What happens to the passports of the user depends on the configured policy: restrict
+	// refuses the deletion, cascade deletes them and detach keeps them without a holder.
+	// With ?dryRun=true nothing is deleted and the response reports what would be.
+	//
 	//     Responses:
+	//       200: deletion
 	//       204: status
-	//		 500: status
+	//       404: status
+	//       409: status
+	//       500: status
 
 	vars := mux.Vars(req)
 	uid, _ := strconv.Atoi(vars["uid"])
-	err := ctx.DB.DeleteUser(uid)
+	dryRun, _ := strconv.ParseBool(req.URL.Query().Get("dryRun"))
+	deletion, err := ctx.DB.DeleteUser(req.Context(), uid, storage.DeleteOptions{Policy: ctx.DeletePolicy, DryRun: dryRun})
 	if err != nil {
+		ruleFailed(w, req, ctx, err)
+		return
+	}
+	if dryRun {
+		respond(w, req, ctx, http.StatusOK, deletion)
+		return
+	}
+	respond(w, req, ctx, http.StatusNoContent, status{})
+}
+
+// passports holds the map with the list of passports and their quantity
+// swagger:response passports
+type passports map[string]interface{}
+
+// ListUserPassportsHandler returns the passports of a user
+func ListUserPassportsHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /users/{uid}/passports passports listUserPassports
+	//
+	// Lists the passports of the user.
+	//
+	// This will show all passports of the user with the specified uid.
+	//
+	//     Responses:
+	//       200: passports
+	//       404: status
+
+	vars := mux.Vars(req)
+	uid, _ := strconv.Atoi(vars["uid"])
+	list, err := ctx.DB.ListUserPassports(req.Context(), uid)
+	if err != nil {
+		if unavailable(w, req, ctx, err) {
+			return
+		}
+		response := status{
+			Status:  "404",
+			Message: "can't find user",
+		}
+		log.Println(err)
+		respond(w, req, ctx, http.StatusNotFound, response)
+		return
+	}
+	responseObject := passports(make(map[string]interface{}))
+	responseObject["passports"] = withStatuses(ctx, list)
+	responseObject["count"] = len(list)
+	respond(w, req, ctx, http.StatusOK, responseObject)
+}
+
+// ListPassportsHandler returns all passports, or those expiring before a date
+func ListPassportsHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /passports passports listPassports
+	//
+	// Lists passports.
+	//
+	// This will show all passports, or with ?expiringBefore=2025-01-01 the passports expiring
+	// before that date, soonest first.
+	//
+	//     Responses:
+	//       200: passports
+	//       400: status
+	//       500: status
+
+	var list []entities.Passport
+	var err error
+	if before := req.URL.Query().Get("expiringBefore"); before != "" {
+		t, perr := parseDate(before)
+		if perr != nil {
+			response := status{
+				Status:  "400",
+				Message: "expiringBefore " + errorMessage(perr),
+			}
+			respond(w, req, ctx, http.StatusBadRequest, response)
+			return
+		}
+		list, err = ctx.DB.ListExpiringPassports(req.Context(), t)
+	} else {
+		list, err = ctx.DB.ListPassports(req.Context())
+	}
+	if err != nil {
+		if unavailable(w, req, ctx, err) {
+			return
+		}
 		response := status{
 			Status:  "500",
 			Message: "something went wrong",
 		}
 		log.Println(err)
-		ctx.Render.JSON(w, http.StatusInternalServerError, response)
+		respond(w, req, ctx, http.StatusInternalServerError, response)
+		return
+	}
+	responseObject := passports(make(map[string]interface{}))
+	responseObject["passports"] = withStatuses(ctx, list)
+	responseObject["count"] = len(list)
+	respond(w, req, ctx, http.StatusOK, responseObject)
+}
+
+// GetPassportHandler returns a passport object
+func GetPassportHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /passports/{pid} passports getPassport
+	//
+	// Shows the passport by pid.
+	//
+	// This will show the passport with the specified pid.
+	//
+	//     Responses:
+	//       200: passport
+	//       404: status
+
+	vars := mux.Vars(req)
+	passport, err := ctx.DB.GetPassport(req.Context(), vars["pid"])
+	if err != nil {
+		if unavailable(w, req, ctx, err) {
+			return
+		}
+		response := status{
+			Status:  "404",
+			Message: "can't find passport",
+		}
+		log.Println(err)
+		respond(w, req, ctx, http.StatusNotFound, response)
+		return
+	}
+	respond(w, req, ctx, http.StatusOK, withStatus(ctx, passport))
+}
+
+// CreateUserPassportHandler adds a new passport to a user
+func CreateUserPassportHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route POST /users/{uid}/passports passports createUserPassport
+	//
+	// Creates a passport for the user.
+	//
+	// This will add a passport to the user with the specified uid.
+	//
+	//     Responses:
+	//       201: passport
+	//       400: status
+	//       404: status
+	//       409: status
+	//       422: status
+
+	vars := mux.Vars(req)
+	uid, _ := strconv.Atoi(vars["uid"])
+	var p entities.Passport
+	err := decodeJSON(req, &p)
+	if err != nil {
+		readFailed(w, req, ctx, err, "malformed passport object")
+		return
+	}
+	p.UserID = uid
+	if err := validatePassport(p); err != nil {
+		response := status{
+			Status:  "400",
+			Message: errorMessage(err),
+		}
+		respond(w, req, ctx, http.StatusBadRequest, response)
+		return
+	}
+	p, err = ctx.DB.AddPassport(req.Context(), p)
+	if err != nil {
+		ruleFailed(w, req, ctx, err)
+		return
+	}
+	respond(w, req, ctx, http.StatusCreated, withStatus(ctx, p))
+}
+
+// UpdatePassportHandler updates a passport object
+func UpdatePassportHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route PUT /passports/{pid} passports updatePassport
+	//
+	// Updates the passport.
+	//
+	// This will update the passport with the specified pid.
+	//
+	//     Responses:
+	//       200: passport
+	//       400: status
+	//       404: status
+	//       409: status
+	//       422: status
+	//       500: status
+
+	vars := mux.Vars(req)
+	var p entities.Passport
+	err := decodeJSON(req, &p)
+	if err != nil {
+		readFailed(w, req, ctx, err, "malformed passport object")
+		return
+	}
+	p.ID = vars["pid"]
+	if err := validatePassport(p); err != nil {
+		response := status{
+			Status:  "400",
+			Message: errorMessage(err),
+		}
+		respond(w, req, ctx, http.StatusBadRequest, response)
 		return
 	}
-	ctx.Render.JSON(w, http.StatusNoContent, status{})
+	p, err = ctx.DB.UpdatePassport(req.Context(), p)
+	if err != nil {
+		ruleFailed(w, req, ctx, err)
+		return
+	}
+	respond(w, req, ctx, http.StatusOK, withStatus(ctx, p))
 }
 
-// PassportsHandler not implemented yet
-func PassportsHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
-	log.Println("Handling Passports - Not implemented yet")
-	ctx.Render.Text(w, http.StatusNotImplemented, "")
+// DeletePassportHandler deletes a passport
+func DeletePassportHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route DELETE /passports/{pid} passports deletePassport
+	//
+	// Deletes the passport.
+	//
+	// This will delete the passport.
+	//
+	//     Responses:
+	//       204: status
+	//       404: status
+
+	vars := mux.Vars(req)
+	err := ctx.DB.DeletePassport(req.Context(), vars["pid"])
+	if err != nil {
+		if unavailable(w, req, ctx, err) {
+			return
+		}
+		response := status{
+			Status:  "404",
+			Message: "can't find passport",
+		}
+		log.Println(err)
+		respond(w, req, ctx, http.StatusNotFound, response)
+		return
+	}
+	respond(w, req, ctx, http.StatusNoContent, status{})
 }
@@ -1,17 +1,27 @@
 package svc
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/resilience"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestHealthHandler(t *testing.T) {
 	ctx := NewContext()
+	defer ctx.Close()
 	r, _ := http.NewRequest("GET", "/health", nil)
 	w := httptest.NewRecorder()
 	makeHandler(ctx, HealthHandler).ServeHTTP(w, r)
@@ -27,6 +37,7 @@ func TestHealthHandler(t *testing.T) {
 
 func TestListUsersHandler(t *testing.T) {
 	ctx := NewContext()
+	defer ctx.Close()
 	req, _ := http.NewRequest("GET", "/users", nil)
 	w := httptest.NewRecorder()
 	makeHandler(ctx, ListUsersHandler).ServeHTTP(w, req)
@@ -38,3 +49,301 @@ func TestListUsersHandler(t *testing.T) {
 	m := f.(map[string]interface{})
 	log.Println(m["users"])
 }
+
+func TestCreateUserPassportHandler(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	body := `{"id": "123456789", "authority": "HMPO", "dateOfIssue": "2015-01-01T00:00:00Z", "dateOfExpiry": "2025-01-01T00:00:00Z"}`
+	req, _ := http.NewRequest("POST", "/users/1/passports", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"uid": "1"})
+	w := httptest.NewRecorder()
+	makeHandler(ctx, CreateUserPassportHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code, "they should be equal")
+	p, err := ctx.DB.GetPassport(t.Context(), "123456789")
+	if assert.Nil(t, err) {
+		assert.Equal(t, 1, p.UserID, "they should be equal")
+	}
+	// the same passport can't be added twice
+	req, _ = http.NewRequest("POST", "/users/0/passports", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"uid": "0"})
+	w = httptest.NewRecorder()
+	makeHandler(ctx, CreateUserPassportHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code, "they should be equal")
+}
+
+func TestListUserPassportsHandler(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	req, _ := http.NewRequest("GET", "/users/1/passports", nil)
+	req = mux.SetURLVars(req, map[string]string{"uid": "1"})
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ListUserPassportsHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var f map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &f)
+	assert.Equal(t, float64(1), f["count"], "they should be equal")
+
+	req = mux.SetURLVars(req, map[string]string{"uid": "10"})
+	w = httptest.NewRecorder()
+	makeHandler(ctx, ListUserPassportsHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "they should be equal")
+}
+
+func TestDeletePassportHandler(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	req, _ := http.NewRequest("DELETE", "/passports/123456789", nil)
+	req = mux.SetURLVars(req, map[string]string{"pid": "123456789"})
+	w := httptest.NewRecorder()
+	makeHandler(ctx, DeletePassportHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code, "they should be equal")
+	w = httptest.NewRecorder()
+	makeHandler(ctx, DeletePassportHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code, "they should be equal")
+}
+
+func TestCreateUserHandlerNotifiesWebhooks(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	received := make(chan entities.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var e entities.Event
+		json.NewDecoder(req.Body).Decode(&e)
+		received <- e
+	}))
+	defer srv.Close()
+	req, _ := http.NewRequest("POST", "/webhooks", strings.NewReader(`{"url": "`+srv.URL+`", "events": ["user.created"]}`))
+	w := httptest.NewRecorder()
+	makeHandler(ctx, CreateWebhookHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code, "they should be equal")
+
+	req, _ = http.NewRequest("POST", "/users", strings.NewReader(`{"firstName": "Apple", "lastName": "Jack"}`))
+	w = httptest.NewRecorder()
+	makeHandler(ctx, CreateUserHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code, "they should be equal")
+	select {
+	case e := <-received:
+		assert.Equal(t, entities.UserCreated, e.Type, "they should be equal")
+		assert.Equal(t, "Apple", e.Data.(map[string]interface{})["firstName"], "they should be equal")
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
+
+func TestListPassportsHandlerExpiringBefore(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	soon := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "111111111", Authority: "HMPO", UserID: 0, DateOfExpiry: soon})
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "222222222", Authority: "HMPO", UserID: 1, DateOfExpiry: soon.AddDate(10, 0, 0)})
+
+	req, _ := http.NewRequest("GET", "/passports?expiringBefore="+soon.AddDate(1, 0, 0).Format("2006-01-02"), nil)
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ListPassportsHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var res struct {
+		Passports []entities.Passport `json:"passports"`
+		Count     int                 `json:"count"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if assert.Equal(t, 1, res.Count, "they should be equal") {
+		assert.Equal(t, "111111111", res.Passports[0].ID, "they should be equal")
+		assert.Equal(t, entities.StatusExpiring, res.Passports[0].Status, "they should be equal")
+	}
+
+	req, _ = http.NewRequest("GET", "/passports", nil)
+	w = httptest.NewRecorder()
+	makeHandler(ctx, ListPassportsHandler).ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if assert.Equal(t, 2, res.Count, "they should be equal") {
+		assert.Equal(t, entities.StatusValid, res.Passports[1].Status, "they should be equal")
+	}
+
+	req, _ = http.NewRequest("GET", "/passports?expiringBefore=soon", nil)
+	w = httptest.NewRecorder()
+	makeHandler(ctx, ListPassportsHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+}
+
+func TestUserHandlersValidation(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	for method, h := range map[string]func(http.ResponseWriter, *http.Request, Context){
+		"POST": CreateUserHandler,
+		"PUT":  UpdateUserHandler,
+	} {
+		req, _ := http.NewRequest(method, "/users/1", strings.NewReader(`{"id": 1, "firstName": " ", "lastName": "Jack"}`))
+		req = mux.SetURLVars(req, map[string]string{"uid": "1"})
+		w := httptest.NewRecorder()
+		makeHandler(ctx, h).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, method)
+		assert.Equal(t, `{"status":"422","message":"firstName is required"}`, strings.TrimSpace(w.Body.String()), method)
+	}
+	u, _ := ctx.DB.GetUser(t.Context(), 1)
+	assert.NotEqual(t, " ", u.FirstName, "the user should be left alone")
+}
+
+func TestCreateUserPassportHandlerRules(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	for body, code := range map[string]int{
+		`{"id": "12345", "authority": "HMPO"}`:                                            http.StatusUnprocessableEntity,
+		`{"id": "123456789", "authority": "HMPO", "dateOfIssue": "1990-01-01T00:00:00Z"}`: http.StatusUnprocessableEntity,
+	} {
+		req, _ := http.NewRequest("POST", "/users/1/passports", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"uid": "1"})
+		w := httptest.NewRecorder()
+		makeHandler(ctx, CreateUserPassportHandler).ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code, body)
+	}
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	req, _ := http.NewRequest("POST", "/users/1/passports", strings.NewReader(`{"id": "987654321", "authority": "HMPO"}`))
+	req = mux.SetURLVars(req, map[string]string{"uid": "1"})
+	w := httptest.NewRecorder()
+	makeHandler(ctx, CreateUserPassportHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code, "they should be equal")
+	assert.Equal(t, `{"status":"409","message":"user already holds passport 123456789 from HMPO, which hasn't expired"}`,
+		strings.TrimSpace(w.Body.String()), "they should be equal")
+}
+
+func TestDeleteUserHandlerPolicies(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB.AddPassport(t.Context(), entities.Passport{ID: "123456789", Authority: "HMPO", UserID: 1})
+	del := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", url, nil)
+		req = mux.SetURLVars(req, map[string]string{"uid": "1"})
+		w := httptest.NewRecorder()
+		makeHandler(ctx, DeleteUserHandler).ServeHTTP(w, req)
+		return w
+	}
+	w := del("/users/1")
+	assert.Equal(t, http.StatusConflict, w.Code, "restrict is the default")
+
+	ctx.DeletePolicy = storage.Cascade
+	w = del("/users/1?dryRun=true")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, `{"userId":1,"policy":"cascade","dryRun":true,"deletedPassports":["123456789"],"detachedPassports":[]}`,
+		strings.TrimSpace(w.Body.String()), "they should be equal")
+	w = del("/users/1")
+	assert.Equal(t, http.StatusNoContent, w.Code, "they should be equal")
+	_, err := ctx.DB.GetPassport(t.Context(), "123456789")
+	assert.NotNil(t, err)
+	w = del("/users/1")
+	assert.Equal(t, http.StatusNotFound, w.Code, "they should be equal")
+}
+
+func TestCachingHeaders(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	get := func(since string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		req = mux.SetURLVars(req, map[string]string{"uid": "1"})
+		if since != "" {
+			req.Header.Set("If-Modified-Since", since)
+		}
+		w := httptest.NewRecorder()
+		makeHandler(ctx, GetUserHandler).ServeHTTP(w, req)
+		return w
+	}
+	w := get("")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "private, max-age=30", w.Header().Get("Cache-Control"), "they should be equal")
+	makeHandler(ctx, ListUsersHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+
+	// the date is only given once the second the user was cached in is over
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	w = get("")
+	modified := w.Header().Get("Last-Modified")
+	assert.NotEqual(t, "", modified, "they should not be equal")
+	w = get(modified)
+	assert.Equal(t, http.StatusNotModified, w.Code, "they should be equal")
+	assert.Equal(t, 0, w.Body.Len(), "they should be equal")
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	w = httptest.NewRecorder()
+	makeHandler(ctx, ListUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code, "they should be equal")
+
+	// a write invalidates the cached user, which is modified since, even within the same second
+	body := `{"id": 1, "firstName": "Janet", "lastName": "Doe"}`
+	req, _ = http.NewRequest("PUT", "/users/1", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"uid": "1"})
+	makeHandler(ctx, UpdateUserHandler).ServeHTTP(httptest.NewRecorder(), req)
+	w = get(modified)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "Janet", "they should be equal")
+}
+
+// brokenDB fails every user lookup and insert as if the backend were down
+type brokenDB struct {
+	Storager
+}
+
+func (db brokenDB) GetUser(ctx context.Context, i int) (entities.User, error) {
+	return entities.User{}, stacktrace.NewErrorWithCode(storage.EcodeTransient, "connection refused")
+}
+
+func (db brokenDB) AddUser(ctx context.Context, u entities.User) (entities.User, error) {
+	return entities.User{}, stacktrace.NewErrorWithCode(storage.EcodeTransient, "connection refused")
+}
+
+func TestStorageUnavailable(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.Breaker = resilience.NewBreaker(resilience.BreakerOptions{Threshold: 2})
+	ctx.DB = ctx.Breaker.Wrap(brokenDB{ctx.DB}).(Storager)
+
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ReadyHandler).ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, `{"status":"ready","storage":{"state":"closed","failures":0}}`, strings.TrimSpace(w.Body.String()), "they should be equal")
+
+	for i := 0; i < 3; i++ {
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/users/1", nil), map[string]string{"uid": "1"})
+		w = httptest.NewRecorder()
+		makeHandler(ctx, GetUserHandler).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, "they should be equal")
+	}
+	w = httptest.NewRecorder()
+	makeHandler(ctx, ReadyHandler).ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `"state":"open"`, "they should be equal")
+}
+
+func TestCreateUserHandlerStorageFailure(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.DB = brokenDB{ctx.DB}
+	body := `{"firstName": "Apple", "lastName": "Jack"}`
+	w := httptest.NewRecorder()
+	makeHandler(ctx, CreateUserHandler).ServeHTTP(w, httptest.NewRequest("POST", "/users", strings.NewReader(body)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "they should be equal")
+	assert.NotContains(t, w.Body.String(), `"id"`)
+
+	// a request that ran out of time isn't answered as created either
+	reqCtx, cancel := context.WithDeadline(t.Context(), time.Now().Add(-time.Second))
+	defer cancel()
+	ctx = NewContext()
+	defer ctx.Close()
+	req := httptest.NewRequest("POST", "/users", strings.NewReader(body)).WithContext(reqCtx)
+	w = httptest.NewRecorder()
+	makeHandler(ctx, CreateUserHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "request timed out")
+}
+
+func TestContextClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	ctx := NewContext()
+	assert.True(t, runtime.NumGoroutine() > before, "the context starts its workers")
+	ctx.Close()
+	ctx.Close()
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= before, "the workers are stopped")
+}
@@ -1,12 +1,22 @@
 package svc
 
 import (
+	"context"
 	"io/ioutil"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/kostiamol/go-rest-api-template/cache"
+	"github.com/kostiamol/go-rest-api-template/domain"
 	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/events"
+	"github.com/kostiamol/go-rest-api-template/expiry"
+	"github.com/kostiamol/go-rest-api-template/outbox"
+	"github.com/kostiamol/go-rest-api-template/resilience"
 	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+	"github.com/kostiamol/go-rest-api-template/webhook"
 	"github.com/palantir/stacktrace"
 	"github.com/unrolled/render"
 )
@@ -19,36 +29,129 @@ const (
 
 // Storager defines all the database operations
 type Storager interface {
-	ListUsers() ([]entities.User, error)
-	GetUser(i int) (entities.User, error)
-	AddUser(u entities.User) (entities.User, error)
-	UpdateUser(u entities.User) (entities.User, error)
-	DeleteUser(i int) error
+	ListUsers(ctx context.Context) ([]entities.User, error)
+	GetUser(ctx context.Context, i int) (entities.User, error)
+	GetUsers(ctx context.Context, ids []int) ([]entities.User, error)
+	AddUser(ctx context.Context, u entities.User) (entities.User, error)
+	UpdateUser(ctx context.Context, u entities.User) (entities.User, error)
+	DeleteUser(ctx context.Context, i int, opts storage.DeleteOptions) (storage.Deletion, error)
+	ListPassports(ctx context.Context) ([]entities.Passport, error)
+	ListExpiringPassports(ctx context.Context, before time.Time) ([]entities.Passport, error)
+	ListUserPassports(ctx context.Context, uid int) ([]entities.Passport, error)
+	ListPassportsOfUsers(ctx context.Context, uids []int) ([]entities.Passport, error)
+	GetPassport(ctx context.Context, id string) (entities.Passport, error)
+	AddPassport(ctx context.Context, p entities.Passport) (entities.Passport, error)
+	UpdatePassport(ctx context.Context, p entities.Passport) (entities.Passport, error)
+	DeletePassport(ctx context.Context, id string) error
+	AddRecords(ctx context.Context, records []storage.Record) ([]storage.Record, error)
 }
 
 // Context holds application configuration data
 type Context struct {
-	Render  *render.Render
-	Version string
-	Env     string
-	Port    string
-	DB      Storager
+	Render   *render.Render
+	Version  string
+	Env      string
+	Port     string
+	GRPCPort string
+	DB       Storager
+	Webhooks *webhook.Dispatcher
+	Events   *events.Broker
+	Outbox   *outbox.Relay
+	// Passports expiring within this window have the expiring status
+	ExpiryWindow time.Duration
+	// Circuit breaker guarding the storage, reported by the readiness endpoint
+	Breaker *resilience.Breaker
+	// How long user reads are cached by the storage, and may be by clients; zero disables caching
+	CacheTTL time.Duration
+	// How long a request may take unless its route says otherwise, DefaultRequestTimeout if zero
+	RequestTimeout time.Duration
+	// How large a request body may be, in bytes, unless its route says otherwise, DefaultMaxBodySize if zero
+	MaxBodySize int64
+	// Size in bytes from which responses are compressed, DefaultCompressionMinSize if zero
+	CompressionMinSize int
+	// What happens to the passports of deleted users
+	DeletePolicy storage.DeletePolicy
+	// DB is the storage of the default tenant, Tenants that of the others
+	Tenants        TenantStorager
+	TenantResolver tenant.Resolver
+	// When version 1 of the API goes away, announced by its responses unless zero
+	V1Sunset time.Time
+	// Policy for cross-origin requests, which are refused by default
+	CORS CORS
+	// HTTPS serving, plain HTTP if it has no certificate
+	TLS TLS
+
+	// the OpenAPI document, built by NewRouter
+	openAPI []byte
 }
 
-// NewContext initialises an application context struct for testing purposes
-func NewContext() Context {
-	testVersion := "0.0.0"
-	db := storage.NewMockDB()
+// StorageOptions configures the layers NewStorageContext puts in front of the storage
+type StorageOptions struct {
+	// Patterns of the passport ids each authority issues, domain.DefaultAuthorities() if nil
+	Authorities domain.Authorities
+	// Number of cached user reads, the cache's default if zero
+	CacheSize int
+	// How long user reads are cached; zero disables caching
+	CacheTTL time.Duration
+	// Options of the webhook deliveries
+	Webhooks webhook.Options
+}
+
+// NewStorageContext returns a context serving the tenants kept in tenants, each through retries,
+// a circuit breaker, the business rules and a cache, with the webhooks and the event stream the
+// outbox of their storage is relayed to. Its background work is stopped by Close.
+func NewStorageContext(tenants *storage.Tenants, opts StorageOptions) Context {
+	if opts.Authorities == nil {
+		opts.Authorities = domain.DefaultAuthorities()
+	}
+	lru := cache.NewLRU(opts.CacheSize)
+	breaker := resilience.NewBreaker(resilience.BreakerOptions{})
+	layer := func(id string, db domain.Store) Storager {
+		db = breaker.Wrap(resilience.Retry(db, resilience.RetryOptions{}))
+		return Cached(domain.NewService(db, opts.Authorities), lru, id, opts.CacheTTL)
+	}
+	db, _ := tenants.For(tenant.Default)
 	ctx := Context{
-		Render:  render.New(),
-		Version: testVersion,
-		Env:     Local,
-		Port:    "3001",
-		DB:      db,
+		Render:   render.New(),
+		DB:       layer(tenant.Default, db),
+		Webhooks: webhook.NewDispatcher(opts.Webhooks),
+		Events:   events.NewBroker(0),
+
+		Breaker:  breaker,
+		CacheTTL: opts.CacheTTL,
+		Tenants:  NewTenantDBs(tenants, layer),
 	}
+	ctx.Outbox = outbox.NewRelay(db, outbox.Options{}, ctx.Webhooks, ctx.Events)
 	return ctx
 }
 
+// NewContext initialises an application context struct for testing purposes, serving the acme
+// tenant besides the default one. Its background work is stopped by Close.
+func NewContext() Context {
+	ctx := NewStorageContext(storage.NewTenants(storage.NewMockDB(), "acme"), StorageOptions{
+		CacheTTL: cache.DefaultTTL,
+		Webhooks: webhook.Options{AllowInternal: true},
+	})
+	ctx.Version = "0.0.0"
+	ctx.Env = Local
+	ctx.Port = "3001"
+	ctx.GRPCPort = "3002"
+	ctx.ExpiryWindow = expiry.DefaultWindow
+	ctx.DeletePolicy = storage.Restrict
+	return ctx
+}
+
+// Close stops the background work of the context: the outbox relay, after it has published what
+// is pending, then the webhook dispatcher
+func (ctx Context) Close() {
+	if ctx.Outbox != nil {
+		ctx.Outbox.Close()
+	}
+	if ctx.Webhooks != nil {
+		ctx.Webhooks.Close()
+	}
+}
+
 // ParseVersionFile returns the version as a string, parsing and validating a file given the path
 func ParseVersionFile(versionPath string) (string, error) {
 	dat, err := ioutil.ReadFile(versionPath)
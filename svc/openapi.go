@@ -0,0 +1,519 @@
+package svc
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/kostiamol/go-rest-api-template/webhook"
+	"github.com/palantir/stacktrace"
+)
+
+// openAPI is an OpenAPI 3 document
+type openAPI struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*schema `json:"schemas"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Tags        []string                    `json:"tags"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                    `json:"required"`
+	Content  map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIMedia struct {
+	Schema *schema `json:"schema"`
+}
+
+// schema is a JSON schema, as far as OpenAPI 3.0 and this API need it
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*schema          `json:"allOf,omitempty"`
+	OneOf                []*schema          `json:"oneOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+}
+
+// apiDoc documents the route with the same method and pattern
+type apiDoc struct {
+	id      string
+	summary string
+	tag     string
+	// query and header parameters; path parameters are taken from the pattern
+	params []openAPIParameter
+	body   *apiBody
+	// the body of each response: a value of the Go type rendered, a *schema, a listOf or nil
+	// for an empty body
+	responses map[int]interface{}
+	// content type of the responses, JSON by default
+	produces []string
+	// whether the route is going away
+	deprecated bool
+}
+
+// apiBody documents a request body
+type apiBody struct {
+	// a value of the Go type decoded
+	value interface{}
+	// fields the handler requires
+	required []string
+	// accepted content types, JSON by default
+	consumes []string
+	// values of the other Go types the body may hold instead
+	oneOf []interface{}
+}
+
+// listOf is the schema of the list responses, holding the items under key along with their count
+type listOf struct {
+	key  string
+	item interface{}
+}
+
+func query(name, description string, s *schema) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Description: description, Schema: s}
+}
+
+var (
+	boolean  = &schema{Type: "boolean"}
+	text     = &schema{Type: "string"}
+	anything = &schema{}
+)
+
+// apiDocs documents the routes, keyed by method and pattern
+var apiDocs = map[string]apiDoc{
+	"GET /health": {id: "health", summary: "Shows the service status.", tag: "service",
+		responses: map[int]interface{}{200: health{}}},
+	"GET /ready": {id: "readiness", summary: "Shows whether the service is ready.", tag: "service",
+		responses: map[int]interface{}{200: readiness{}, 503: readiness{}}},
+	"GET /openapi.json": {id: "openAPI", summary: "Returns this OpenAPI document.", tag: "service",
+		responses: map[int]interface{}{200: anything}},
+	"GET /docs": {id: "docs", summary: "Shows the API documentation.", tag: "service",
+		responses: map[int]interface{}{200: text}, produces: []string{"text/html"}},
+	"GET /": {id: "listUsersAtRoot", summary: "Lists users.", tag: "users",
+		responses: map[int]interface{}{200: listOf{"users", entities.User{}}, 304: nil, 404: status{}}},
+	"GET /users": {id: "listUsers", summary: "Lists users.", tag: "users",
+		responses: map[int]interface{}{200: listOf{"users", entities.User{}}, 304: nil, 404: status{}}},
+	"GET /users/{uid:[0-9]+}": {id: "getUser", summary: "Shows the user by uid.", tag: "users",
+		responses: map[int]interface{}{200: entities.User{}, 304: nil, 404: status{}}},
+	"POST /users": {id: "createUser", summary: "Creates the user.", tag: "users",
+		body:      &apiBody{value: entities.User{}, required: []string{"firstName", "lastName"}},
+		responses: map[int]interface{}{201: entities.User{}, 400: status{}, 422: status{}, 500: status{}}},
+	"PUT /users/{uid:[0-9]+}": {id: "updateUser", summary: "Updates the user.", tag: "users",
+		body:      &apiBody{value: entities.User{}},
+		responses: map[int]interface{}{200: entities.User{}, 400: status{}, 404: status{}, 422: status{}, 500: status{}}},
+	"DELETE /users/{uid:[0-9]+}": {id: "deleteUser", summary: "Deletes the user.", tag: "users",
+		params:    []openAPIParameter{query("dryRun", "report what would be deleted without deleting it", boolean)},
+		responses: map[int]interface{}{200: storage.Deletion{}, 204: nil, 404: status{}, 409: status{}, 500: status{}}},
+	"POST /users:batch": {id: "batchUsers", summary: "Creates users in bulk.", tag: "users",
+		params:    []openAPIParameter{query("atomic", "create every item or none", boolean)},
+		body:      &apiBody{value: []userRecord{}, oneOf: []interface{}{storage.Fixtures{}}, consumes: []string{"application/json", "application/x-ndjson", "text/csv"}},
+		responses: map[int]interface{}{200: batchResults{}, 400: status{}, 422: batchResults{}}},
+	"GET /export": {id: "exportData", summary: "Exports all data.", tag: "export",
+		params:    []openAPIParameter{query("format", "export format, taken from the Accept header by default", &schema{Type: "string", Enum: []string{"json", "ndjson", "csv"}})},
+		responses: map[int]interface{}{200: storage.Fixtures{}, 400: status{}, 500: status{}},
+		produces:  []string{"application/json", "application/x-ndjson", "text/csv"}},
+	"GET /graphql": {id: "graphQLQuery", summary: "Executes a GraphQL query, or returns the schema without one.", tag: "graphql",
+		params: []openAPIParameter{
+			query("query", "the GraphQL query", text),
+			query("operationName", "the operation to execute", text),
+			query("variables", "the variables of the query as JSON", text),
+		},
+		responses: map[int]interface{}{200: gqlResponse{}, 400: gqlResponse{}, 405: gqlResponse{}}},
+	"POST /graphql": {id: "graphQL", summary: "Executes a GraphQL request.", tag: "graphql",
+		body:      &apiBody{value: gqlRequest{}, required: []string{"query"}},
+		responses: map[int]interface{}{200: gqlResponse{}, 400: gqlResponse{}}},
+	"GET /events": {id: "streamEvents", summary: "Streams changes to users and passports as server-sent events.", tag: "events",
+		params: []openAPIParameter{
+			query("types", "comma-separated entity types to stream, user or passport", text),
+			query("lastEventId", "resume after this event", text),
+			{Name: "Last-Event-ID", In: "header", Description: "resume after this event", Schema: text},
+		},
+		responses: map[int]interface{}{200: text, 500: status{}},
+		produces:  []string{"text/event-stream"}},
+	"GET /webhooks": {id: "listWebhooks", summary: "Lists webhooks.", tag: "webhooks",
+		responses: map[int]interface{}{200: listOf{"webhooks", webhook.Subscription{}}}},
+	"POST /webhooks": {id: "createWebhook", summary: "Subscribes to events.", tag: "webhooks",
+		body:      &apiBody{value: webhook.Subscription{}, required: []string{"url"}},
+		responses: map[int]interface{}{201: webhook.Subscription{}, 400: status{}}},
+	"DELETE /webhooks/{wid}": {id: "deleteWebhook", summary: "Deletes the webhook.", tag: "webhooks",
+		responses: map[int]interface{}{204: nil, 404: status{}}},
+	"GET /webhooks/deadletters": {id: "listDeadLetters", summary: "Lists undeliverable events.", tag: "webhooks",
+		responses: map[int]interface{}{200: listOf{"deadLetters", webhook.DeadLetter{}}}},
+	"GET /users/{uid:[0-9]+}/passports": {id: "listUserPassports", summary: "Lists the passports of the user.", tag: "passports",
+		responses: map[int]interface{}{200: listOf{"passports", entities.Passport{}}, 404: status{}}},
+	"GET /passports": {id: "listPassports", summary: "Lists passports.", tag: "passports",
+		params:    []openAPIParameter{query("expiringBefore", "only passports expiring before this date, soonest first", &schema{Type: "string", Format: "date"})},
+		responses: map[int]interface{}{200: listOf{"passports", entities.Passport{}}, 400: status{}, 500: status{}}},
+	"GET /passports/{pid}": {id: "getPassport", summary: "Shows the passport by pid.", tag: "passports",
+		responses: map[int]interface{}{200: entities.Passport{}, 404: status{}}},
+	"POST /users/{uid:[0-9]+}/passports": {id: "createUserPassport", summary: "Creates a passport for the user.", tag: "passports",
+		body:      &apiBody{value: entities.Passport{}, required: []string{"id", "authority"}},
+		responses: map[int]interface{}{201: entities.Passport{}, 400: status{}, 404: status{}, 409: status{}, 422: status{}}},
+	"PUT /passports/{pid}": {id: "updatePassport", summary: "Updates the passport.", tag: "passports",
+		body:      &apiBody{value: entities.Passport{}, required: []string{"authority"}},
+		responses: map[int]interface{}{200: entities.Passport{}, 400: status{}, 404: status{}, 409: status{}, 422: status{}, 500: status{}}},
+	"DELETE /passports/{pid}": {id: "deletePassport", summary: "Deletes the passport.", tag: "passports",
+		responses: map[int]interface{}{204: nil, 404: status{}}},
+}
+
+// schemaNames names the component schemas of types whose Go name doesn't suit the API
+var schemaNames = map[reflect.Type]string{
+	reflect.TypeOf(gqlRequest{}):  "GraphQLRequest",
+	reflect.TypeOf(gqlResponse{}): "GraphQLResponse",
+	reflect.TypeOf(gqlError{}):    "GraphQLError",
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+	pathParam   = regexp.MustCompile(`\{([^}:]+)(?::([^}]+))?\}`)
+)
+
+// specBuilder builds an OpenAPI document, collecting the schemas of the types it meets
+type specBuilder struct {
+	schemas map[string]*schema
+	types   map[string]reflect.Type
+}
+
+// buildOpenAPI describes the routes with the docs of apiDocs. Routes without docs, and docs
+// without routes, are an error, so that the two can't drift apart. The routes of an API version
+// share the docs of the unprefixed route, adapted to the version.
+func buildOpenAPI(version string, routes Routes) (*openAPI, error) {
+	b := &specBuilder{schemas: make(map[string]*schema), types: make(map[string]reflect.Type)}
+	doc := &openAPI{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "go-rest-api-template", Version: version},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+	routed := make(map[string]bool)
+	documented := make(map[string]bool)
+	ids := make(map[string]bool)
+	for _, route := range routes {
+		key := route.Method + " " + route.Pattern
+		v, pattern := splitVersion(route.Pattern)
+		d, ok := apiDocs[route.Method+" "+pattern]
+		if !ok {
+			return nil, stacktrace.NewError("route %s %s is undocumented", route.Name, key)
+		}
+		if routed[key] {
+			return nil, stacktrace.NewError("route %s is declared twice", key)
+		}
+		routed[key] = true
+		documented[route.Method+" "+pattern] = true
+		if v != nil {
+			d = v.document(d)
+		}
+		if ids[d.id] {
+			return nil, stacktrace.NewError("operation id %s is used twice", d.id)
+		}
+		ids[d.id] = true
+		path, params := openAPIPath(route.Pattern)
+		op, err := b.operation(d, params)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "can't document route %s", key)
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+	}
+	for key := range apiDocs {
+		if !documented[key] {
+			return nil, stacktrace.NewError("%s is documented but not routed", key)
+		}
+	}
+	doc.Components.Schemas = b.schemas
+	return doc, nil
+}
+
+// openAPIPath turns a mux pattern into an OpenAPI path and its path parameters
+func openAPIPath(pattern string) (string, []openAPIParameter) {
+	var params []openAPIParameter
+	path := pathParam.ReplaceAllStringFunc(pattern, func(m string) string {
+		parts := pathParam.FindStringSubmatch(m)
+		s := &schema{Type: "string"}
+		if parts[2] == "[0-9]+" {
+			s = &schema{Type: "integer", Format: "int64"}
+		} else if parts[2] != "" {
+			s.Pattern = "^" + parts[2] + "$"
+		}
+		params = append(params, openAPIParameter{Name: parts[1], In: "path", Required: true, Schema: s})
+		return "{" + parts[1] + "}"
+	})
+	return path, params
+}
+
+func (b *specBuilder) operation(d apiDoc, params []openAPIParameter) (*openAPIOperation, error) {
+	op := &openAPIOperation{
+		OperationID: d.id,
+		Summary:     d.summary,
+		Tags:        []string{d.tag},
+		Deprecated:  d.deprecated,
+		Parameters:  append(params, d.params...),
+		Responses:   make(map[string]*openAPIResponse),
+	}
+	if d.body != nil {
+		s, err := b.schemaOf(reflect.TypeOf(d.body.value))
+		if err != nil {
+			return nil, err
+		}
+		if len(d.body.required) > 0 {
+			s = &schema{AllOf: []*schema{s}, Required: d.body.required}
+		}
+		if len(d.body.oneOf) > 0 {
+			s = &schema{OneOf: []*schema{s}}
+			for _, v := range d.body.oneOf {
+				alt, err := b.schemaOf(reflect.TypeOf(v))
+				if err != nil {
+					return nil, err
+				}
+				s.OneOf = append(s.OneOf, alt)
+			}
+		}
+		op.RequestBody = &openAPIRequestBody{Required: true, Content: content(d.body.consumes, s)}
+	}
+	codes := make([]int, 0, len(d.responses))
+	for code := range d.responses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		r := &openAPIResponse{Description: http.StatusText(code)}
+		if v := d.responses[code]; v != nil {
+			s, err := b.bodySchema(v)
+			if err != nil {
+				return nil, err
+			}
+			produces := d.produces
+			if code >= 400 {
+				produces = nil
+			}
+			r.Content = content(produces, s)
+		}
+		op.Responses[strconv.Itoa(code)] = r
+	}
+	// any request may fail on its tenant, its Accept header or its deadline
+	def, err := b.schemaOf(reflect.TypeOf(status{}))
+	if err != nil {
+		return nil, err
+	}
+	op.Responses["default"] = &openAPIResponse{Description: "Error", Content: content(nil, def)}
+	return op, nil
+}
+
+// content describes a body of the given content types, JSON if none are given
+func content(types []string, s *schema) map[string]openAPIMedia {
+	if len(types) == 0 {
+		types = []string{"application/json"}
+	}
+	c := make(map[string]openAPIMedia, len(types))
+	for _, t := range types {
+		c[t] = openAPIMedia{Schema: s}
+	}
+	return c
+}
+
+// bodySchema returns the schema of a documented response body
+func (b *specBuilder) bodySchema(v interface{}) (*schema, error) {
+	switch v := v.(type) {
+	case *schema:
+		return v, nil
+	case listOf:
+		item, err := b.schemaOf(reflect.TypeOf(v.item))
+		if err != nil {
+			return nil, err
+		}
+		return &schema{
+			Type: "object",
+			Properties: map[string]*schema{
+				v.key:   {Type: "array", Items: item},
+				"count": {Type: "integer"},
+			},
+			Required: []string{v.key, "count"},
+		}, nil
+	}
+	return b.schemaOf(reflect.TypeOf(v))
+}
+
+// schemaOf returns the schema of the JSON encoding of a Go type. Structs become component schemas
+// named after their type and are referenced.
+func (b *specBuilder) schemaOf(t reflect.Type) (*schema, error) {
+	switch {
+	case t == timeType:
+		return &schema{Type: "string", Format: "date-time"}, nil
+	case t == rawJSONType:
+		return &schema{}, nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schemaOf(t.Elem())
+	case reflect.String:
+		return &schema{Type: "string"}, nil
+	case reflect.Bool:
+		return &schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}, nil
+	case reflect.Interface:
+		return &schema{}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := b.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, stacktrace.NewError("map %s has no string keys", t)
+		}
+		values, err := b.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		return b.component(t)
+	}
+	return nil, stacktrace.NewError("type %s has no JSON schema", t)
+}
+
+// component adds the schema of a struct to the components and returns a reference to it
+func (b *specBuilder) component(t reflect.Type) (*schema, error) {
+	name, ok := schemaNames[t]
+	if !ok {
+		name = strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	}
+	ref := &schema{Ref: "#/components/schemas/" + name}
+	if other, ok := b.types[name]; ok {
+		if other != t {
+			return nil, stacktrace.NewError("types %s and %s share the schema name %s", other, t, name)
+		}
+		return ref, nil
+	}
+	b.types[name] = t
+	s := &schema{Type: "object", Properties: make(map[string]*schema)}
+	b.schemas[name] = s
+	if err := b.fields(t, s); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// fields adds the JSON fields of a struct to s, those of embedded structs included
+func (b *specBuilder) fields(t reflect.Type, s *schema) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			if err := b.fields(f.Type, s); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs, err := b.schemaOf(f.Type)
+		if err != nil {
+			return stacktrace.Propagate(err, "field %s of %s", f.Name, t)
+		}
+		s.Properties[name] = fs
+	}
+	return nil
+}
+
+// OpenAPIHandler returns the OpenAPI document of the API
+func OpenAPIHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /openapi.json service openAPI
+	//
+	// Returns the OpenAPI document.
+	//
+	// The document is built from the routes of the service when it starts.
+	//
+	//     Responses:
+	//       200: description: the OpenAPI document
+
+	if ctx.openAPI == nil {
+		response := status{
+			Status:  "500",
+			Message: "the OpenAPI document is only built by NewRouter",
+		}
+		respond(w, req, ctx, http.StatusInternalServerError, response)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(ctx.openAPI)
+}
+
+//go:embed docs.html
+var docsPage []byte
+
+// DocsHandler shows the OpenAPI document with Swagger UI
+func DocsHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /docs service docs
+	//
+	// Shows the API documentation.
+	//
+	// Renders /openapi.json with Swagger UI.
+	//
+	//     Responses:
+	//       200: description: the documentation page
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(docsPage)
+}
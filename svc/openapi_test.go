@@ -0,0 +1,168 @@
+package svc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/resilience"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/kostiamol/go-rest-api-template/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+// servedOpenAPI fetches the OpenAPI document from the router
+func servedOpenAPI(t *testing.T, router *mux.Router) map[string]interface{} {
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var doc map[string]interface{}
+	if !assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &doc)) {
+		t.FailNow()
+	}
+	return doc
+}
+
+// refs collects the $ref values of a decoded JSON document
+func refs(v interface{}, found map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				found[ref] = true
+			}
+			refs(value, found)
+		}
+	case []interface{}:
+		for _, value := range v {
+			refs(value, found)
+		}
+	}
+}
+
+func TestOpenAPIMatchesRouter(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	router := NewRouter(ctx)
+	doc := servedOpenAPI(t, router)
+	assert.Equal(t, "3.0.3", doc["openapi"], "they should be equal")
+	assert.Equal(t, ctx.Version, doc["info"].(map[string]interface{})["version"], "they should be equal")
+	paths := doc["paths"].(map[string]interface{})
+
+	// every route the router serves is in the document, and nothing else is
+	routed := 0
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		assert.Nil(t, err)
+		methods, err := route.GetMethods()
+		assert.Nil(t, err)
+		path, _ := openAPIPath(template)
+		for _, method := range methods {
+			if method == "OPTIONS" {
+				// preflight routes are generated for every path and aren't documented
+				continue
+			}
+			routed++
+			operations, ok := paths[path].(map[string]interface{})
+			if assert.True(t, ok, "path %s is missing", path) {
+				assert.Contains(t, operations, strings.ToLower(method), "they should be equal")
+			}
+		}
+		return nil
+	})
+	documented := 0
+	ids := make(map[string]bool)
+	for path, operations := range paths {
+		for method, op := range operations.(map[string]interface{}) {
+			documented++
+			id := op.(map[string]interface{})["operationId"].(string)
+			assert.False(t, ids[id], "operation id %s of %s %s is used twice", id, method, path)
+			ids[id] = true
+		}
+	}
+	assert.Equal(t, routed, documented, "they should be equal")
+
+	// every reference resolves
+	found := make(map[string]bool)
+	refs(doc, found)
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for ref := range found {
+		assert.Contains(t, schemas, strings.TrimPrefix(ref, "#/components/schemas/"), "they should be equal")
+	}
+}
+
+func TestOpenAPISchemasMatchEntities(t *testing.T) {
+	doc, err := buildOpenAPI("0.0.0", allRoutes())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	now := time.Now()
+	for name, v := range map[string]interface{}{
+		"User":         entities.User{},
+		"Passport":     entities.Passport{Status: entities.StatusValid},
+		"Deletion":     storage.Deletion{},
+		"Subscription": webhook.Subscription{Secret: "secret", Events: []string{entities.UserCreated}},
+		"DeadLetter":   webhook.DeadLetter{},
+		"Readiness":    readiness{Storage: &resilience.BreakerState{OpenedAt: &now}},
+	} {
+		data, _ := json.Marshal(v)
+		var fields map[string]interface{}
+		json.Unmarshal(data, &fields)
+		var want, got []string
+		for field := range fields {
+			want = append(want, field)
+		}
+		for property := range doc.Components.Schemas[name].Properties {
+			got = append(got, property)
+		}
+		sort.Strings(want)
+		sort.Strings(got)
+		assert.Equal(t, want, got, "they should be equal")
+	}
+}
+
+func TestOpenAPIDrift(t *testing.T) {
+	undocumented := allRoutes()
+	undocumented = append(undocumented, Route{"Secret", "GET", "/secret", HealthHandler})
+	_, err := buildOpenAPI("0.0.0", undocumented)
+	assert.NotNil(t, err)
+
+	_, err = buildOpenAPI("0.0.0", allRoutes()[1:])
+	assert.NotNil(t, err)
+}
+
+func TestDocsHandler(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/docs", nil)
+	w := httptest.NewRecorder()
+	ctx := NewContext()
+	defer ctx.Close()
+	NewRouter(ctx).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "SwaggerUIBundle")
+}
+
+func TestPassportIDsAreStrings(t *testing.T) {
+	// authorities may issue passport numbers with letters, which the routes take as they are
+	ctx := NewContext()
+	defer ctx.Close()
+	db := storage.NewMockDB()
+	db.AddPassport(t.Context(), entities.Passport{ID: "AB1234567", Authority: "Ministry of Magic", UserID: 1})
+	ctx.DB = db
+	w := httptest.NewRecorder()
+	NewRouter(ctx).ServeHTTP(w, httptest.NewRequest("GET", "/passports/AB1234567", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `"authority":"Ministry of Magic"`)
+
+	path, params := openAPIPath("/passports/{pid}")
+	assert.Equal(t, "/passports/{pid}", path, "they should be equal")
+	if assert.Equal(t, 1, len(params)) {
+		assert.Equal(t, "string", params[0].Schema.Type, "they should be equal")
+	}
+}
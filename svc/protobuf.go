@@ -0,0 +1,143 @@
+package svc
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/proto/userspb"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// This file converts the entities to and from the messages of proto/users.proto. Zero times are
+// left out, as the JSON representation does.
+
+func timestampProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func timeFromProto(ts *timestamppb.Timestamp) (time.Time, error) {
+	if ts == nil {
+		return time.Time{}, nil
+	}
+	if err := ts.CheckValid(); err != nil {
+		return time.Time{}, stacktrace.Propagate(err, "invalid timestamp")
+	}
+	return ts.AsTime(), nil
+}
+
+func userProto(u entities.User) *userspb.User {
+	return &userspb.User{
+		Id:              int32(u.ID),
+		FirstName:       u.FirstName,
+		LastName:        u.LastName,
+		DateOfBirth:     timestampProto(u.DateOfBirth),
+		LocationOfBirth: u.LocationOfBirth,
+	}
+}
+
+func userFromProto(m *userspb.User) (entities.User, error) {
+	u := entities.User{
+		ID:              int(m.GetId()),
+		FirstName:       m.GetFirstName(),
+		LastName:        m.GetLastName(),
+		LocationOfBirth: m.GetLocationOfBirth(),
+	}
+	var err error
+	u.DateOfBirth, err = timeFromProto(m.GetDateOfBirth())
+	return u, err
+}
+
+func passportProto(p entities.Passport) *userspb.Passport {
+	return &userspb.Passport{
+		Id:           p.ID,
+		DateOfIssue:  timestampProto(p.DateOfIssue),
+		DateOfExpiry: timestampProto(p.DateOfExpiry),
+		Authority:    p.Authority,
+		UserId:       int32(p.UserID),
+		Status:       p.Status,
+	}
+}
+
+// passportFromProto reads a passport sent by a client, ignoring its status, which is computed
+func passportFromProto(m *userspb.Passport) (entities.Passport, error) {
+	p := entities.Passport{
+		ID:        m.GetId(),
+		Authority: m.GetAuthority(),
+		UserID:    int(m.GetUserId()),
+	}
+	var err error
+	if p.DateOfIssue, err = timeFromProto(m.GetDateOfIssue()); err != nil {
+		return p, err
+	}
+	p.DateOfExpiry, err = timeFromProto(m.GetDateOfExpiry())
+	return p, err
+}
+
+func userRecordProto(r userRecord) *userspb.UserRecord {
+	m := &userspb.UserRecord{User: userProto(r.User)}
+	for _, p := range r.Passports {
+		m.Passports = append(m.Passports, passportProto(p))
+	}
+	return m
+}
+
+func userRecordFromProto(m *userspb.UserRecord) (userRecord, error) {
+	var r userRecord
+	var err error
+	if r.User, err = userFromProto(m.GetUser()); err != nil {
+		return r, err
+	}
+	for _, mp := range m.GetPassports() {
+		p, err := passportFromProto(mp)
+		if err != nil {
+			return r, err
+		}
+		r.Passports = append(r.Passports, p)
+	}
+	return r, nil
+}
+
+func userListProto(list []entities.User) *userspb.ListUsersResponse {
+	m := &userspb.ListUsersResponse{Count: int32(len(list))}
+	for _, u := range list {
+		m.Users = append(m.Users, userProto(u))
+	}
+	return m
+}
+
+func passportListProto(list []entities.Passport) *userspb.ListPassportsResponse {
+	m := &userspb.ListPassportsResponse{Count: int32(len(list))}
+	for _, p := range list {
+		m.Passports = append(m.Passports, passportProto(p))
+	}
+	return m
+}
+
+func deletionProto(d storage.Deletion) *userspb.Deletion {
+	return &userspb.Deletion{
+		UserId:            int32(d.UserID),
+		Policy:            string(d.Policy),
+		DryRun:            d.DryRun,
+		DeletedPassports:  d.DeletedPassports,
+		DetachedPassports: d.DetachedPassports,
+	}
+}
+
+func batchResultsProto(res batchResults) *userspb.BatchUsersResponse {
+	m := &userspb.BatchUsersResponse{Created: int32(res.Created), Failed: int32(res.Failed)}
+	for _, r := range res.Results {
+		code, _ := strconv.Atoi(r.Status)
+		result := &userspb.BatchResult{Index: int32(r.Index), Status: int32(code), Message: r.Message}
+		if r.User != nil {
+			result.Record = userRecordProto(*r.User)
+		}
+		m.Results = append(m.Results, result)
+	}
+	return m
+}
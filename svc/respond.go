@@ -0,0 +1,444 @@
+package svc
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// representation is a response format the service can negotiate
+type representation struct {
+	contentType string
+	aliases     []string
+	encode      func(n node, root string) ([]byte, error)
+}
+
+// representations are listed in order of preference, the first one is the default
+var representations = []representation{
+	{"application/json", []string{"application/json"}, nil},
+	{"application/xml", []string{"application/xml", "text/xml"}, encodeXML},
+	{"text/csv", []string{"text/csv"}, encodeCSV},
+	{"application/yaml", []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}, encodeYAML},
+}
+
+// respond renders v in the representation negotiated from the Accept header of the request.
+// JSON goes through ctx.Render as before, the other formats are derived from the JSON encoding
+// of v so that json tags and custom marshalers are honoured everywhere. Failures of requests that
+// ran out of time are reported as such.
+func respond(w http.ResponseWriter, req *http.Request, ctx Context, code int, v interface{}) {
+	code, v = timedOut(req, code, v)
+	w.Header().Add("Vary", "Accept")
+	rep, ok := negotiate(req.Header.Get("Accept"))
+	if !ok {
+		notAcceptable(w, req, ctx, nil)
+		return
+	}
+	if rep.encode == nil {
+		ctx.Render.JSON(w, code, v)
+		return
+	}
+	data, err := json.Marshal(v)
+	if err == nil {
+		var n node
+		n, err = decodeNode(json.NewDecoder(bytes.NewReader(data)))
+		if err == nil {
+			data, err = rep.encode(n, rootName(v))
+		}
+	}
+	if err != nil {
+		response := status{
+			Status:  "500",
+			Message: "can't render response as " + rep.contentType,
+		}
+		log.Println(err)
+		ctx.Render.JSON(w, http.StatusInternalServerError, response)
+		return
+	}
+	w.Header().Set("Content-Type", rep.contentType+"; charset=UTF-8")
+	ctx.Render.Data(w, code, data)
+}
+
+// routeMediaTypes are the media types routes produce besides the representations of respond
+var routeMediaTypes = map[string][]string{
+	"Docs":         {"text/html"},
+	"Export":       {"application/x-ndjson"},
+	"StreamEvents": {"text/event-stream"},
+}
+
+// withAcceptable answers 406 to the requests of a route whose Accept header allows none of the
+// representations of respond nor the media types of the route, before h runs, so that requests
+// refused for their Accept header have no effect
+func withAcceptable(ctx Context, name string, h http.Handler) http.Handler {
+	types := routeMediaTypes[name]
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		accept := req.Header.Get("Accept")
+		if _, ok := negotiate(accept); !ok && !acceptsAny(accept, types) {
+			notAcceptable(w, req, ctx, types)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// notAcceptable answers a request whose Accept header allows none of the representations, nor
+// the other media types given
+func notAcceptable(w http.ResponseWriter, req *http.Request, ctx Context, other []string) {
+	w.Header().Add("Vary", "Accept")
+	types := make([]string, len(representations), len(representations)+len(other))
+	for i, r := range representations {
+		types[i] = r.contentType
+	}
+	response := status{
+		Status:  "406",
+		Message: "not acceptable, supported types are " + strings.Join(append(types, other...), ", "),
+	}
+	ctx.Render.JSON(w, http.StatusNotAcceptable, response)
+}
+
+// acceptRange is a media range of an Accept header with its quality
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// acceptRanges returns the media ranges an Accept header allows, most preferred first
+func acceptRanges(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		r := acceptRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && kv[0] == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		if r.q > 0 && r.mediaType != "" {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+// matches reports whether a media range covers a media type
+func (r acceptRange) matches(mediaType string) bool {
+	return r.mediaType == mediaType || r.mediaType == "*/*" ||
+		strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*"))
+}
+
+// acceptsAny reports whether an Accept header allows one of types
+func acceptsAny(accept string, types []string) bool {
+	for _, r := range acceptRanges(accept) {
+		for _, t := range types {
+			if r.matches(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// negotiate picks the representation that best matches an Accept header
+func negotiate(accept string) (representation, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return representations[0], true
+	}
+	for _, r := range acceptRanges(accept) {
+		if r.mediaType == "*/*" || versionMediaType.MatchString(r.mediaType) {
+			return representations[0], true
+		}
+		for _, rep := range representations {
+			for _, alias := range rep.aliases {
+				if r.matches(alias) {
+					return rep, true
+				}
+			}
+		}
+	}
+	return representation{}, false
+}
+
+// rootName names the document element of a response after the Go type of v, without the version
+// of versioned representations, e.g. user for userV2
+func rootName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return "response"
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	suffix := ""
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+		suffix = "s"
+	}
+	name := versionSuffix.ReplaceAllString(t.Name(), "")
+	if name == "" {
+		return "response"
+	}
+	return strings.ToLower(name[:1]) + name[1:] + suffix
+}
+
+type nodeKind int
+
+const (
+	nullNode nodeKind = iota
+	stringNode
+	numberNode
+	boolNode
+	objectNode
+	arrayNode
+)
+
+// node is a format neutral response body which, unlike a map, keeps the field order of the JSON encoding
+type node struct {
+	kind   nodeKind
+	value  string
+	fields []field
+	items  []node
+}
+
+type field struct {
+	name  string
+	value node
+}
+
+func (n node) scalar() bool {
+	return n.kind != objectNode && n.kind != arrayNode
+}
+
+// decodeNode reads the next JSON value from dec
+func decodeNode(dec *json.Decoder) (node, error) {
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return node{}, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		n := node{kind: arrayNode}
+		if t == '{' {
+			n.kind = objectNode
+		}
+		for dec.More() {
+			var name string
+			if n.kind == objectNode {
+				key, err := dec.Token()
+				if err != nil {
+					return node{}, err
+				}
+				name = key.(string)
+			}
+			child, err := decodeNode(dec)
+			if err != nil {
+				return node{}, err
+			}
+			if n.kind == objectNode {
+				n.fields = append(n.fields, field{name, child})
+			} else {
+				n.items = append(n.items, child)
+			}
+		}
+		_, err = dec.Token()
+		return n, err
+	case string:
+		return node{kind: stringNode, value: t}, nil
+	case json.Number:
+		return node{kind: numberNode, value: t.String()}, nil
+	case bool:
+		return node{kind: boolNode, value: strconv.FormatBool(t)}, nil
+	}
+	return node{kind: nullNode}, nil
+}
+
+// encodeXML writes objects as elements named after their fields and array items as elements
+// named after the singular of the array, e.g. <users><user>...</user></users>
+func encodeXML(n node, root string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := writeXMLElement(enc, root, n); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeXMLElement(enc *xml.Encoder, name string, n node) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	switch n.kind {
+	case objectNode:
+		for _, f := range n.fields {
+			if err := writeXMLElement(enc, f.name, f.value); err != nil {
+				return err
+			}
+		}
+	case arrayNode:
+		item := "item"
+		if len(name) > 1 && strings.HasSuffix(name, "s") {
+			item = strings.TrimSuffix(name, "s")
+		}
+		for _, i := range n.items {
+			if err := writeXMLElement(enc, item, i); err != nil {
+				return err
+			}
+		}
+	case nullNode:
+	default:
+		if err := enc.EncodeToken(xml.CharData(n.value)); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// encodeCSV writes one row per object. Lists are taken from the top level array or from the only
+// array of objects inside the top level object, anything else is written as a single row.
+// Nested values are flattened into dotted column names, e.g. passports.0.id.
+func encodeCSV(n node, root string) ([]byte, error) {
+	rows := []node{n}
+	if n.kind == arrayNode {
+		rows = n.items
+	} else if n.kind == objectNode {
+		var lists []node
+		for _, f := range n.fields {
+			if f.value.kind == arrayNode && (len(f.value.items) == 0 || f.value.items[0].kind == objectNode) {
+				lists = append(lists, f.value)
+			}
+		}
+		if len(lists) == 1 {
+			rows = lists[0].items
+		}
+	}
+	var header []string
+	columns := make(map[string]int)
+	records := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		records[i] = make(map[string]string)
+		flatten("", row, func(key, value string) {
+			if _, ok := columns[key]; !ok {
+				columns[key] = len(header)
+				header = append(header, key)
+			}
+			records[i][key] = value
+		})
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	for _, r := range records {
+		line := make([]string, len(header))
+		for key, value := range r {
+			line[columns[key]] = csvCell(value)
+		}
+		w.Write(line)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func flatten(prefix string, n node, emit func(key, value string)) {
+	join := func(name string) string {
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+	switch n.kind {
+	case objectNode:
+		for _, f := range n.fields {
+			flatten(join(f.name), f.value, emit)
+		}
+	case arrayNode:
+		for i, item := range n.items {
+			flatten(join(strconv.Itoa(i)), item, emit)
+		}
+	default:
+		if prefix == "" {
+			prefix = "value"
+		}
+		emit(prefix, n.value)
+	}
+}
+
+// encodeYAML writes n as a block style YAML document
+func encodeYAML(n node, root string) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, line := range yamlLines(n) {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func yamlLines(n node) []string {
+	var lines []string
+	switch {
+	case n.kind == objectNode && len(n.fields) > 0:
+		for _, f := range n.fields {
+			if f.value.scalar() || len(f.value.fields)+len(f.value.items) == 0 {
+				lines = append(lines, yamlString(f.name)+": "+yamlLines(f.value)[0])
+				continue
+			}
+			lines = append(lines, yamlString(f.name)+":")
+			for _, l := range yamlLines(f.value) {
+				lines = append(lines, "  "+l)
+			}
+		}
+	case n.kind == arrayNode && len(n.items) > 0:
+		for _, item := range n.items {
+			for i, l := range yamlLines(item) {
+				if i == 0 {
+					lines = append(lines, "- "+l)
+				} else {
+					lines = append(lines, "  "+l)
+				}
+			}
+		}
+	case n.kind == objectNode:
+		lines = append(lines, "{}")
+	case n.kind == arrayNode:
+		lines = append(lines, "[]")
+	case n.kind == nullNode:
+		lines = append(lines, "null")
+	case n.kind == stringNode:
+		lines = append(lines, yamlString(n.value))
+	default:
+		lines = append(lines, n.value)
+	}
+	return lines
+}
+
+var (
+	versionSuffix = regexp.MustCompile(`V[0-9]+$`)
+	yamlPlain     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ .\-]*$`)
+	yamlReserved  = regexp.MustCompile(`^(?i:true|false|yes|no|on|off|y|n|null|~)$`)
+)
+
+// yamlString quotes a string unless it can safely be written as a plain scalar
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) && !yamlReserved.MatchString(s) && !strings.HasSuffix(s, " ") {
+		return s
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
@@ -0,0 +1,130 @@
+package svc
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                                  "application/json",
+		"*/*":                               "application/json",
+		"text/xml":                          "application/xml",
+		"text/csv;q=0.5, application/yaml":  "application/yaml",
+		"application/*":                     "application/json",
+		"text/*":                            "application/xml",
+		"text/html;q=1, text/csv;q=0.9":     "text/csv",
+		"application/json;q=0, text/x-yaml": "application/yaml",
+	}
+	for accept, expected := range cases {
+		rep, ok := negotiate(accept)
+		if assert.True(t, ok, accept) {
+			assert.Equal(t, expected, rep.contentType, accept)
+		}
+	}
+	_, ok := negotiate("text/html, image/png")
+	assert.False(t, ok, "they should not be acceptable")
+}
+
+func TestListUsersHandlerXML(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Accept", "application/xml")
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ListUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "application/xml; charset=UTF-8", w.Header().Get("Content-Type"), "they should be equal")
+	var doc struct {
+		XMLName xml.Name `xml:"users"`
+		Count   int      `xml:"count"`
+		Names   []string `xml:"users>user>firstName"`
+	}
+	assert.Nil(t, xml.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, 2, doc.Count, "they should be equal")
+	assert.Equal(t, []string{"John", "Jane"}, doc.Names, "they should be equal")
+}
+
+func TestListUsersHandlerCSV(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ListUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"id", "firstName", "lastName", "dateOfBirth", "locationOfBirth"}, rows[0], "they should be equal")
+	assert.Equal(t, 3, len(rows), "header and two users")
+	assert.Equal(t, "Milton Keynes", rows[2][4], "they should be equal")
+}
+
+func TestHealthHandlerYAML(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	req, _ := http.NewRequest("GET", "/health", nil)
+	req.Header.Set("Accept", "application/x-yaml")
+	w := httptest.NewRecorder()
+	makeHandler(ctx, HealthHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "svcName: go-rest-api-template\nversion: \"0.0.0\"\n", w.Body.String(), "they should be equal")
+}
+
+func TestEncodeYAMLNested(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Accept", "application/yaml")
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ListUsersHandler).ServeHTTP(w, req)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "count: 2\nusers:\n  - id: 0\n    firstName: John\n"), w.Body.String())
+}
+
+func TestRespondNotAcceptable(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	makeHandler(ctx, ListUsersHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code, "they should be equal")
+	assert.Equal(t, "application/json; charset=UTF-8", w.Header().Get("Content-Type"), "they should be equal")
+}
+
+func TestNotAcceptableBeforeHandler(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	serve := func(method, target, accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		NewHandler(ctx).ServeHTTP(w, req)
+		return w
+	}
+
+	// the user isn't created when its response can't be sent
+	w := serve("POST", "/users", "text/html", `{"firstName": "Apple", "lastName": "Jack"}`)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "supported types are application/json, application/xml, text/csv, application/yaml")
+	list, err := ctx.DB.ListUsers(t.Context())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(list), "they should be equal")
+
+	// routes producing other media types accept them
+	w = serve("GET", "/export", "application/x-ndjson", "")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	w = serve("GET", "/docs", "text/html", "")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	w = serve("GET", "/export", "image/png", "")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "application/x-ndjson")
+	w = serve("GET", "/users", "application/x-ndjson", "")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code, "they should be equal")
+}
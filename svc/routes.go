@@ -13,17 +13,47 @@ type Routes []Route
 
 var routes = Routes{
 	Route{"Health", "GET", "/health", HealthHandler},
+	Route{"Ready", "GET", "/ready", ReadyHandler},
+	Route{"OpenAPI", "GET", "/openapi.json", OpenAPIHandler},
+	Route{"Docs", "GET", "/docs", DocsHandler},
+	Route{"BatchUsers", "POST", "/users:batch", BatchUsersHandler},
+	Route{"Export", "GET", "/export", ExportHandler},
+	Route{"GraphQL", "GET", "/graphql", GraphQLHandler},
+	Route{"GraphQL", "POST", "/graphql", GraphQLHandler},
+	Route{"StreamEvents", "GET", "/events", StreamEventsHandler},
+	Route{"ListWebhooks", "GET", "/webhooks", ListWebhooksHandler},
+	Route{"CreateWebhook", "POST", "/webhooks", CreateWebhookHandler},
+	Route{"DeleteWebhook", "DELETE", "/webhooks/{wid}", DeleteWebhookHandler},
+	Route{"ListDeadLetters", "GET", "/webhooks/deadletters", ListDeadLettersHandler},
+}
+
+// versionedRoutes are served in every API version, under the prefix of the version, e.g.
+// /v2/users, and without a prefix in the version asked for by the Accept header
+var versionedRoutes = Routes{
 	Route{"ListUsers", "GET", "/", ListUsersHandler},
 	Route{"ListUsers", "GET", "/users", ListUsersHandler},
 	Route{"GetUser", "GET", "/users/{uid:[0-9]+}", GetUserHandler},
 	Route{"CreateUser", "POST", "/users", CreateUserHandler},
 	Route{"UpdateUser", "PUT", "/users/{uid:[0-9]+}", UpdateUserHandler},
 	Route{"DeleteUser", "DELETE", "/users/{uid:[0-9]+}", DeleteUserHandler},
+	Route{"GetUserPassport", "GET", "/users/{uid:[0-9]+}/passports", ListUserPassportsHandler},
+	Route{"ListPassports", "GET", "/passports", ListPassportsHandler},
+	Route{"GetPassport", "GET", "/passports/{pid}", GetPassportHandler},
+	Route{"CreateUserPassport", "POST", "/users/{uid:[0-9]+}/passports", CreateUserPassportHandler},
+	Route{"UpdatePassport", "PUT", "/passports/{pid}", UpdatePassportHandler},
+	Route{"DeletePassport", "DELETE", "/passports/{pid}", DeletePassportHandler},
+}
 
-	// Not implemented yet, defaulting to unimplemented PassportsHandler
-	Route{"GetUserPassport", "GET", "/users/{uid}/passports", PassportsHandler},
-	Route{"GetPassport", "GET", "/passports/{pid:[0-9]+}", PassportsHandler},
-	Route{"CreateUserPassport", "POST", "/users/{uid}/passports", PassportsHandler},
-	Route{"UpdatePassport", "PUT", "/passports/{pid:[0-9]+}", PassportsHandler},
-	Route{"DeletePassport", "DELETE", "/passports/{pid:[0-9]+}", PassportsHandler},
+// allRoutes returns every route served: the unversioned ones, then versionedRoutes without a
+// prefix and under the prefix of each API version
+func allRoutes() Routes {
+	all := append(Routes{}, routes...)
+	all = append(all, versionedRoutes...)
+	for _, v := range apiVersions {
+		for _, route := range versionedRoutes {
+			route.Pattern = v.prefix() + route.Pattern
+			all = append(all, route)
+		}
+	}
+	return all
 }
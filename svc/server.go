@@ -1,46 +1,197 @@
 package svc
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
 	"github.com/unrolled/secure"
 )
 
-// Run wraps the mux Router and uses the Negroni Middleware
-func Run(ctx Context) {
+// NewRouter returns the mux Router serving the routes of the service. Requests are checked against
+// the OpenAPI document of the routes, and so are responses in the LOCAL environment. Requests
+// accepting none of the media types of their route are refused before anything else. It panics if
+// the routes and their OpenAPI docs don't match, which the tests catch.
+func NewRouter(ctx Context) *mux.Router {
+	doc, err := buildOpenAPI(ctx.Version, allRoutes())
+	if err != nil {
+		panic(err)
+	}
+	if ctx.openAPI, err = json.MarshalIndent(doc, "", "  "); err != nil {
+		panic(err)
+	}
+	c := conformance{schemas: doc.Components.Schemas, responses: ctx.Env == Local}
+	// validated returns the handler of a route, checked against the docs of its pattern
+	validated := func(route Route, pattern string) http.Handler {
+		path, _ := openAPIPath(pattern)
+		op := doc.Paths[path][strings.ToLower(route.Method)]
+		return c.validated(ctx, op, makeHandler(ctx, route.HandlerFunc))
+	}
 	router := mux.NewRouter().StrictSlash(true)
-	for _, route := range routes {
-		var handler http.Handler
-		handler = makeHandler(ctx, route.HandlerFunc)
+	add := func(route Route, handler http.Handler) {
+		size := maxBodySizeFor(ctx, route.Name)
+		handler = limitBody(ctx, size, decompressBody(ctx, gzipBodies[route.Name], size, handler))
+		handler = withAcceptable(ctx, route.Name, handler)
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
 			Name(route.Name).
-			Handler(handler)
+			Handler(withTimeout(timeoutFor(ctx, route.Name), handler))
+	}
+	for _, route := range routes {
+		add(route, validated(route, route.Pattern))
+	}
+	for _, route := range versionedRoutes {
+		handlers := make(map[*apiVersion]http.Handler)
+		for _, v := range apiVersions {
+			handlers[v] = validated(route, v.prefix()+route.Pattern)
+		}
+		add(route, negotiateVersion(ctx, handlers))
+	}
+	for _, v := range apiVersions {
+		for _, route := range versionedRoutes {
+			route.Pattern = v.prefix() + route.Pattern
+			add(route, withVersion(ctx, v, validated(route, route.Pattern)))
+		}
 	}
+	// preflight, and plain OPTIONS, requests are answered for every path
+	for pattern, methods := range routeMethods(allRoutes()) {
+		router.Methods("OPTIONS").Path(pattern).Handler(preflight(ctx, methods))
+	}
+	router.NotFoundHandler = notFound(ctx)
+	router.MethodNotAllowedHandler = methodNotAllowed(ctx, router)
+	return router
+}
+
+// NewHandler returns the service as an http.Handler: the router of NewRouter behind the Negroni
+// middleware, the CORS policy and response compression. Run serves it, tests can serve it with
+// httptest.
+func NewHandler(ctx Context) http.Handler {
 	// security
 	var isDevelopment = false
 	if ctx.Env == Local {
 		isDevelopment = true
 	}
+	var stsSeconds int64
+	if ctx.TLS.enabled() {
+		stsSeconds = 365 * 24 * 60 * 60
+	}
+	sslHost := secure.SSLHostFunc(httpsHost(ctx))
 	secureMiddleware := secure.New(secure.Options{
-		IsDevelopment:      isDevelopment, // This will cause the AllowedHosts, SSLRedirect, and STSSeconds/STSIncludeSubdomains options to be ignored during development. When deploying to production, be sure to set this to false.
-		AllowedHosts:       []string{},    // AllowedHosts is a list of fully qualified domain names that are allowed (CORS)
-		ContentTypeNosniff: true,          // If ContentTypeNosniff is true, adds the X-Content-Type-Options header with the value `nosniff`. Default is false.
-		BrowserXssFilter:   true,          // If BrowserXssFilter is true, adds the X-XSS-Protection header with the value `1; mode=block`. Default is false.
+		IsDevelopment:      isDevelopment,                                   // This will cause the AllowedHosts, SSLRedirect, and STSSeconds/STSIncludeSubdomains options to be ignored during development. When deploying to production, be sure to set this to false.
+		AllowedHosts:       []string{},                                      // AllowedHosts is a list of fully qualified domain names that are allowed. This isn't CORS, see ctx.CORS.
+		SSLRedirect:        ctx.TLS.enabled(),                               // If SSLRedirect is true, plain HTTP requests are redirected to HTTPS.
+		SSLHostFunc:        &sslHost,                                        // SSLHostFunc redirects to the HTTPS port of the same host.
+		SSLProxyHeaders:    map[string]string{"X-Forwarded-Proto": "https"}, // SSLProxyHeaders mark the requests a proxy received over HTTPS, only kept from ctx.TLS.TrustedProxies.
+		STSSeconds:         stsSeconds,                                      // STSSeconds is the max-age of the Strict-Transport-Security header, which is only sent with TLS.
+		ContentTypeNosniff: true,                                            // If ContentTypeNosniff is true, adds the X-Content-Type-Options header with the value `nosniff`. Default is false.
+		BrowserXssFilter:   true,                                            // If BrowserXssFilter is true, adds the X-XSS-Protection header with the value `1; mode=block`. Default is false.
 	})
-	// start now
 	n := negroni.New()
 	n.Use(negroni.NewLogger())
+	n.Use(withTrustedProxies(ctx))
 	n.Use(negroni.HandlerFunc(secureMiddleware.HandlerFuncWithNext))
-	n.UseHandler(router)
-	log.Println("===> Starting service (v" + ctx.Version + ") on port " + ctx.Port + " in " + ctx.Env + " mode.")
+	n.UseHandler(withCompression(ctx, withCORS(ctx, NewRouter(ctx))))
+	return n
+}
+
+// shutdownTimeout bounds how long Run waits for the requests in flight when it is stopped
+var shutdownTimeout = 10 * time.Second
+
+// Run serves the handler of NewHandler on the port of the context, over HTTPS if the context has
+// a certificate. The redirect port then serves plain HTTP, only redirecting to HTTPS.
+// It serves until stop is closed, then shuts the servers down, letting the requests in flight
+// finish, or until a server fails.
+func Run(ctx Context, stop <-chan struct{}) error {
+	handler := NewHandler(ctx)
+	host := ""
 	if ctx.Env == Local {
-		n.Run("localhost:" + ctx.Port)
-	} else {
-		n.Run(":" + ctx.Port)
+		host = "localhost"
 	}
+	log.Println("===> Starting service (v" + ctx.Version + ") on port " + ctx.Port + " in " + ctx.Env + " mode.")
+	server := &http.Server{Addr: host + ":" + ctx.Port, Handler: handler}
+	servers := []shutdowner{server}
+	listen := []func() error{server.ListenAndServe}
+	if ctx.TLS.enabled() {
+		config, err := ctx.TLS.config()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = config
+		listen[0] = func() error { return server.ListenAndServeTLS("", "") }
+		if ctx.TLS.RedirectPort != "" {
+			redirect := &http.Server{Addr: host + ":" + ctx.TLS.RedirectPort, Handler: redirectHandler(ctx)}
+			servers = append(servers, redirect)
+			listen = append(listen, redirect.ListenAndServe)
+		}
+	}
+	return serveUntil(stop, servers, listen)
+}
+
+// shutdowner is a server that shuts down gracefully, as http.Server does
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// serveUntil runs the listen functions of servers until stop is closed or one of them fails, then
+// shuts all of them down
+func serveUntil(stop <-chan struct{}, servers []shutdowner, listen []func() error) error {
+	errs := make(chan error, len(listen))
+	for _, l := range listen {
+		go func(l func() error) { errs <- l() }(l)
+	}
+	var err error
+	select {
+	case err = <-errs:
+	case <-stop:
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+			log.Println(shutdownErr)
+		}
+	}
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
+
+// notFound answers requests for paths the router doesn't serve
+func notFound(ctx Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		response := status{
+			Status:  "404",
+			Message: "no route for " + req.URL.Path,
+		}
+		respond(w, req, ctx, http.StatusNotFound, response)
+	})
+}
+
+// methodNotAllowed answers requests for served paths with another method, listing the methods
+// the path is served with in the Allow header
+func methodNotAllowed(ctx Context, router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var allowed []string
+		for _, method := range []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"} {
+			probe := req.Clone(req.Context())
+			probe.Method = method
+			var match mux.RouteMatch
+			if router.Match(probe, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		response := status{
+			Status:  "405",
+			Message: "method " + req.Method + " not allowed, use " + strings.Join(allowed, ", "),
+		}
+		respond(w, req, ctx, http.StatusMethodNotAllowed, response)
+	})
 }
@@ -0,0 +1,86 @@
+package svc
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+	"github.com/palantir/stacktrace"
+)
+
+// TenantStorager hands out the storage of the tenants other than the default one, whose storage
+// is Context.DB
+type TenantStorager interface {
+	// ForTenant returns the storage of a tenant, false if the tenant is unknown
+	ForTenant(id string) (Storager, bool)
+}
+
+// Layering builds the storage a tenant is served from out of its database, e.g. by putting it
+// behind the domain service and a cache
+type Layering func(tenant string, db domain.Store) Storager
+
+// tenantDBs serves the database of every tenant through its layers
+type tenantDBs struct {
+	mu       sync.Mutex
+	tenants  *storage.Tenants
+	layer    Layering
+	services map[string]Storager
+}
+
+// NewTenantDBs returns the storage of the tenants kept in t, built by layer
+func NewTenantDBs(t *storage.Tenants, layer Layering) TenantStorager {
+	return &tenantDBs{tenants: t, layer: layer, services: make(map[string]Storager)}
+}
+
+func (t *tenantDBs) ForTenant(id string) (Storager, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.services[id]; ok {
+		return s, true
+	}
+	db, ok := t.tenants.For(id)
+	if !ok {
+		return nil, false
+	}
+	s := t.layer(id, db)
+	t.services[id] = s
+	return s, true
+}
+
+// scope resolves the tenant of a request and returns the request carrying it, and the identity of
+// its client certificate if any, along with the context holding the tenant's storage
+func scope(ctx Context, req *http.Request) (Context, *http.Request, error) {
+	id, err := ctx.TenantResolver.Resolve(req)
+	if err != nil {
+		return ctx, req, err
+	}
+	if id != tenant.Default {
+		var db Storager
+		ok := false
+		if ctx.Tenants != nil {
+			db, ok = ctx.Tenants.ForTenant(id)
+		}
+		if !ok {
+			return ctx, req, stacktrace.NewErrorWithCode(tenant.EcodeUnknown, "unknown tenant %s", id)
+		}
+		ctx.DB = db
+	}
+	reqCtx := tenant.NewContext(req.Context(), id)
+	if identity, ok := tenant.ClientIdentity(req); ok {
+		reqCtx = tenant.NewIdentityContext(reqCtx, identity)
+	}
+	return ctx, req.WithContext(reqCtx), nil
+}
+
+// tenantStatus is the HTTP status of a request whose tenant can't be resolved
+func tenantStatus(err error) int {
+	switch stacktrace.GetCode(err) {
+	case tenant.EcodeInvalidToken, tenant.EcodeUnverified:
+		return http.StatusUnauthorized
+	case tenant.EcodeUnknown:
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
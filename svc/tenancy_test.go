@@ -0,0 +1,72 @@
+package svc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func tenantRequest(ctx Context, fn HandlerFunc, method, url, id, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if id != "" {
+		req.Header.Set(tenant.Header, id)
+	}
+	w := httptest.NewRecorder()
+	makeHandler(ctx, fn).ServeHTTP(w, req)
+	return w
+}
+
+func TestTenantsAreIsolated(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	w := tenantRequest(ctx, CreateUserHandler, "POST", "/users", "acme", `{"firstName": "Apple", "lastName": "Jack"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, "they should be equal")
+	var u entities.User
+	json.Unmarshal(w.Body.Bytes(), &u)
+	assert.Equal(t, 0, u.ID, "ids are allocated per tenant")
+
+	var res struct {
+		Users []entities.User `json:"users"`
+	}
+	w = tenantRequest(ctx, ListUsersHandler, "GET", "/users", "acme", "")
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, []entities.User{u}, res.Users, "they should be equal")
+	w = tenantRequest(ctx, ListUsersHandler, "GET", "/users", "", "")
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "John", res.Users[0].FirstName, "the default tenant keeps its users")
+
+	w = tenantRequest(ctx, ListWebhooksHandler, "GET", "/webhooks", "Not A Tenant", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+}
+
+func TestUnknownAndUnverifiedTenants(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	w := tenantRequest(ctx, ListUsersHandler, "GET", "/users", "globex", "")
+	assert.Equal(t, http.StatusForbidden, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), "unknown tenant globex")
+
+	ctx.TenantResolver.Verified = true
+	w = tenantRequest(ctx, ListUsersHandler, "GET", "/users", "acme", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "they should be equal")
+	w = tenantRequest(ctx, ListUsersHandler, "GET", "/users", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+}
+
+func TestWebhooksAreScopedToTheirTenant(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	w := tenantRequest(ctx, CreateWebhookHandler, "POST", "/webhooks", "acme", `{"url": "https://example.com/hook"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, "they should be equal")
+
+	w = tenantRequest(ctx, ListWebhooksHandler, "GET", "/webhooks", "", "")
+	assert.Contains(t, w.Body.String(), `"count":0`, "they should be equal")
+	w = tenantRequest(ctx, ListWebhooksHandler, "GET", "/webhooks", "acme", "")
+	assert.Contains(t, w.Body.String(), `"count":1`, "they should be equal")
+}
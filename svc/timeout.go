@@ -0,0 +1,64 @@
+package svc
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRequestTimeout bounds the handling of a request when the context doesn't set a timeout
+const DefaultRequestTimeout = 10 * time.Second
+
+// routeTimeouts overrides the request timeout of the routes, and of the gRPC methods of the same
+// name, that take longer by design. Zero means no timeout at all.
+var routeTimeouts = map[string]time.Duration{
+	"BatchUsers":   time.Minute,
+	"Export":       time.Minute,
+	"StreamEvents": 0,
+}
+
+// timeoutFor returns how long a route may take
+func timeoutFor(ctx Context, name string) time.Duration {
+	if timeout, ok := routeTimeouts[name]; ok {
+		return timeout
+	}
+	if ctx.RequestTimeout > 0 {
+		return ctx.RequestTimeout
+	}
+	return DefaultRequestTimeout
+}
+
+// withTimeout gives the requests served by h a deadline. The deadline is carried by the request's
+// context down to the storage, which gives up once it has passed; see timedOut for the response.
+func withTimeout(timeout time.Duration, h http.Handler) http.Handler {
+	if timeout <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reqCtx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		h.ServeHTTP(w, req.WithContext(reqCtx))
+	})
+}
+
+// timedOut replaces the failure response of a request that was cancelled or ran out of time, as
+// the failure is then most likely a consequence of that rather than of the request itself
+func timedOut(req *http.Request, code int, v interface{}) (int, interface{}) {
+	if code < 400 {
+		return code, v
+	}
+	switch req.Context().Err() {
+	case context.DeadlineExceeded:
+		return http.StatusServiceUnavailable, status{
+			Status:  strconv.Itoa(http.StatusServiceUnavailable),
+			Message: "request timed out",
+		}
+	case context.Canceled:
+		return http.StatusServiceUnavailable, status{
+			Status:  strconv.Itoa(http.StatusServiceUnavailable),
+			Message: "request cancelled",
+		}
+	}
+	return code, v
+}
@@ -0,0 +1,64 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/kostiamol/go-rest-api-template/proto/userspb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestRequestTimedOut(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	reqCtx, cancel := context.WithDeadline(t.Context(), time.Now().Add(-time.Second))
+	defer cancel()
+	req, _ := http.NewRequestWithContext(reqCtx, "GET", "/users/0", nil)
+	req = mux.SetURLVars(req, map[string]string{"uid": "0"})
+	w := httptest.NewRecorder()
+	makeHandler(ctx, GetUserHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "they should be equal")
+	var res status
+	json.Unmarshal(w.Body.Bytes(), &res)
+	assert.Equal(t, "request timed out", res.Message, "they should be equal")
+}
+
+func TestWithTimeout(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		deadline, ok = req.Context().Deadline()
+	})
+	req, _ := http.NewRequest("GET", "/users", nil)
+	withTimeout(time.Minute, h).ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, ok, "the request has a deadline")
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second, "they should be equal")
+
+	withTimeout(0, h).ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, ok, "zero means no deadline")
+
+	ctx := NewContext()
+	defer ctx.Close()
+	assert.Equal(t, DefaultRequestTimeout, timeoutFor(ctx, "GetUser"), "they should be equal")
+	assert.Equal(t, time.Duration(0), timeoutFor(ctx, "StreamEvents"), "they should be equal")
+	ctx.RequestTimeout = time.Second
+	assert.Equal(t, time.Second, timeoutFor(ctx, "GetUser"), "they should be equal")
+	assert.Equal(t, time.Minute, timeoutFor(ctx, "Export"), "they should be equal")
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	ctx := NewContext()
+	ctx.RequestTimeout = time.Nanosecond
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, ctx))
+	_, err := c.ListUsers(t.Context(), &emptypb.Empty{})
+	assert.Equal(t, codes.DeadlineExceeded, grpcstatus.Code(err), "they should be equal")
+	assert.Equal(t, "deadline exceeded", grpcstatus.Convert(err).Message(), "they should be equal")
+}
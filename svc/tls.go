@@ -0,0 +1,201 @@
+package svc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/palantir/stacktrace"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+var certCheckInterval = time.Second
+
+// TLS configures HTTPS serving. The service serves plain HTTP without a certificate.
+type TLS struct {
+	// PEM files of the certificate, chain included, and of its key; they are reloaded when they change
+	CertFile string
+	KeyFile  string
+	// PEM file of the CAs verifying client certificates; they aren't asked for if empty
+	ClientCAFile string
+	// Whether connections without a verified client certificate are refused
+	RequireClientCert bool
+	// Port serving plain HTTP that only redirects to HTTPS; not served if empty
+	RedirectPort string
+	// Addresses of the proxies whose X-Forwarded-Proto header is trusted; others' is ignored
+	TrustedProxies []netip.Prefix
+}
+
+// enabled reports whether the service serves HTTPS
+func (t TLS) enabled() bool {
+	return t.CertFile != ""
+}
+
+// config returns the configuration of the HTTPS server
+func (t TLS) config() (*tls.Config, error) {
+	cert, err := loadCertificate(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cert.get,
+	}
+	if t.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, stacktrace.Propagate(err, "error reading client CA file")
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, stacktrace.NewError("no certificates in client CA file %s", t.ClientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if t.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if t.RequireClientCert {
+		return nil, stacktrace.NewError("client certificates can't be required without a client CA file")
+	}
+	return config, nil
+}
+
+// trustsProxy reports whether a request comes from a trusted proxy
+func (t TLS) trustsProxy(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, p := range t.TrustedProxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTrustedProxies drops the X-Forwarded-Proto header of requests that don't come from a
+// trusted proxy, so that clients can't pass plain HTTP requests off as HTTPS ones
+func withTrustedProxies(ctx Context) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		if !ctx.TLS.trustsProxy(req) {
+			req.Header.Del("X-Forwarded-Proto")
+		}
+		next(w, req)
+	}
+}
+
+// redirectHandler answers every request with a redirection to the same URL over HTTPS; it is all
+// the redirect port serves
+func redirectHandler(ctx Context) http.Handler {
+	host := httpsHost(ctx)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		code := http.StatusMovedPermanently
+		if req.Method != "GET" && req.Method != "HEAD" {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, req, "https://"+host(req.Host)+req.URL.RequestURI(), code)
+	})
+}
+
+// httpsHost returns the host HTTP requests for host are redirected to: the same name on the
+// HTTPS port
+func httpsHost(ctx Context) func(host string) string {
+	return func(host string) string {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ctx.Port == "443" {
+			return host
+		}
+		return net.JoinHostPort(host, ctx.Port)
+	}
+}
+
+// certificate serves a certificate from files, reloading it when the files change so that renewed
+// certificates are picked up without a restart
+type certificate struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+	checked  time.Time
+}
+
+// loadCertificate loads a certificate and its key
+func loadCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	modified, err := c.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modified); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// modTime returns when either file last changed
+func (c *certificate) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, stacktrace.Propagate(err, "error reading certificate")
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certificate) load(modified time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return stacktrace.Propagate(err, "error loading certificate")
+	}
+	c.cert = &cert
+	c.modified = modified
+	c.checked = time.Now()
+	return nil
+}
+
+// get returns the certificate, reloading it first if its files changed. A certificate that can't
+// be reloaded, e.g. while it is half written, is logged and the previous one kept.
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = time.Now()
+	modified, err := c.modTime()
+	if err != nil {
+		log.Println(err)
+		return c.cert, nil
+	}
+	if modified.Equal(c.modified) {
+		return c.cert, nil
+	}
+	if err := c.load(modified); err != nil {
+		log.Println(err)
+		return c.cert, nil
+	}
+	log.Println("reloaded certificate " + c.certFile)
+	return c.cert, nil
+}
@@ -0,0 +1,296 @@
+package svc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/proto/userspb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// testCert is a certificate and its key, signed by itself or a CA
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate from template, signed by ca or by itself if ca is nil
+func newTestCert(t *testing.T, template *x509.Certificate, ca *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// testPKI is a CA with a server certificate for localhost and a client certificate of the acme tenant
+type testPKI struct {
+	ca, server, client *testCert
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	ca := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	return testPKI{
+		ca: ca,
+		server: newTestCert(t, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			DNSNames:     []string{"localhost"},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca),
+		client: newTestCert(t, &x509.Certificate{
+			SerialNumber: big.NewInt(3),
+			Subject:      pkix.Name{CommonName: "billing", Organization: []string{"acme"}},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca),
+	}
+}
+
+// writeTestCert writes a certificate and its key to dir
+func writeTestCert(t *testing.T, dir string, c *testCert) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, c.certPEM, 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, c.keyPEM, 0600))
+	return certFile, keyFile
+}
+
+func TestCertificateReload(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, pki.server)
+	c, err := loadCertificate(certFile, keyFile)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	cert, _ := c.get(nil)
+	assert.Equal(t, pki.server.certPEM, pemOf(cert), "they should be equal")
+
+	// a renewed certificate is served once its files change
+	renewed := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(4), Subject: pkix.Name{CommonName: "localhost"}}, pki.ca)
+	writeTestCert(t, dir, renewed)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	cert, _ = c.get(nil)
+	assert.Equal(t, pki.server.certPEM, pemOf(cert), "files aren't checked more than once per interval")
+	c.checked = time.Time{}
+	cert, _ = c.get(nil)
+	assert.Equal(t, renewed.certPEM, pemOf(cert), "they should be equal")
+
+	// a broken certificate is ignored
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte("half written"), 0600))
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	c.checked = time.Time{}
+	cert, err = c.get(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, renewed.certPEM, pemOf(cert), "they should be equal")
+
+	_, err = loadCertificate(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.NotNil(t, err)
+}
+
+func pemOf(cert *tls.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+}
+
+func TestMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, pki.server)
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(caFile, pki.ca.certPEM, 0600))
+
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.TLS = TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}
+	config, err := ctx.TLS.config()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	srv := httptest.NewUnstartedServer(NewHandler(ctx))
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+
+	// the server is asked for localhost, as the certificate httptest adds is served to clients not
+	// naming a server
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+			ServerName:   "localhost",
+		}}}
+	}
+	clientCert := tls.Certificate{Certificate: [][]byte{pki.client.cert.Raw}, PrivateKey: pki.client.key}
+
+	// the client certificate names the tenant, whose storage is empty
+	res, err := client(clientCert).Get(srv.URL + "/users")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode, "they should be equal")
+	assert.Contains(t, string(body), `"count":0`)
+	assert.Equal(t, "", res.Header.Get("Strict-Transport-Security"), "STS isn't sent in LOCAL")
+
+	// tenants named otherwise must agree with the certificate
+	req, _ := http.NewRequest("GET", srv.URL+"/users", nil)
+	req.Header.Set("X-Tenant-ID", "globex")
+	res, err = client(clientCert).Do(req)
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, "they should be equal")
+	}
+
+	// without a certificate the request is served for the default tenant
+	res, err = client().Get(srv.URL + "/users")
+	if assert.Nil(t, err) {
+		body, _ = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Contains(t, string(body), `"count":2`)
+	}
+
+	// unless certificates are required
+	ctx.TLS.RequireClientCert = true
+	config, err = ctx.TLS.config()
+	assert.Nil(t, err)
+	strict := httptest.NewUnstartedServer(NewHandler(ctx))
+	strict.TLS = config
+	strict.StartTLS()
+	defer strict.Close()
+	_, err = client().Get(strict.URL + "/users")
+	assert.NotNil(t, err)
+	res, err = client(clientCert).Get(strict.URL + "/users")
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode, "they should be equal")
+	}
+
+	_, err = TLS{CertFile: certFile, KeyFile: keyFile, RequireClientCert: true}.config()
+	assert.NotNil(t, err)
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.Env = Prod
+	ctx.Port = "8443"
+	ctx.TLS = TLS{CertFile: "cert.pem", KeyFile: "key.pem"}
+	serve := func(h http.Handler, method, proto string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://api.example.com:8080/users?x=1", nil)
+		if proto != "" {
+			req.Header.Set("X-Forwarded-Proto", proto)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(NewHandler(ctx), "GET", "")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code, "they should be equal")
+	assert.Equal(t, "https://api.example.com:8443/users?x=1", w.Header().Get("Location"), "they should be equal")
+
+	// the proxy header is ignored unless it comes from a trusted proxy
+	w = serve(NewHandler(ctx), "GET", "https")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code, "they should be equal")
+	ctx.TLS.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	w = serve(NewHandler(ctx), "GET", "https")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, "max-age=31536000", w.Header().Get("Strict-Transport-Security"), "they should be equal")
+
+	// the redirect port only redirects, whatever the request says
+	for method, code := range map[string]int{"GET": http.StatusMovedPermanently, "POST": http.StatusPermanentRedirect} {
+		w = serve(redirectHandler(ctx), method, "https")
+		assert.Equal(t, code, w.Code, "they should be equal")
+		assert.Equal(t, "https://api.example.com:8443/users?x=1", w.Header().Get("Location"), "they should be equal")
+	}
+
+	// requests aren't redirected without TLS, or in LOCAL
+	ctx.TLS = TLS{}
+	w = serve(NewHandler(ctx), "GET", "")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	ctx.TLS = TLS{CertFile: "cert.pem", KeyFile: "key.pem"}
+	ctx.Env = Local
+	w = httptest.NewRecorder()
+	NewHandler(ctx).ServeHTTP(w, httptest.NewRequest("GET", "http://localhost:3001/users", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+}
+
+func TestGRPCOverTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, pki.server)
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(caFile, pki.ca.certPEM, 0600))
+	ctx := NewContext()
+	ctx.TLS = TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}
+
+	// plaintext clients aren't served
+	plain := userspb.NewUserServiceClient(newGRPCTestConn(t, ctx))
+	_, err := plain.Health(t.Context(), &emptypb.Empty{})
+	assert.Equal(t, codes.Unavailable, grpcstatus.Code(err), "they should be equal")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.ca.cert)
+	creds := credentials.NewTLS(&tls.Config{
+		RootCAs:      roots,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{{Certificate: [][]byte{pki.client.cert.Raw}, PrivateKey: pki.client.key}},
+	})
+	c := userspb.NewUserServiceClient(newGRPCTestConn(t, ctx, grpc.WithTransportCredentials(creds)))
+	_, err = c.Health(t.Context(), &emptypb.Empty{})
+	assert.Nil(t, err)
+
+	// the tenant is taken from the client certificate
+	list, err := c.ListUsers(t.Context(), &emptypb.Empty{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, int32(0), list.GetCount(), "they should be equal")
+	}
+}
@@ -0,0 +1,86 @@
+package svc
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kostiamol/go-rest-api-template/domain"
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/kostiamol/go-rest-api-template/storage"
+	"github.com/palantir/stacktrace"
+)
+
+// validateUser checks that a user carries the fields required to store it
+func validateUser(u entities.User) error {
+	if strings.TrimSpace(u.FirstName) == "" {
+		return stacktrace.NewError("firstName is required")
+	}
+	if strings.TrimSpace(u.LastName) == "" {
+		return stacktrace.NewError("lastName is required")
+	}
+	return nil
+}
+
+// validatePassport checks that a passport carries the fields required to store it
+func validatePassport(p entities.Passport) error {
+	if strings.TrimSpace(p.ID) == "" {
+		return stacktrace.NewError("passport id is required")
+	}
+	if strings.TrimSpace(p.Authority) == "" {
+		return stacktrace.NewError("passport authority is required")
+	}
+	if !p.DateOfExpiry.IsZero() && p.DateOfExpiry.Before(p.DateOfIssue) {
+		return stacktrace.NewError("passport dateOfExpiry is before dateOfIssue")
+	}
+	return nil
+}
+
+// ruleStatus maps an error of the domain service to an HTTP status, a failure of the storage to 503
+func ruleStatus(err error) int {
+	if storage.Unavailable(err) {
+		return http.StatusServiceUnavailable
+	}
+	switch domain.KindOf(err) {
+	case domain.NotFound:
+		return http.StatusNotFound
+	case domain.Conflict:
+		return http.StatusConflict
+	case domain.Invalid:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// ruleFailed responds with the status of an error of the domain service
+func ruleFailed(w http.ResponseWriter, req *http.Request, ctx Context, err error) {
+	if unavailable(w, req, ctx, err) {
+		return
+	}
+	code := ruleStatus(err)
+	response := status{
+		Status:  strconv.Itoa(code),
+		Message: errorMessage(err),
+	}
+	if code == http.StatusInternalServerError {
+		log.Println(err)
+		response.Message = "something went wrong"
+	}
+	respond(w, req, ctx, code, response)
+}
+
+// unavailable responds 503 to a failure of the storage itself, rather than of the request, and
+// reports whether it did
+func unavailable(w http.ResponseWriter, req *http.Request, ctx Context, err error) bool {
+	if !storage.Unavailable(err) {
+		return false
+	}
+	log.Println(err)
+	response := status{
+		Status:  "503",
+		Message: "storage is unavailable, try again later",
+	}
+	respond(w, req, ctx, http.StatusServiceUnavailable, response)
+	return true
+}
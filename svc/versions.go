@@ -0,0 +1,253 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+)
+
+// apiVersion is a version of the representation of users. The routes of versionedRoutes are
+// served in every version by the same handlers, which map users through the version.
+type apiVersion struct {
+	// name of the version, and its URL prefix without the slash
+	name string
+	// deprecated versions announce their successor, and their sunset, in their responses
+	deprecated bool
+	// user is a value of the representation of users, for the OpenAPI document
+	user interface{}
+	// required are the fields of the representation required to create a user
+	required []string
+	// encodeUser maps a user to its representation
+	encodeUser func(u entities.User) interface{}
+	// decodeUser reads a user in its representation
+	decodeUser func(d *json.Decoder) (entities.User, error)
+}
+
+// prefix is the URL prefix of the routes of the version
+func (v *apiVersion) prefix() string {
+	return "/" + v.name
+}
+
+// mediaType is the media type asking for the version in the Accept header
+func (v *apiVersion) mediaType() string {
+	return "application/vnd.go-rest-api-template." + v.name + "+json"
+}
+
+// userV2 is the representation of a user in version 2 of the API
+// swagger:response userV2
+type userV2 struct {
+	// UID
+	ID int `json:"id"`
+	// Name
+	Name personName `json:"name"`
+	// Birth
+	Birth birth `json:"birth"`
+}
+
+// personName is the name of a user in version 2 of the API
+type personName struct {
+	// First name
+	First string `json:"first"`
+	// Last name
+	Last string `json:"last"`
+}
+
+// birth is the birth of a user in version 2 of the API
+type birth struct {
+	// Date of birth
+	Date time.Time `json:"date"`
+	// Location of birth
+	Location string `json:"location"`
+}
+
+var (
+	v1 = &apiVersion{
+		name:       "v1",
+		deprecated: true,
+		user:       entities.User{},
+		required:   []string{"firstName", "lastName"},
+		encodeUser: func(u entities.User) interface{} { return u },
+		decodeUser: func(d *json.Decoder) (entities.User, error) {
+			var u entities.User
+			err := d.Decode(&u)
+			return u, err
+		},
+	}
+	v2 = &apiVersion{
+		name:     "v2",
+		user:     userV2{},
+		required: []string{"name"},
+		encodeUser: func(u entities.User) interface{} {
+			return userV2{
+				ID:    u.ID,
+				Name:  personName{First: u.FirstName, Last: u.LastName},
+				Birth: birth{Date: u.DateOfBirth, Location: u.LocationOfBirth},
+			}
+		},
+		decodeUser: func(d *json.Decoder) (entities.User, error) {
+			var u userV2
+			err := d.Decode(&u)
+			return entities.User{
+				ID:              u.ID,
+				FirstName:       u.Name.First,
+				LastName:        u.Name.Last,
+				DateOfBirth:     u.Birth.Date,
+				LocationOfBirth: u.Birth.Location,
+			}, err
+		},
+	}
+)
+
+// apiVersions are the versions of the API, oldest first. Requests that don't ask for a version
+// get the first one, so that clients written before versioning keep working.
+var apiVersions = []*apiVersion{v1, v2}
+
+// versionMediaType matches the media types asking for a version of the API
+var versionMediaType = regexp.MustCompile(`^application/vnd\.go-rest-api-template\.(v[0-9]+)\+json$`)
+
+type versionKey struct{}
+
+// versionOf returns the API version a request is served in
+func versionOf(req *http.Request) *apiVersion {
+	if v, ok := req.Context().Value(versionKey{}).(*apiVersion); ok {
+		return v
+	}
+	return apiVersions[0]
+}
+
+// splitVersion splits the URL prefix of an API version off a route pattern. The version is nil
+// if the pattern has none.
+func splitVersion(pattern string) (*apiVersion, string) {
+	for _, v := range apiVersions {
+		if strings.HasPrefix(pattern, v.prefix()+"/") {
+			return v, strings.TrimPrefix(pattern, v.prefix())
+		}
+	}
+	return nil, pattern
+}
+
+// requestedVersion returns the version asked for by the Accept header, the first one if it asks
+// for none. It fails if the header only asks for versions that don't exist.
+func requestedVersion(accept string) (*apiVersion, bool) {
+	asked := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.Split(part, ";")[0]))
+		m := versionMediaType.FindStringSubmatch(mediaType)
+		if m == nil {
+			continue
+		}
+		asked = true
+		for _, v := range apiVersions {
+			if v.name == m[1] {
+				return v, true
+			}
+		}
+	}
+	return apiVersions[0], !asked
+}
+
+// withVersion serves the requests of h in version v
+func withVersion(ctx Context, v *apiVersion, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		serveVersion(w, req, ctx, v, h)
+	})
+}
+
+// negotiateVersion serves requests with the handler of the version asked for by their Accept
+// header, answering 406 if that version doesn't exist
+func negotiateVersion(ctx Context, handlers map[*apiVersion]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		v, ok := requestedVersion(req.Header.Get("Accept"))
+		if !ok {
+			types := make([]string, len(apiVersions))
+			for i, v := range apiVersions {
+				types[i] = v.mediaType()
+			}
+			response := status{
+				Status:  "406",
+				Message: "not acceptable, supported versions are " + strings.Join(types, ", "),
+			}
+			ctx.Render.JSON(w, http.StatusNotAcceptable, response)
+			return
+		}
+		serveVersion(w, req, ctx, v, handlers[v])
+	})
+}
+
+// serveVersion serves a request in version v. The responses of deprecated versions point to the
+// same resource in the latest version, and tell when the deprecated one goes away.
+func serveVersion(w http.ResponseWriter, req *http.Request, ctx Context, v *apiVersion, h http.Handler) {
+	if v.deprecated {
+		latest := apiVersions[len(apiVersions)-1]
+		_, path := splitVersion(req.URL.Path)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+latest.prefix()+path+`>; rel="successor-version"`)
+		if !ctx.V1Sunset.IsZero() {
+			w.Header().Set("Sunset", ctx.V1Sunset.UTC().Format(http.TimeFormat))
+		}
+	}
+	h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), versionKey{}, v)))
+}
+
+// decodeUser reads the user in the body of a request, in the version the request is served in
+func decodeUser(req *http.Request) (u entities.User, err error) {
+	err = decodeBody(req, func(d *json.Decoder) error {
+		u, err = versionOf(req).decodeUser(d)
+		return err
+	})
+	return u, err
+}
+
+// presentUser maps a user to the version a request is served in
+func presentUser(req *http.Request, u entities.User) interface{} {
+	return versionOf(req).encodeUser(u)
+}
+
+// presentUsers maps users to the version a request is served in
+func presentUsers(req *http.Request, list []entities.User) []interface{} {
+	v := versionOf(req)
+	users := make([]interface{}, len(list))
+	for i, u := range list {
+		users[i] = v.encodeUser(u)
+	}
+	return users
+}
+
+// document adapts the docs of a route of versionedRoutes to version v
+func (v *apiVersion) document(d apiDoc) apiDoc {
+	d.id += strings.ToUpper(v.name[:1]) + v.name[1:]
+	d.deprecated = v.deprecated
+	if d.body != nil {
+		body := *d.body
+		if _, ok := body.value.(entities.User); ok {
+			body.value = v.user
+			if len(body.required) > 0 {
+				body.required = v.required
+			}
+		}
+		d.body = &body
+	}
+	responses := make(map[int]interface{}, len(d.responses))
+	for code, value := range d.responses {
+		switch value := value.(type) {
+		case entities.User:
+			responses[code] = v.user
+		case listOf:
+			if _, ok := value.item.(entities.User); ok {
+				responses[code] = listOf{value.key, v.user}
+				continue
+			}
+			responses[code] = value
+		default:
+			responses[code] = value
+		}
+	}
+	d.responses = responses
+	return d
+}
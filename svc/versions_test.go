@@ -0,0 +1,94 @@
+package svc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionedRoutes(t *testing.T) {
+	ctx := NewContext()
+	defer ctx.Close()
+	ctx.V1Sunset = time.Date(2027, 6, 30, 0, 0, 0, 0, time.UTC)
+	router := NewRouter(ctx)
+	serve := func(method, target, accept, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/v2/users/1", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.JSONEq(t, `{"id":1,"name":{"first":"Jane","last":"Doe"},"birth":{"date":"1992-01-01T00:00:00Z","location":"Milton Keynes"}}`, w.Body.String())
+	assert.Equal(t, "", w.Header().Get("Deprecation"), "they should be equal")
+
+	w = serve("GET", "/v1/users/1", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.JSONEq(t, `{"id":1,"firstName":"Jane","lastName":"Doe","dateOfBirth":"1992-01-01T00:00:00Z","locationOfBirth":"Milton Keynes"}`, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("Deprecation"), "they should be equal")
+	assert.Equal(t, "Wed, 30 Jun 2027 00:00:00 GMT", w.Header().Get("Sunset"), "they should be equal")
+	assert.Equal(t, `</v2/users/1>; rel="successor-version"`, w.Header().Get("Link"), "they should be equal")
+
+	// without a prefix the version is taken from the Accept header, v1 by default
+	w = serve("GET", "/users", "", "")
+	assert.Contains(t, w.Body.String(), `"firstName":"Jane"`)
+	assert.Equal(t, `</v2/users>; rel="successor-version"`, w.Header().Get("Link"), "they should be equal")
+	w = serve("GET", "/users", "application/vnd.go-rest-api-template.v2+json", "")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `"name":{"first":"Jane","last":"Doe"}`)
+	assert.Equal(t, "", w.Header().Get("Deprecation"), "they should be equal")
+	w = serve("GET", "/users", "application/vnd.go-rest-api-template.v9+json", "")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code, "they should be equal")
+
+	// other representations name their elements after the resource, whatever the version
+	w = serve("GET", "/v2/users/1", "application/xml", "")
+	assert.Contains(t, w.Body.String(), "<user>\n  <id>1</id>\n  <name>")
+
+	// bodies are read in the version too
+	w = serve("POST", "/v2/users", "", `{"name":{"first":"Apple","last":"Jack"},"birth":{"location":"Ponyville"}}`)
+	assert.Equal(t, http.StatusCreated, w.Code, "they should be equal")
+	var created userV2
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, "Ponyville", created.Birth.Location, "they should be equal")
+	w = serve("GET", "/v1/users/2", "", "")
+	assert.Contains(t, w.Body.String(), `"firstName":"Apple"`)
+	w = serve("PUT", "/users/2", "application/vnd.go-rest-api-template.v2+json", `{"id":2,"name":{"first":"Apple","last":"Bloom"}}`)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `"last":"Bloom"`)
+	w = serve("POST", "/v2/users", "", `{"firstName":"Apple","lastName":"Jack"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `{"pointer":"/name","message":"is required"}`)
+
+	// routes without users are the same in every version
+	w = serve("GET", "/v2/passports", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	w = serve("GET", "/v2/health", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "they should be equal")
+}
+
+func TestVersionedOpenAPI(t *testing.T) {
+	doc, err := buildOpenAPI("0.0.0", allRoutes())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	op := doc.Paths["/v2/users/{uid}"]["get"]
+	assert.Equal(t, "getUserV2", op.OperationID, "they should be equal")
+	assert.Equal(t, false, op.Deprecated, "they should be equal")
+	assert.Equal(t, "#/components/schemas/UserV2", op.Responses["200"].Content["application/json"].Schema.Ref, "they should be equal")
+	assert.Equal(t, []string{"name"}, doc.Paths["/v2/users"]["post"].RequestBody.Content["application/json"].Schema.Required, "they should be equal")
+
+	op = doc.Paths["/v1/users/{uid}"]["get"]
+	assert.Equal(t, "getUserV1", op.OperationID, "they should be equal")
+	assert.Equal(t, true, op.Deprecated, "they should be equal")
+	assert.Equal(t, "#/components/schemas/User", op.Responses["200"].Content["application/json"].Schema.Ref, "they should be equal")
+	assert.Equal(t, "getUser", doc.Paths["/users/{uid}"]["get"].OperationID, "they should be equal")
+}
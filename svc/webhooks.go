@@ -0,0 +1,113 @@
+package svc
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/kostiamol/go-rest-api-template/tenant"
+	"github.com/kostiamol/go-rest-api-template/webhook"
+)
+
+// webhooks holds the map with the list of webhook subscriptions and their quantity
+// swagger:response webhooks
+type webhooks map[string]interface{}
+
+// deadLetters holds the map with the list of undeliverable events and their quantity
+// swagger:response deadLetters
+type deadLetters map[string]interface{}
+
+// CreateWebhookHandler subscribes a URL to change events
+func CreateWebhookHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route POST /webhooks webhooks createWebhook
+	//
+	// Subscribes to events.
+	//
+	// Events of the given types, or all events if none are given, will be posted to the url
+	// and signed with the secret in the X-Webhook-Signature header. A secret is generated
+	// when none is given; it is only returned in this response.
+	//
+	//     Responses:
+	//       201: subscription
+	//       400: status
+
+	var s webhook.Subscription
+	err := decodeJSON(req, &s)
+	if err != nil {
+		readFailed(w, req, ctx, err, "malformed webhook object")
+		return
+	}
+	s.Tenant = tenant.FromContext(req.Context())
+	s, err = ctx.Webhooks.Subscribe(s)
+	if err != nil {
+		response := status{
+			Status:  "400",
+			Message: errorMessage(err),
+		}
+		respond(w, req, ctx, http.StatusBadRequest, response)
+		return
+	}
+	respond(w, req, ctx, http.StatusCreated, s)
+}
+
+// ListWebhooksHandler returns the webhook subscriptions
+func ListWebhooksHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /webhooks webhooks listWebhooks
+	//
+	// Lists webhooks.
+	//
+	// This will show the webhook subscriptions of the tenant, without their secrets.
+	//
+	//     Responses:
+	//       200: webhooks
+
+	list := ctx.Webhooks.Subscriptions(tenant.FromContext(req.Context()))
+	responseObject := webhooks(make(map[string]interface{}))
+	responseObject["webhooks"] = list
+	responseObject["count"] = len(list)
+	respond(w, req, ctx, http.StatusOK, responseObject)
+}
+
+// DeleteWebhookHandler removes a webhook subscription
+func DeleteWebhookHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route DELETE /webhooks/{wid} webhooks deleteWebhook
+	//
+	// Deletes the webhook.
+	//
+	// No more events will be delivered to the webhook.
+	//
+	//     Responses:
+	//       204: status
+	//       404: status
+
+	vars := mux.Vars(req)
+	err := ctx.Webhooks.Unsubscribe(tenant.FromContext(req.Context()), vars["wid"])
+	if err != nil {
+		response := status{
+			Status:  "404",
+			Message: "can't find webhook",
+		}
+		log.Println(err)
+		respond(w, req, ctx, http.StatusNotFound, response)
+		return
+	}
+	respond(w, req, ctx, http.StatusNoContent, status{})
+}
+
+// ListDeadLettersHandler returns the events that couldn't be delivered
+func ListDeadLettersHandler(w http.ResponseWriter, req *http.Request, ctx Context) {
+	// swagger:route GET /webhooks/deadletters webhooks listDeadLetters
+	//
+	// Lists undeliverable events.
+	//
+	// This will show the events that couldn't be delivered to a webhook after all retries.
+	//
+	//     Responses:
+	//       200: deadLetters
+
+	list := ctx.Webhooks.DeadLetters(tenant.FromContext(req.Context()))
+	responseObject := deadLetters(make(map[string]interface{}))
+	responseObject["deadLetters"] = list
+	responseObject["count"] = len(list)
+	respond(w, req, ctx, http.StatusOK, responseObject)
+}
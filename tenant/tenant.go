@@ -0,0 +1,214 @@
+package tenant
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// Default is the tenant of requests that don't name one
+const Default = "default"
+
+// Header names the tenant of a request
+const Header = "X-Tenant-ID"
+
+// EcodeInvalidToken marks the errors of bearer tokens that can't be trusted
+const EcodeInvalidToken = stacktrace.ErrorCode(1)
+
+// EcodeUnverified marks the errors of tenants named by sources the resolver doesn't trust alone
+const EcodeUnverified = stacktrace.ErrorCode(2)
+
+// EcodeUnknown marks the errors of tenants the service doesn't serve
+const EcodeUnknown = stacktrace.ErrorCode(3)
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Valid reports whether id is a well-formed tenant id: lower case letters, digits and dashes
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the tenant id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant carried by ctx, Default if there is none
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok {
+		return id
+	}
+	return Default
+}
+
+type identityKey struct{}
+
+// Identity is the caller authenticated by a verified client certificate
+type Identity struct {
+	// Common name of the subject of the certificate
+	Name string
+	// Tenant of the caller, the first organization of the subject
+	Tenant string
+}
+
+// ClientIdentity returns the identity of the client certificate of a request, if the server
+// verified one
+func ClientIdentity(req *http.Request) (Identity, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return Identity{}, false
+	}
+	subject := req.TLS.VerifiedChains[0][0].Subject
+	id := Identity{Name: subject.CommonName}
+	if len(subject.Organization) > 0 {
+		id.Tenant = subject.Organization[0]
+	}
+	return id, true
+}
+
+// NewIdentityContext returns a context carrying the identity of the caller
+func NewIdentityContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity of the caller carried by ctx, if any
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Resolver finds the tenant of a request in, by order of trust, the organization of a verified
+// client certificate, the claim of a bearer JWT, the X-Tenant-ID header and the subdomain of the
+// host. Sources that disagree are an error.
+type Resolver struct {
+	// Verified only takes the tenant from a verified client certificate or bearer token; the
+	// header and the subdomain may then only repeat it
+	Verified bool
+	// Secret verifies the HS256 signature of bearer tokens; tokens are ignored when empty
+	Secret []byte
+	// Claim holding the tenant in tokens, "tenant" by default
+	Claim string
+	// Domain under which tenants have their subdomain, e.g. api.example.com for
+	// acme.api.example.com; subdomains are ignored when empty
+	Domain string
+}
+
+// Resolve returns the tenant of a request, Default if it names none
+func (r Resolver) Resolve(req *http.Request) (string, error) {
+	var found []string
+	if id, ok := ClientIdentity(req); ok && id.Tenant != "" {
+		found = append(found, id.Tenant)
+	}
+	if len(r.Secret) > 0 {
+		if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			id, err := r.tokenTenant(strings.TrimPrefix(auth, "Bearer "))
+			if err != nil {
+				return "", err
+			}
+			if id != "" {
+				found = append(found, id)
+			}
+		}
+	}
+	verified := len(found) > 0
+	if id := req.Header.Get(Header); id != "" {
+		found = append(found, id)
+	}
+	if id := r.subdomain(req.Host); id != "" {
+		found = append(found, id)
+	}
+	if len(found) == 0 {
+		return Default, nil
+	}
+	if r.Verified && !verified && found[0] != Default {
+		return "", stacktrace.NewErrorWithCode(EcodeUnverified, "tenant %s must be named by a client certificate or bearer token", found[0])
+	}
+	for _, id := range found[1:] {
+		if id != found[0] {
+			return "", stacktrace.NewError("conflicting tenants %s and %s", found[0], id)
+		}
+	}
+	if !Valid(found[0]) {
+		return "", stacktrace.NewError("invalid tenant id %q", found[0])
+	}
+	return found[0], nil
+}
+
+func (r Resolver) subdomain(host string) string {
+	if r.Domain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	suffix := "." + strings.ToLower(r.Domain)
+	if !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+	sub := strings.TrimSuffix(host, suffix)
+	if strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// tokenTenant verifies a HS256 JWT and returns its tenant claim
+func (r Resolver) tokenTenant(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", stacktrace.NewErrorWithCode(EcodeInvalidToken, "malformed bearer token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return "", stacktrace.NewErrorWithCode(EcodeInvalidToken, "bearer token must be signed with HS256")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, Sign(r.Secret, parts[0]+"."+parts[1])) {
+		return "", stacktrace.NewErrorWithCode(EcodeInvalidToken, "invalid bearer token signature")
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", stacktrace.NewErrorWithCode(EcodeInvalidToken, "malformed bearer token claims")
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return "", stacktrace.NewErrorWithCode(EcodeInvalidToken, "bearer token has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return "", stacktrace.NewErrorWithCode(EcodeInvalidToken, "bearer token is not valid yet")
+	}
+	claim := r.Claim
+	if claim == "" {
+		claim = "tenant"
+	}
+	id, _ := claims[claim].(string)
+	return id, nil
+}
+
+// Sign returns the HS256 signature of the signing input of a JWT
+func Sign(secret []byte, input string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,137 @@
+package tenant
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/stretchr/testify/assert"
+)
+
+var secret = []byte("s3cr3t")
+
+func token(claims string) string {
+	input := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return input + "." + base64.RawURLEncoding.EncodeToString(Sign(secret, input))
+}
+
+func resolve(r Resolver, host string, headers map[string]string) (string, error) {
+	req, _ := http.NewRequest("GET", "/users", nil)
+	req.Host = host
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return r.Resolve(req)
+}
+
+func TestResolve(t *testing.T) {
+	r := Resolver{Secret: secret, Domain: "api.example.com"}
+	for _, c := range []struct {
+		host    string
+		headers map[string]string
+		tenant  string
+	}{
+		{"localhost:3001", nil, Default},
+		{"localhost:3001", map[string]string{Header: "acme"}, "acme"},
+		{"acme.api.example.com:443", nil, "acme"},
+		{"api.example.com", map[string]string{"Authorization": "Bearer " + token(`{"tenant":"acme"}`)}, "acme"},
+		{"acme.api.example.com", map[string]string{Header: "acme", "Authorization": "Bearer " + token(`{"tenant":"acme"}`)}, "acme"},
+		{"x.acme.api.example.com", nil, Default},
+	} {
+		id, err := resolve(r, c.host, c.headers)
+		assert.Nil(t, err)
+		assert.Equal(t, c.tenant, id, "%s %v", c.host, c.headers)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	r := Resolver{Secret: secret, Domain: "api.example.com"}
+	_, err := resolve(r, "globex.api.example.com", map[string]string{Header: "acme"})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "conflicting tenants acme and globex", "they should be equal")
+	}
+	_, err = resolve(r, "localhost", map[string]string{Header: "Acme Corp"})
+	assert.NotNil(t, err)
+
+	exp := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	for _, bearer := range []string{
+		"not.a-token",
+		token(`{"tenant":"acme"}`) + "x",
+		token(`{"tenant":"acme","exp":` + exp + `}`),
+	} {
+		_, err = resolve(r, "localhost", map[string]string{"Authorization": "Bearer " + bearer})
+		assert.Equal(t, EcodeInvalidToken, stacktrace.GetCode(err), bearer)
+	}
+
+	// tokens aren't looked at without a secret
+	id, err := resolve(Resolver{}, "localhost", map[string]string{"Authorization": "Bearer whatever"})
+	assert.Nil(t, err)
+	assert.Equal(t, Default, id, "they should be equal")
+}
+
+func TestResolveVerified(t *testing.T) {
+	r := Resolver{Verified: true, Secret: secret, Domain: "api.example.com"}
+	for _, c := range []struct {
+		host    string
+		headers map[string]string
+		tenant  string
+	}{
+		{"localhost:3001", nil, Default},
+		{"localhost:3001", map[string]string{Header: Default}, Default},
+		{"api.example.com", map[string]string{"Authorization": "Bearer " + token(`{"tenant":"acme"}`)}, "acme"},
+		{"acme.api.example.com", map[string]string{Header: "acme", "Authorization": "Bearer " + token(`{"tenant":"acme"}`)}, "acme"},
+	} {
+		id, err := resolve(r, c.host, c.headers)
+		assert.Nil(t, err)
+		assert.Equal(t, c.tenant, id, "%s %v", c.host, c.headers)
+	}
+
+	// the header and the subdomain alone aren't trusted
+	_, err := resolve(r, "localhost", map[string]string{Header: "acme"})
+	assert.Equal(t, EcodeUnverified, stacktrace.GetCode(err), "they should be equal")
+	_, err = resolve(r, "acme.api.example.com", nil)
+	assert.Equal(t, EcodeUnverified, stacktrace.GetCode(err), "they should be equal")
+	_, err = resolve(r, "localhost", map[string]string{Header: "acme", "Authorization": "Bearer " + token(`{"sub":"billing"}`)})
+	assert.Equal(t, EcodeUnverified, stacktrace.GetCode(err), "a token without a tenant doesn't vouch for one")
+}
+
+func TestContext(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	assert.Equal(t, Default, FromContext(req.Context()), "they should be equal")
+	assert.Equal(t, "acme", FromContext(NewContext(req.Context(), "acme")), "they should be equal")
+}
+
+func TestClientIdentity(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	_, ok := ClientIdentity(req)
+	assert.False(t, ok)
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "billing", Organization: []string{"acme"}}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	id, ok := ClientIdentity(req)
+	assert.True(t, ok)
+	assert.Equal(t, Identity{Name: "billing", Tenant: "acme"}, id, "they should be equal")
+
+	tenant, err := Resolver{}.Resolve(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", tenant, "they should be equal")
+	req.Header.Set(Header, "globex")
+	_, err = Resolver{}.Resolve(req)
+	assert.NotNil(t, err)
+
+	// certificates that weren't verified name no one
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, ok = ClientIdentity(req)
+	assert.False(t, ok)
+
+	found, ok := IdentityFromContext(NewIdentityContext(req.Context(), id))
+	assert.True(t, ok)
+	assert.Equal(t, id, found, "they should be equal")
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/palantir/stacktrace"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, which isn't reachable from the internet
+// either
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// internal reports whether deliveries to an address would reach the service's own network rather
+// than a subscriber: loopback, link-local, which holds cloud metadata endpoints, private and
+// unspecified addresses
+func internal(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsPrivate() || addr.IsUnspecified() || sharedAddressSpace.Contains(addr)
+}
+
+// refuseInternal is a dialer control refusing connections to internal addresses. It runs once the
+// host name is resolved, so that names resolving to internal addresses are refused too.
+func refuseInternal(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if internal(addr) {
+		return stacktrace.NewError("webhook address %s is internal", addr)
+	}
+	return nil
+}
+
+// internalHost reports whether a URL host names an internal address without resolving it
+func internalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && internal(addr)
+}
+
+// newClient returns the client of deliveries, which refuses internal addresses unless
+// allowInternal is set, ignores proxies, as they would be dialed instead of the subscriber, and
+// doesn't follow redirects: a redirect is a failed delivery.
+func newClient(allowInternal bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowInternal {
+		dialer.Control = refuseInternal
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
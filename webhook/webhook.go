@@ -0,0 +1,364 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/palantir/stacktrace"
+)
+
+// SignatureHeader carries the signature of a delivery, t=<unix time>,v1=<hex HMAC-SHA256>, the
+// HMAC being that of the time, a dot and the request body, keyed with the subscription secret
+const SignatureHeader = "X-Webhook-Signature"
+
+// DefaultTolerance is how far from the present receivers should accept the time of a signature
+// by default. Deliveries are signed anew on every attempt, so only replays are older.
+const DefaultTolerance = 5 * time.Minute
+
+// Subscription registers a URL to receive events
+// swagger:response subscription
+type Subscription struct {
+	// Subscription id
+	ID string `json:"id"`
+	// URL the events are posted to
+	URL string `json:"url"`
+	// Secret used to sign deliveries, only returned when the subscription is created
+	Secret string `json:"secret,omitempty"`
+	// Event types to deliver, all events when empty
+	Events []string `json:"events,omitempty"`
+	// Tenant whose events are delivered, set by the service
+	Tenant string `json:"-"`
+}
+
+// wants reports whether the subscription receives an event
+func (s Subscription) wants(e entities.Event) bool {
+	if s.Tenant != e.Tenant {
+		return false
+	}
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, t := range s.Events {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter is an event that couldn't be delivered to a subscription
+type DeadLetter struct {
+	Subscription string         `json:"subscription"`
+	Event        entities.Event `json:"event"`
+	Attempts     int            `json:"attempts"`
+	LastError    string         `json:"lastError"`
+	FailedAt     time.Time      `json:"failedAt"`
+}
+
+// Options configures a Dispatcher; zero values are replaced by defaults
+type Options struct {
+	// Number of concurrent deliveries
+	Workers int
+	// Deliveries are dead-lettered after this many failed attempts
+	MaxAttempts int
+	// Delay before the first retry, doubled for every further retry
+	InitialBackoff time.Duration
+	// Upper bound of the delay between retries
+	MaxBackoff time.Duration
+	// Pending deliveries beyond this are dead-lettered straight away
+	QueueSize int
+	// Dead letters kept per tenant, the oldest are dropped beyond this
+	MaxDeadLetters int
+	// Deliver to loopback, link-local and private addresses, as local setups and tests do
+	AllowInternal bool
+	// Client used for deliveries, one refusing internal addresses and redirects by default
+	Client *http.Client
+}
+
+type delivery struct {
+	subscription Subscription
+	event        entities.Event
+	body         []byte
+	attempt      int
+}
+
+// Dispatcher delivers events to the subscribed URLs asynchronously, retrying failed deliveries
+// with exponential backoff and keeping those that never succeed in a dead-letter list
+type Dispatcher struct {
+	opts          Options
+	mu            sync.RWMutex
+	subscriptions map[string]Subscription
+	deadLetters   map[string][]DeadLetter
+	queue         chan delivery
+	closed        chan struct{}
+	closeOnce     sync.Once
+	wg            sync.WaitGroup
+}
+
+// NewDispatcher starts a dispatcher with its delivery workers
+func NewDispatcher(opts Options) *Dispatcher {
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Minute
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.MaxDeadLetters <= 0 {
+		opts.MaxDeadLetters = 1000
+	}
+	if opts.Client == nil {
+		opts.Client = newClient(opts.AllowInternal)
+	}
+	d := &Dispatcher{
+		opts:          opts,
+		subscriptions: make(map[string]Subscription),
+		deadLetters:   make(map[string][]DeadLetter),
+		queue:         make(chan delivery, opts.QueueSize),
+		closed:        make(chan struct{}),
+	}
+	for i := 0; i < opts.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Subscribe registers a subscription, generating its id and, if none is given, its secret. URLs
+// naming internal addresses are refused unless the options allow them; those whose host name
+// resolves to one are refused when delivering.
+func (d *Dispatcher) Subscribe(s Subscription) (Subscription, error) {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return s, stacktrace.NewError("webhook url must be an absolute http(s) url")
+	}
+	if !d.opts.AllowInternal && internalHost(u.Hostname()) {
+		return s, stacktrace.NewError("webhook url must not name an internal address")
+	}
+	s.ID = randomHex(8)
+	if s.Secret == "" {
+		s.Secret = randomHex(32)
+	}
+	d.mu.Lock()
+	d.subscriptions[s.ID] = s
+	d.mu.Unlock()
+	return s, nil
+}
+
+// Unsubscribe removes a subscription of a tenant
+func (d *Dispatcher) Unsubscribe(tenant, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s, ok := d.subscriptions[id]; !ok || s.Tenant != tenant {
+		return stacktrace.NewError("Failure trying to delete webhook")
+	}
+	delete(d.subscriptions, id)
+	return nil
+}
+
+// Subscriptions lists the subscriptions of a tenant without their secrets
+func (d *Dispatcher) Subscriptions(tenant string) []Subscription {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	list := []Subscription{}
+	for _, s := range d.subscriptions {
+		if s.Tenant != tenant {
+			continue
+		}
+		s.Secret = ""
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// DeadLetters lists the latest deliveries to a tenant that failed for good, see
+// Options.MaxDeadLetters
+func (d *Dispatcher) DeadLetters(tenant string) []DeadLetter {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]DeadLetter{}, d.deadLetters[tenant]...)
+}
+
+// Publish queues an event for delivery to every interested subscription. It never blocks.
+func (d *Dispatcher) Publish(e entities.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return stacktrace.Propagate(err, "can't encode event %s", e.ID)
+	}
+	d.mu.RLock()
+	var targets []Subscription
+	for _, s := range d.subscriptions {
+		if s.wants(e) {
+			targets = append(targets, s)
+		}
+	}
+	d.mu.RUnlock()
+	for _, s := range targets {
+		d.enqueue(delivery{subscription: s, event: e, body: body})
+	}
+	return nil
+}
+
+// Close stops the workers; deliveries still pending or waiting for a retry are dropped
+func (d *Dispatcher) Close() {
+	d.closeOnce.Do(func() { close(d.closed) })
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) enqueue(job delivery) {
+	select {
+	case <-d.closed:
+	case d.queue <- job:
+	default:
+		d.deadLetter(job, "delivery queue is full")
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.closed:
+			return
+		case job := <-d.queue:
+			d.deliver(job)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(job delivery) {
+	job.attempt++
+	err := d.post(job)
+	if err == nil {
+		return
+	}
+	if job.attempt >= d.opts.MaxAttempts {
+		d.deadLetter(job, err.Error())
+		return
+	}
+	time.AfterFunc(d.backoff(job.attempt), func() { d.enqueue(job) })
+}
+
+func (d *Dispatcher) post(job delivery) error {
+	req, err := http.NewRequest("POST", job.subscription.URL, bytes.NewReader(job.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", job.subscription.ID)
+	req.Header.Set("X-Event-Id", job.event.ID)
+	req.Header.Set("X-Event-Type", job.event.Type)
+	req.Header.Set(SignatureHeader, Sign(job.subscription.Secret, time.Now(), job.body))
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// backoff returns the delay before the given retry: exponential, capped and with up to 20% jitter
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.opts.MaxBackoff
+	if shifted := d.opts.InitialBackoff << uint(attempt-1); attempt < 32 && shifted > 0 && shifted < delay {
+		delay = shifted
+	}
+	return delay - time.Duration(mrand.Int63n(int64(delay)/5+1))
+}
+
+func (d *Dispatcher) deadLetter(job delivery, reason string) {
+	log.Println("webhook " + job.subscription.ID + ": giving up on event " + job.event.ID + ": " + reason)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	letters := append(d.deadLetters[job.event.Tenant], DeadLetter{
+		Subscription: job.subscription.ID,
+		Event:        job.event,
+		Attempts:     job.attempt,
+		LastError:    reason,
+		FailedAt:     time.Now().UTC(),
+	})
+	if len(letters) > d.opts.MaxDeadLetters {
+		n := copy(letters, letters[len(letters)-d.opts.MaxDeadLetters:])
+		letters = letters[:n]
+	}
+	d.deadLetters[job.event.Tenant] = letters
+}
+
+// Sign returns the signature header value of a delivery body sent at t
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header value in constant time, for use by receivers. The signature
+// must have been made within tolerance of the present, so that captured deliveries can't be
+// replayed later; DefaultTolerance suits most receivers.
+func Verify(secret string, body []byte, header string, tolerance time.Duration) bool {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return false
+	}
+	expected := signature(secret, timestamp, body)
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(expected), []byte(sig)) {
+			return true
+		}
+	}
+	return false
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,213 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kostiamol/go-rest-api-template/entities"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver is a webhook endpoint failing the first failures deliveries
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	events   []entities.Event
+	valid    bool
+	secret   string
+	done     chan struct{}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.calls <= r.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var e entities.Event
+	json.Unmarshal(body, &e)
+	r.events = append(r.events, e)
+	r.valid = Verify(r.secret, body, req.Header.Get(SignatureHeader), DefaultTolerance)
+	close(r.done)
+}
+
+func testOptions() Options {
+	return Options{Workers: 1, MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, AllowInternal: true}
+}
+
+func TestDeliveryIsSignedAndRetried(t *testing.T) {
+	r := &receiver{failures: 2, secret: "s3cr3t", done: make(chan struct{})}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	d := NewDispatcher(testOptions())
+	defer d.Close()
+	_, err := d.Subscribe(Subscription{URL: srv.URL, Secret: "s3cr3t", Events: []string{entities.UserCreated}})
+	assert.Nil(t, err)
+	d.Publish(entities.Event{ID: "1", Type: entities.UserDeleted})
+	d.Publish(entities.Event{ID: "2", Type: entities.UserCreated})
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assert.Equal(t, 3, r.calls, "two failures and a success")
+	assert.Equal(t, "2", r.events[0].ID, "only subscribed events are delivered")
+	assert.True(t, r.valid, "the signature should match the body")
+	assert.Equal(t, 0, len(d.DeadLetters("")), "they should be equal")
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := time.Now()
+	assert.True(t, Verify("s3cr3t", body, Sign("s3cr3t", now, body), DefaultTolerance))
+	assert.False(t, Verify("other", body, Sign("s3cr3t", now, body), DefaultTolerance), "the secret should matter")
+	assert.False(t, Verify("s3cr3t", []byte(`{"id":"2"}`), Sign("s3cr3t", now, body), DefaultTolerance), "the body should matter")
+	assert.False(t, Verify("s3cr3t", body, Sign("s3cr3t", now.Add(-10*time.Minute), body), DefaultTolerance), "old signatures are replays")
+
+	// the time is signed too, so it can't be moved forward to replay a delivery
+	old := Sign("s3cr3t", now.Add(-10*time.Minute), body)
+	forged := fmt.Sprintf("t=%d,%s", now.Unix(), strings.SplitN(old, ",", 2)[1])
+	assert.False(t, Verify("s3cr3t", body, forged, DefaultTolerance), "the time should matter")
+	assert.False(t, Verify("s3cr3t", body, "sha256=abc", DefaultTolerance), "signatures without a time should be refused")
+}
+
+func TestDeadLetter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	d := NewDispatcher(testOptions())
+	defer d.Close()
+	s, _ := d.Subscribe(Subscription{URL: srv.URL})
+	d.Publish(entities.Event{ID: "1", Type: entities.UserCreated})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.DeadLetters("")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	letters := d.DeadLetters("")
+	if assert.Equal(t, 1, len(letters)) {
+		assert.Equal(t, s.ID, letters[0].Subscription, "they should be equal")
+		assert.Equal(t, 3, letters[0].Attempts, "they should be equal")
+		assert.Equal(t, "webhook responded with status 500", letters[0].LastError, "they should be equal")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	d := NewDispatcher(testOptions())
+	defer d.Close()
+	_, err := d.Subscribe(Subscription{URL: "not a url"})
+	assert.NotNil(t, err)
+	s, err := d.Subscribe(Subscription{URL: "https://example.com/hook"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, s.Secret, "a secret should be generated")
+	list := d.Subscriptions("")
+	if assert.Equal(t, 1, len(list)) {
+		assert.Empty(t, list[0].Secret, "secrets should not be listed")
+	}
+	assert.Nil(t, d.Unsubscribe("", s.ID))
+	assert.NotNil(t, d.Unsubscribe("", s.ID))
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(Options{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second})
+	defer d.Close()
+	for attempt, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second, 64: 10 * time.Second} {
+		delay := d.backoff(attempt)
+		assert.True(t, delay <= max && delay >= max*4/5, "attempt %d: %s", attempt, delay)
+	}
+}
+
+func TestInternalAddressesAreRefused(t *testing.T) {
+	for addr, refused := range map[string]bool{
+		"127.0.0.1":        true,
+		"::1":              true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"fd00::1":          true,
+		"fe80::1":          true,
+		"::ffff:127.0.0.1": true,
+		"93.184.216.34":    false,
+		"2606:4700::1111":  false,
+	} {
+		assert.Equal(t, refused, internal(netip.MustParseAddr(addr)), addr)
+	}
+
+	d := NewDispatcher(Options{})
+	defer d.Close()
+	for _, u := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://[::1]/hook", "http://169.254.169.254/latest/meta-data"} {
+		_, err := d.Subscribe(Subscription{URL: u})
+		assert.NotNil(t, err, u)
+	}
+	_, err := d.Subscribe(Subscription{URL: "https://example.com/hook"})
+	assert.Nil(t, err)
+
+	// host names are checked once resolved
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+	_, err = newClient(false).Get(srv.URL)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "is internal")
+	}
+	res, err := newClient(true).Get(srv.URL)
+	if assert.Nil(t, err) {
+		res.Body.Close()
+	}
+}
+
+func TestRedirectsAreNotFollowed(t *testing.T) {
+	target := &receiver{done: make(chan struct{})}
+	dst := httptest.NewServer(target)
+	defer dst.Close()
+	srv := httptest.NewServer(http.RedirectHandler(dst.URL, http.StatusTemporaryRedirect))
+	defer srv.Close()
+	d := NewDispatcher(testOptions())
+	defer d.Close()
+	d.Subscribe(Subscription{URL: srv.URL})
+	d.Publish(entities.Event{ID: "1", Type: entities.UserCreated})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(d.DeadLetters("")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	letters := d.DeadLetters("")
+	if assert.Equal(t, 1, len(letters)) {
+		assert.Equal(t, "webhook responded with status 307", letters[0].LastError, "they should be equal")
+	}
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	assert.Equal(t, 0, target.calls, "they should be equal")
+}
+
+func TestDeadLettersAreCapped(t *testing.T) {
+	opts := testOptions()
+	opts.MaxDeadLetters = 2
+	d := NewDispatcher(opts)
+	defer d.Close()
+	for _, id := range []string{"1", "2", "3"} {
+		d.deadLetter(delivery{event: entities.Event{ID: id, Tenant: "acme"}}, "gone")
+	}
+	d.deadLetter(delivery{event: entities.Event{ID: "4", Tenant: "globex"}}, "gone")
+	letters := d.DeadLetters("acme")
+	if assert.Equal(t, 2, len(letters)) {
+		assert.Equal(t, "2", letters[0].Event.ID, "the oldest are dropped")
+		assert.Equal(t, "3", letters[1].Event.ID, "they should be equal")
+	}
+	assert.Equal(t, 1, len(d.DeadLetters("globex")), "they should be equal")
+}